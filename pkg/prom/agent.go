@@ -36,6 +36,7 @@ var DefaultConfig = Config{
 type Config struct {
 	Global                 instance.GlobalConfig `yaml:"global,omitempty"`
 	WALDir                 string                `yaml:"wal_directory,omitempty"`
+	SkipWALDirectoryCheck  bool                  `yaml:"skip_wal_directory_check,omitempty"`
 	WALCleanupAge          time.Duration         `yaml:"wal_cleanup_age,omitempty"`
 	WALCleanupPeriod       time.Duration         `yaml:"wal_cleanup_period,omitempty"`
 	ServiceConfig          cluster.Config        `yaml:"scraping_service,omitempty"`
@@ -98,6 +99,7 @@ func (c *Config) ApplyDefaults() error {
 // RegisterFlags defines flags corresponding to the Config.
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.WALDir, "prometheus.wal-directory", "", "base directory to store the WAL in")
+	f.BoolVar(&c.SkipWALDirectoryCheck, "prometheus.skip-wal-directory-check", false, "launch instances without checking that the WAL directory is writable")
 	f.DurationVar(&c.WALCleanupAge, "prometheus.wal-cleanup-age", DefaultConfig.WALCleanupAge, "remove abandoned (unused) WALs older than this")
 	f.DurationVar(&c.WALCleanupPeriod, "prometheus.wal-cleanup-period", DefaultConfig.WALCleanupPeriod, "how often to check for abandoned WALs")
 	f.DurationVar(&c.InstanceRestartBackoff, "prometheus.instance-restart-backoff", DefaultConfig.InstanceRestartBackoff, "how long to wait before restarting a failed Prometheus instance")
@@ -145,9 +147,7 @@ func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact ins
 		actor:           make(chan func(), 1),
 	}
 
-	a.bm = instance.NewBasicManager(instance.BasicManagerConfig{
-		InstanceRestartBackoff: cfg.InstanceRestartBackoff,
-	}, a.logger, a.newInstance)
+	a.bm = instance.NewBasicManager(basicManagerConfig(cfg), a.logger, a.newInstance)
 
 	var err error
 	a.mm, err = instance.NewModalManager(a.reg, a.logger, a.bm, cfg.InstanceMode)
@@ -167,6 +167,17 @@ func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact ins
 	return a, nil
 }
 
+// basicManagerConfig returns the BasicManagerConfig to use for cfg. Settings
+// not controlled by cfg use their defaults from
+// instance.DefaultBasicManagerConfig.
+func basicManagerConfig(cfg Config) instance.BasicManagerConfig {
+	bmc := instance.DefaultBasicManagerConfig
+	bmc.InstanceRestartBackoff = cfg.InstanceRestartBackoff
+	bmc.StorageDirectory = cfg.WALDir
+	bmc.SkipStorageCheck = cfg.SkipWALDirectoryCheck
+	return bmc
+}
+
 // newInstance creates a new Instance given a config.
 func (a *Agent) newInstance(c instance.Config) (instance.ManagedInstance, error) {
 	a.mut.RLock()
@@ -230,9 +241,12 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 		cfg.WALCleanupPeriod,
 	)
 
-	a.bm.UpdateManagerConfig(instance.BasicManagerConfig{
-		InstanceRestartBackoff: cfg.InstanceRestartBackoff,
-	})
+	a.bm.UpdateManagerConfig(basicManagerConfig(cfg))
+	if a.cfg.InstanceRestartBackoff != cfg.InstanceRestartBackoff {
+		// Restart any instances waiting on the old backoff so the new backoff
+		// applies immediately.
+		a.bm.ResetBackoffs()
+	}
 
 	if err := a.mm.SetMode(cfg.InstanceMode); err != nil {
 		return err
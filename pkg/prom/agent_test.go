@@ -111,6 +111,20 @@ configs:
 	require.Greater(t, int64(scrapeConfig.ScrapeInterval), int64(0))
 }
 
+func TestBasicManagerConfig_SkipWALDirectoryCheck(t *testing.T) {
+	cfgText := `
+wal_directory: ./wal
+skip_wal_directory_check: true
+`
+
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(cfgText), &cfg))
+
+	bmc := basicManagerConfig(cfg)
+	require.Equal(t, "./wal", bmc.StorageDirectory)
+	require.True(t, bmc.SkipStorageCheck)
+}
+
 func TestAgent(t *testing.T) {
 	// Lanch two instances
 	cfg := Config{
@@ -284,6 +298,14 @@ func (i *fakeInstance) StorageDirectory() string {
 	return ""
 }
 
+func (i *fakeInstance) StorageSize() (int64, error) {
+	return 0, nil
+}
+
+func (i *fakeInstance) LastScrapeTime() time.Time {
+	return time.Time{}
+}
+
 type fakeInstanceFactory struct {
 	mut   sync.Mutex
 	mocks []*fakeInstance
@@ -221,6 +221,12 @@ func (m *mockConfigManager) ListConfigs() map[string]instance.Config {
 	return args.Get(0).(map[string]instance.Config)
 }
 
+// InstanceStatuses implements Manager.
+func (m *mockConfigManager) InstanceStatuses() map[string]instance.InstanceStatus {
+	args := m.Mock.Called()
+	return args.Get(0).(map[string]instance.InstanceStatus)
+}
+
 // ApplyConfig implements Manager.
 func (m *mockConfigManager) ApplyConfig(c instance.Config) error {
 	args := m.Mock.Called(c)
@@ -153,3 +153,11 @@ func (i *mockInstanceScrape) TargetsActive() map[string][]*scrape.Target {
 func (i *mockInstanceScrape) StorageDirectory() string {
 	return ""
 }
+
+func (i *mockInstanceScrape) StorageSize() (int64, error) {
+	return 0, nil
+}
+
+func (i *mockInstanceScrape) LastScrapeTime() time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Values of the agent_prometheus_manager_circuit_breaker_state metric.
+const (
+	breakerClosed   = 0
+	breakerOpen     = 1
+	breakerHalfOpen = 2
+)
+
+// circuitBreaker stops constructing instances after too many consecutive
+// failures to do so. It's closed while instances can be constructed, opens
+// once CircuitBreakerThreshold consecutive attempts failed, and half-opens
+// after CircuitBreakerCooldown to let a single attempt test whether
+// constructing instances works again.
+type circuitBreaker struct {
+	mut       sync.Mutex
+	state     int
+	failures  int       // Consecutive failures
+	openUntil time.Time // End of the cooldown while open
+	probing   bool      // Set while the attempt let through when half-open runs
+}
+
+// allow returns ErrCircuitOpen if an instance can't be constructed: when the
+// breaker is open and its cooldown hasn't elapsed yet, or when it's half-open
+// and another attempt is already testing it. An open breaker half-opens once
+// its cooldown elapsed, letting the first attempt through. Every allowed
+// attempt must be followed by a call to record.
+func (b *circuitBreaker) allow(logger log.Logger) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		return nil
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return ErrCircuitOpen
+		}
+		level.Info(logger).Log("msg", "circuit breaker cooldown elapsed, allowing an apply to test whether instances can be constructed again")
+		b.setState(breakerHalfOpen)
+	}
+
+	if b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record records the outcome of constructing an instance. The breaker opens
+// when threshold consecutive attempts failed, or when an attempt fails while
+// it's half-open. A successful attempt closes it. The breaker is never opened
+// if threshold is 0.
+func (b *circuitBreaker) record(logger log.Logger, err error, threshold int, cooldown time.Duration) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.probing = false
+	if err == nil {
+		if b.state != breakerClosed {
+			level.Info(logger).Log("msg", "instances can be constructed again, closing circuit breaker")
+		}
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	if threshold <= 0 || (b.state != breakerHalfOpen && b.failures < threshold) {
+		return
+	}
+
+	level.Error(logger).Log("msg", "too many consecutive failures constructing instances, rejecting applies until cooldown elapses", "failures", b.failures, "cooldown", cooldown, "err", err)
+	b.openUntil = time.Now().Add(cooldown)
+	b.setState(breakerOpen)
+}
+
+func (b *circuitBreaker) setState(s int) {
+	b.state = s
+	circuitBreakerState.Set(float64(s))
+}
+
+// launchInstance constructs an instance for c through launch, unless the
+// circuit breaker doesn't allow it, and records the outcome in the circuit
+// breaker.
+func (m *BasicManager) launchInstance(launch Factory, c Config) (ManagedInstance, error) {
+	if err := m.breaker.allow(m.logger); err != nil {
+		return nil, err
+	}
+	inst, err := launch(c)
+
+	cfg := m.ManagerConfig()
+	m.breaker.record(m.logger, err, cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown)
+	return inst, err
+}
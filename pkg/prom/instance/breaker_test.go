@@ -0,0 +1,124 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_CircuitBreaker(t *testing.T) {
+	var (
+		broken   = atomic.NewBool(true)
+		launches = atomic.NewInt64(0)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		launches.Inc()
+		if broken.Load() {
+			return nil, fmt.Errorf("read-only file system")
+		}
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.CircuitBreakerThreshold = 2
+	cfg.CircuitBreakerCooldown = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	breakerState := func() float64 {
+		var m dto.Metric
+		require.NoError(t, circuitBreakerState.Write(&m))
+		return m.GetGauge().GetValue()
+	}
+
+	require.EqualError(t, cm.ApplyConfig(Config{Name: "a"}), "read-only file system")
+	require.EqualError(t, cm.ApplyConfig(Config{Name: "b"}), "read-only file system")
+
+	// The breaker opens after the threshold, rejecting applies without
+	// calling the factory.
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "c"}), ErrCircuitOpen)
+	_, err := cm.PrepareConfig(Config{Name: "c"})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int64(2), launches.Load())
+	require.Equal(t, float64(breakerOpen), breakerState())
+
+	// After the cooldown, a failing apply opens the breaker again right away.
+	time.Sleep(cfg.CircuitBreakerCooldown)
+	require.EqualError(t, cm.ApplyConfig(Config{Name: "c"}), "read-only file system")
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "c"}), ErrCircuitOpen)
+	require.Equal(t, int64(3), launches.Load())
+
+	// A successful apply after the cooldown closes the breaker.
+	broken.Store(false)
+	time.Sleep(cfg.CircuitBreakerCooldown)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "c"}))
+	require.Equal(t, float64(breakerClosed), breakerState())
+	require.NoError(t, cm.ApplyConfig(Config{Name: "d"}))
+}
+
+func TestBasicManager_CircuitBreaker_OnlyGatesFactory(t *testing.T) {
+	var (
+		broken  = atomic.NewBool(false)
+		probing = make(chan struct{})
+		release = make(chan struct{})
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		if c.Name == "probe" {
+			close(probing)
+			<-release
+		}
+		if broken.Load() {
+			return nil, fmt.Errorf("read-only file system")
+		}
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.CircuitBreakerThreshold = 1
+	cfg.CircuitBreakerCooldown = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "running"}))
+	broken.Store(true)
+	require.EqualError(t, cm.ApplyConfig(Config{Name: "a"}), "read-only file system")
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "a"}), ErrCircuitOpen)
+
+	// Dynamic updates don't call the factory and aren't rejected.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "running", Labels: map[string]string{"a": "b"}}))
+
+	// Once half-open, a single call tests the factory while the others are
+	// still rejected.
+	time.Sleep(cfg.CircuitBreakerCooldown)
+	broken.Store(false)
+	probeErr := make(chan error, 1)
+	go func() {
+		_, err := cm.PrepareConfig(Config{Name: "probe"})
+		probeErr <- err
+	}()
+	<-probing
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "a"}), ErrCircuitOpen)
+
+	close(release)
+	require.NoError(t, <-probeErr)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+}
@@ -0,0 +1,49 @@
+package instance
+
+import "go.uber.org/atomic"
+
+// configsCache holds a snapshot of the configs of the managed instances so
+// ListConfigs can be served without locking mut when CacheListConfigs is set.
+//
+// The snapshot is invalidated with mut held whenever an instance is added,
+// removed or has its config changed, and only filled with mut held, so it's
+// never older than the last change.
+type configsCache struct {
+	enabled  atomic.Bool
+	snapshot atomic.Value // map[string]Config, nil when invalidated
+}
+
+// load returns a copy of the cached configs. Returns false if caching is
+// disabled or there's no valid snapshot.
+func (c *configsCache) load() (map[string]Config, bool) {
+	if !c.enabled.Load() {
+		return nil, false
+	}
+	snapshot, _ := c.snapshot.Load().(map[string]Config)
+	if snapshot == nil {
+		return nil, false
+	}
+	return copyConfigs(snapshot), true
+}
+
+// fill caches configs if caching is enabled. mut must be held when calling
+// fill.
+func (c *configsCache) fill(configs map[string]Config) {
+	if c.enabled.Load() {
+		c.snapshot.Store(copyConfigs(configs))
+	}
+}
+
+// invalidate drops the cached snapshot. mut must be held when calling
+// invalidate.
+func (c *configsCache) invalidate() {
+	c.snapshot.Store(map[string]Config(nil))
+}
+
+func copyConfigs(configs map[string]Config) map[string]Config {
+	res := make(map[string]Config, len(configs))
+	for name, c := range configs {
+		res[name] = c
+	}
+	return res
+}
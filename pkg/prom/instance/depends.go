@@ -0,0 +1,96 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// orderByDependencies returns cs sorted so that every config comes after the
+// configs of cs it depends on. Configs otherwise keep their relative order.
+// Configs which can't be ordered because of a dependency cycle are left out
+// and get a CodeValidation error in errs.
+func orderByDependencies(cs []Config, errs map[string]error) []Config {
+	inBatch := make(map[string]bool, len(cs))
+	for _, c := range cs {
+		inBatch[c.Name] = true
+	}
+
+	var (
+		ordered   = make([]Config, 0, len(cs))
+		emitted   = make(map[string]bool, len(cs))
+		remaining = cs
+	)
+	for len(remaining) > 0 {
+		var next []Config
+		for _, c := range remaining {
+			if dependenciesEmitted(c, inBatch, emitted) {
+				ordered = append(ordered, c)
+				emitted[c.Name] = true
+			} else {
+				next = append(next, c)
+			}
+		}
+
+		// Nothing could be emitted, so every remaining config is part of or
+		// depends on a cycle.
+		if len(next) == len(remaining) {
+			names := make([]string, 0, len(next))
+			for _, c := range next {
+				names = append(names, c.Name)
+			}
+			sort.Strings(names)
+
+			err := fmt.Errorf("dependency cycle among configs %s", strings.Join(names, ", "))
+			for _, c := range next {
+				errs[c.Name] = wrapError(c.Name, CodeValidation, err)
+			}
+			break
+		}
+		remaining = next
+	}
+	return ordered
+}
+
+// dependenciesEmitted returns whether all dependencies of c which are part of
+// the batch were emitted.
+func dependenciesEmitted(c Config, inBatch, emitted map[string]bool) bool {
+	for _, dep := range c.DependsOn {
+		if inBatch[dep] && !emitted[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// waitDependencies waits for the dependencies of c to be running, for up to
+// DependencyTimeout. errs holds the errors of the configs applied so far in
+// the batch; dependencies which failed to apply fail c right away. Unknown
+// dependencies fail c with CodeValidation.
+func (m *BasicManager) waitDependencies(c Config, errs map[string]error) error {
+	if len(c.DependsOn) == 0 {
+		return nil
+	}
+
+	for _, dep := range c.DependsOn {
+		if err, failed := errs[dep]; failed {
+			return fmt.Errorf("dependency %s failed to apply: %w", dep, err)
+		}
+		if _, ok := m.Instance(dep); !ok {
+			return wrapError(c.Name, CodeValidation, fmt.Errorf("unknown dependency %s", dep))
+		}
+	}
+
+	timeout := m.ManagerConfig().DependencyTimeout
+	if timeout <= 0 {
+		timeout = DefaultBasicManagerConfig.DependencyTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := m.waitRunning(ctx, c.DependsOn); err != nil {
+		return fmt.Errorf("dependencies of %s are not running: %w", c.Name, err)
+	}
+	return nil
+}
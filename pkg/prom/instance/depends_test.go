@@ -0,0 +1,92 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderByDependencies(t *testing.T) {
+	names := func(cs []Config) []string {
+		res := make([]string, 0, len(cs))
+		for _, c := range cs {
+			res = append(res, c.Name)
+		}
+		return res
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		errs := map[string]error{}
+		ordered := orderByDependencies([]Config{
+			{Name: "c", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"a", "external"}},
+			{Name: "d"},
+			{Name: "a"},
+		}, errs)
+		require.Empty(t, errs)
+		require.Equal(t, []string{"d", "a", "b", "c"}, names(ordered))
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		errs := map[string]error{}
+		ordered := orderByDependencies([]Config{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"a"}},
+			{Name: "c", DependsOn: []string{"c"}},
+			{Name: "d"},
+		}, errs)
+		require.Equal(t, []string{"d"}, names(ordered))
+
+		expect := "dependency cycle among configs a, b, c"
+		require.Len(t, errs, 3)
+		for _, name := range []string{"a", "b", "c"} {
+			require.EqualError(t, errs[name], expect)
+		}
+	})
+}
+
+func TestBasicManager_ApplyConfigs_DependsOn(t *testing.T) {
+	var launched []string
+	spawner := func(c Config) (ManagedInstance, error) {
+		launched = append(launched, c.Name)
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.DependencyTimeout = 200 * time.Millisecond
+
+	// Nothing listens on the probed address, so the instance keeps waiting
+	// for its startup probe.
+	probe := DefaultStartupProbeConfig
+	probe.Address = "127.0.0.1:1"
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	errs := cm.ApplyConfigs([]Config{
+		{Name: "dependent", DependsOn: []string{"dependency"}},
+		{Name: "dependency"},
+		{Name: "on-probed", DependsOn: []string{"probed"}},
+		{Name: "probed", StartupProbe: &probe},
+		{Name: "on-unknown", DependsOn: []string{"unknown"}},
+		{Name: "cycle", DependsOn: []string{"cycle"}},
+	})
+
+	require.Len(t, errs, 3)
+	require.EqualError(t, errs["on-probed"], "dependencies of on-probed are not running: instances not running: probed (waiting_for_dependency): context deadline exceeded")
+	require.EqualError(t, errs["on-unknown"], "unknown dependency unknown")
+	require.EqualError(t, errs["cycle"], "dependency cycle among configs cycle")
+	require.Equal(t, CodeLaunchFailed, ErrorCodeOf(errs["on-probed"]))
+	require.Equal(t, CodeValidation, ErrorCodeOf(errs["on-unknown"]))
+	require.Equal(t, CodeValidation, ErrorCodeOf(errs["cycle"]))
+
+	require.Equal(t, []string{"dependency", "probed", "dependent"}, launched)
+}
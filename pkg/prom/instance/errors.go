@@ -1,6 +1,155 @@
 package instance
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrConfigNotFound is returned when an operation targets a config that is not
+// being managed.
+var ErrConfigNotFound = errors.New("config does not exist")
+
+// ErrManagerStopped is returned when an operation is performed against a
+// manager that has been stopped.
+var ErrManagerStopped = errors.New("manager is stopped")
+
+// ErrSaturated is returned by ApplyConfig when BlockOnSaturation is set and
+// too many instances kept backing off before a restart for the whole
+// SaturationTimeout.
+var ErrSaturated = errors.New("too many instance restarts in flight")
+
+// ErrInstanceLimitReached is returned by ApplyConfig when launching a new
+// instance would go over MaxInstances.
+var ErrInstanceLimitReached = errors.New("maximum number of instances reached")
+
+// ErrPreparedConfigNotFound is returned by CommitConfig when its token
+// doesn't identify a prepared config, for example because it expired.
+var ErrPreparedConfigNotFound = errors.New("prepared config does not exist")
+
+// ErrNoPreviousConfig is returned by Rollback when there is no previous config
+// to roll back to.
+var ErrNoPreviousConfig = errors.New("no previous config to roll back to")
+
+// ErrRolledBack is wrapped by the error returned from ApplyConfigTx when the
+// instance failed during its probation period and its previous config was
+// restored.
+var ErrRolledBack = errors.New("config was rolled back")
+
+// ErrCircuitOpen is returned by ApplyConfig, Rollback and PrepareConfig when
+// they would construct an instance while the circuit breaker of the
+// BasicManager is open after repeated failures to construct instances.
+var ErrCircuitOpen = errors.New("circuit breaker is open after repeated failures to construct instances")
+
+// ErrIdempotencyKeyReused is returned by ApplyConfigWithKey when its key was
+// recently used to apply a different config.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different config")
+
+// ErrorCode classifies the errors returned by the operations of BasicManager.
+type ErrorCode int
+
+// Possible values of ErrorCode.
+const (
+	// CodeNotFound is used when the targeted config, prepared config or
+	// previous config doesn't exist.
+	CodeNotFound ErrorCode = iota + 1
+
+	// CodeValidation is used when the arguments of the operation, such as a
+	// config, are invalid.
+	CodeValidation
+
+	// CodeLaunchFailed is used when an instance couldn't be launched, for
+	// example because the Factory failed or the circuit breaker is open.
+	CodeLaunchFailed
+
+	// CodeUpdateFailed is used when an instance failed to be updated
+	// dynamically for another reason than ErrInvalidUpdate, which causes a
+	// restart instead.
+	CodeUpdateFailed
+
+	// CodeLimitReached is used when the operation was rejected because of
+	// MaxInstances or MaxRestartsInFlight.
+	CodeLimitReached
+
+	// CodeStopped is used when the BasicManager is stopped.
+	CodeStopped
+)
+
+// String returns the name of the code.
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeNotFound:
+		return "not_found"
+	case CodeValidation:
+		return "validation"
+	case CodeLaunchFailed:
+		return "launch_failed"
+	case CodeUpdateFailed:
+		return "update_failed"
+	case CodeLimitReached:
+		return "limit_reached"
+	case CodeStopped:
+		return "stopped"
+	default:
+		return fmt.Sprintf("ErrorCode(%d)", int(c))
+	}
+}
+
+// ManagerError is the error returned by the operations of BasicManager, such
+// as ApplyConfig and DeleteConfig. It wraps the cause of the failure, so the
+// sentinel errors of this package can still be checked with errors.Is, and
+// has the same message as the cause.
+type ManagerError struct {
+	Code ErrorCode
+	Name string // Name of the instance targeted by the operation, if any
+	Err  error
+}
+
+// Error implements the error interface.
+func (e *ManagerError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the cause of e.
+func (e *ManagerError) Unwrap() error { return e.Err }
+
+// ErrorCodeOf returns the code of the ManagerError wrapped by err. Returns 0
+// if err doesn't wrap a ManagerError.
+func ErrorCodeOf(err error) ErrorCode {
+	var me *ManagerError
+	if errors.As(err, &me) {
+		return me.Code
+	}
+	return 0
+}
+
+// wrapError returns err as a *ManagerError about the named instance. The code
+// is taken from the ManagerError or sentinel error wrapped by err, and
+// defaults to code. Returns err as is if it's nil or already a *ManagerError
+// about the named instance.
+func wrapError(name string, code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	if me, ok := err.(*ManagerError); ok && me.Name == name {
+		return err
+	}
+
+	var me *ManagerError
+	switch {
+	case errors.As(err, &me):
+		code = me.Code
+	case errors.Is(err, ErrConfigNotFound),
+		errors.Is(err, ErrPreparedConfigNotFound),
+		errors.Is(err, ErrNoPreviousConfig):
+		code = CodeNotFound
+	case errors.Is(err, ErrManagerStopped):
+		code = CodeStopped
+	case errors.Is(err, ErrInstanceLimitReached), errors.Is(err, ErrSaturated):
+		code = CodeLimitReached
+	case errors.Is(err, ErrCircuitOpen):
+		code = CodeLaunchFailed
+	}
+	return &ManagerError{Code: code, Name: name, Err: err}
+}
 
 // ErrInvalidUpdate is returned whenever Update is called against an instance
 // but an invalid field is changed between configs. If ErrInvalidUpdate is
@@ -8,6 +157,11 @@ import "fmt"
 // with the new config.
 type ErrInvalidUpdate struct {
 	Inner error
+
+	// Fields lists the config fields which changed but can't be updated
+	// dynamically. Fields is empty when the update failed for another
+	// reason, such as the instance not running yet.
+	Fields []string
 }
 
 // Error implements the error interface.
@@ -35,10 +189,10 @@ func (e ErrInvalidUpdate) As(err interface{}) bool {
 	return true
 }
 
-// errImmutableField is the error describing a field that cannot be changed. It
+// errImmutableFields is the error describing fields that cannot be changed. It
 // is wrapped inside of a ErrInvalidUpdate.
-type errImmutableField struct{ Field string }
+type errImmutableFields struct{ Fields []string }
 
-func (e errImmutableField) Error() string {
-	return fmt.Sprintf("%s cannot be changed dynamically", e.Field)
+func (e errImmutableFields) Error() string {
+	return fmt.Sprintf("%s cannot be changed dynamically", strings.Join(e.Fields, ", "))
 }
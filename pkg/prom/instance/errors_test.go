@@ -0,0 +1,71 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerError(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		if c.HostFilter {
+			return nil, fmt.Errorf("invalid config")
+		}
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				return fmt.Errorf("update failed")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.MaxInstances = 1
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+
+	requireCode := func(t *testing.T, code ErrorCode, name string, err error) {
+		t.Helper()
+
+		var me *ManagerError
+		require.True(t, errors.As(err, &me), "expected a ManagerError, got %v", err)
+		require.Equal(t, code, me.Code, "unexpected code for %v", err)
+		require.Equal(t, name, me.Name)
+		require.Equal(t, code, ErrorCodeOf(err))
+	}
+
+	err := cm.DeleteConfig("missing")
+	requireCode(t, CodeNotFound, "missing", err)
+	require.True(t, errors.Is(err, ErrConfigNotFound))
+	require.EqualError(t, err, ErrConfigNotFound.Error())
+
+	err = cm.OverrideConfig("a", Config{Name: "b"})
+	requireCode(t, CodeValidation, "a", err)
+
+	err = cm.ApplyConfig(Config{Name: "a"})
+	requireCode(t, CodeUpdateFailed, "a", err)
+	require.EqualError(t, err, "failed to update instance a: update failed")
+
+	err = cm.ApplyConfig(Config{Name: "b"})
+	requireCode(t, CodeLimitReached, "b", err)
+	require.True(t, errors.Is(err, ErrInstanceLimitReached))
+
+	require.NoError(t, cm.DeleteConfig("a"))
+	err = cm.ApplyConfig(Config{Name: "b", HostFilter: true})
+	requireCode(t, CodeLaunchFailed, "b", err)
+
+	cm.Stop()
+	err = cm.ApplyConfig(Config{Name: "b"})
+	requireCode(t, CodeStopped, "b", err)
+	require.True(t, errors.Is(err, ErrManagerStopped))
+
+	require.Equal(t, ErrorCode(0), ErrorCodeOf(fmt.Errorf("other error")))
+}
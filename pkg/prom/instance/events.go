@@ -0,0 +1,168 @@
+package instance
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// EventType is the type of a lifecycle Event.
+type EventType string
+
+// Types of lifecycle events emitted by a BasicManager.
+const (
+	// EventStarted is emitted when an instance is started for a new config.
+	EventStarted EventType = "started"
+
+	// EventRestarted is emitted when an instance is started again. The Cause
+	// of the Event explains why it was restarted.
+	EventRestarted EventType = "restarted"
+
+	// EventStopped is emitted when an instance stops for good, such as when
+	// its config is deleted.
+	EventStopped EventType = "stopped"
+)
+
+// RestartCause explains why an instance was restarted.
+type RestartCause string
+
+// Possible causes of an EventRestarted.
+const (
+	// RestartCauseAbnormalExit is used when the instance is restarted after
+	// exiting unexpectedly.
+	RestartCauseAbnormalExit RestartCause = "abnormal_exit"
+
+	// RestartCauseForcedByUpdate is used when the instance is restarted
+	// because its new config couldn't be applied dynamically.
+	RestartCauseForcedByUpdate RestartCause = "forced_by_update"
+
+	// RestartCauseManualRestart is used when the instance is restarted
+	// through BasicManager.RestartInstance.
+	RestartCauseManualRestart RestartCause = "manual_restart"
+
+	// RestartCauseRecoveredFromQuarantine is used when a quarantined
+	// instance is restarted after waiting for the quarantine interval.
+	RestartCauseRecoveredFromQuarantine RestartCause = "recovered_from_quarantine"
+
+	// RestartCauseStorageMigrated is used when the instance is restarted
+	// after its storage was moved by BasicManager.MigrateStorage.
+	RestartCauseStorageMigrated RestartCause = "storage_migrated"
+
+	// RestartCauseStartupTimeout is used when the instance is restarted
+	// because it didn't become ready within the startup timeout.
+	RestartCauseStartupTimeout RestartCause = "startup_timeout"
+
+	// RestartCauseRestartsResumed is used when the instance is restarted
+	// after exiting abnormally while restarts were suspended.
+	RestartCauseRestartsResumed RestartCause = "restarts_resumed"
+)
+
+// Event describes a change in the lifecycle of a managed instance.
+type Event struct {
+	Type     EventType
+	Instance string
+	Time     time.Time
+
+	// Cause is only set for EventRestarted.
+	Cause RestartCause
+}
+
+// eventBufferSize is the number of events buffered for each subscriber.
+const eventBufferSize = 64
+
+// Subscribe subscribes to lifecycle events of the instances managed by the
+// BasicManager. Events are dropped for subscribers that fall too far behind.
+// The returned function unsubscribes and closes the channel.
+//
+// The channel is also closed once the BasicManager is stopped, after the
+// final EventStopped events have been sent. Subscribing to a stopped
+// BasicManager returns a closed channel.
+func (m *BasicManager) Subscribe() (<-chan Event, func()) {
+	return m.subscribe(false)
+}
+
+// SubscribeWithSnapshot is like Subscribe, but the channel first receives a
+// synthetic EventStarted for every instance managed at the time of
+// subscribing, timestamped with the time of subscribing. Every later event is
+// sent after those, so subscribers don't miss or duplicate any event.
+func (m *BasicManager) SubscribeWithSnapshot() (<-chan Event, func()) {
+	return m.subscribe(true)
+}
+
+func (m *BasicManager) subscribe(snapshot bool) (<-chan Event, func()) {
+	var names []string
+	if snapshot {
+		// EventStarted and EventStopped are emitted while holding mut, so
+		// holding it until the channel is subscribed keeps the snapshot
+		// consistent with the events that follow.
+		m.mut.Lock()
+		defer m.mut.Unlock()
+
+		names = make([]string, 0, len(m.processes))
+		for name := range m.processes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	ch := make(chan Event, eventBufferSize+len(names))
+
+	m.eventSubsMut.Lock()
+	if m.eventsClosed {
+		m.eventSubsMut.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	now := time.Now()
+	for _, name := range names {
+		ch <- Event{Type: EventStarted, Instance: name, Time: now}
+	}
+	m.eventSubs[ch] = struct{}{}
+	m.eventSubsMut.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			m.eventSubsMut.Lock()
+			defer m.eventSubsMut.Unlock()
+
+			// The channel is already closed if the manager stopped.
+			if _, ok := m.eventSubs[ch]; ok {
+				delete(m.eventSubs, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, unsubscribe
+}
+
+// closeSubscribers closes the channels of all subscribers. Subscribers
+// registered afterwards will receive a closed channel.
+func (m *BasicManager) closeSubscribers() {
+	m.eventSubsMut.Lock()
+	defer m.eventSubsMut.Unlock()
+
+	for sub := range m.eventSubs {
+		close(sub)
+		delete(m.eventSubs, sub)
+	}
+	m.eventsClosed = true
+}
+
+// emit sends an event for the named instance to all subscribers.
+func (m *BasicManager) emit(typ EventType, name string, cause RestartCause) {
+	ev := Event{Type: typ, Instance: name, Time: time.Now(), Cause: cause}
+
+	m.eventSubsMut.Lock()
+	defer m.eventSubsMut.Unlock()
+
+	for sub := range m.eventSubs {
+		select {
+		case sub <- ev:
+		default:
+			level.Debug(m.logger).Log("msg", "dropping lifecycle event for slow subscriber", "instance", name, "event", typ)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_Subscribe(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				return ErrInvalidUpdate{Inner: fmt.Errorf("cannot dynamically update for testing reasons")}
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	requireEvent(t, events, EventStarted, "")
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	requireEvent(t, events, EventRestarted, RestartCauseForcedByUpdate)
+
+	require.Eventually(t, func() bool { return runs.Load() == 2 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, cm.RestartInstance("test"))
+	requireEvent(t, events, EventRestarted, RestartCauseManualRestart)
+	require.Eventually(t, func() bool { return runs.Load() == 3 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, cm.DeleteConfig("test"))
+	requireEvent(t, events, EventStopped, "")
+
+	require.ErrorIs(t, cm.RestartInstance("missing"), ErrConfigNotFound)
+}
+
+func TestBasicManager_Subscribe_FailedRestart(t *testing.T) {
+	spawns := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		if spawns.Inc() > 1 {
+			return nil, fmt.Errorf("cannot launch")
+		}
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				return ErrInvalidUpdate{Inner: fmt.Errorf("cannot dynamically update for testing reasons")}
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	requireEvent(t, events, EventStarted, "")
+
+	// The old instance is stopped, but its replacement never starts.
+	require.Error(t, cm.ApplyConfig(Config{Name: "test"}))
+	requireEvent(t, events, EventStopped, "")
+}
+
+func TestBasicManager_Subscribe_RestartCauses(t *testing.T) {
+	t.Run("abnormal exit", func(t *testing.T) {
+		runs := atomic.NewInt64(0)
+		spawner := func(c Config) (ManagedInstance, error) {
+			return &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					if runs.Inc() == 1 {
+						return fmt.Errorf("failed to run")
+					}
+					<-ctx.Done()
+					return nil
+				},
+			}, nil
+		}
+
+		cfg := DefaultBasicManagerConfig
+		cfg.InstanceRestartBackoff = time.Millisecond
+
+		cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+		defer cm.Stop()
+
+		events, unsubscribe := cm.Subscribe()
+		defer unsubscribe()
+
+		require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+		requireEvent(t, events, EventStarted, "")
+		requireEvent(t, events, EventRestarted, RestartCauseAbnormalExit)
+	})
+
+	t.Run("recovered from quarantine", func(t *testing.T) {
+		runs := atomic.NewInt64(0)
+		spawner := func(c Config) (ManagedInstance, error) {
+			return &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					if runs.Inc() == 1 {
+						return fmt.Errorf("failed to run")
+					}
+					<-ctx.Done()
+					return nil
+				},
+			}, nil
+		}
+
+		cfg := DefaultBasicManagerConfig
+		cfg.QuarantineThreshold = 1
+		cfg.QuarantineInterval = time.Millisecond
+
+		cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+		defer cm.Stop()
+
+		events, unsubscribe := cm.Subscribe()
+		defer unsubscribe()
+
+		require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+		requireEvent(t, events, EventStarted, "")
+		requireEvent(t, events, EventRestarted, RestartCauseRecoveredFromQuarantine)
+	})
+}
+
+func TestBasicManager_Subscribe_Stop(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "b"}))
+
+	// Range over the events like a typical subscriber would; the loop must
+	// exit once the manager stops.
+	received := make(chan []Event)
+	go func() {
+		var res []Event
+		for ev := range events {
+			res = append(res, ev)
+		}
+		received <- res
+	}()
+
+	cm.Stop()
+
+	var res []Event
+	select {
+	case res = <-received:
+	case <-time.After(time.Second):
+		require.FailNow(t, "subscriber loop did not exit after Stop")
+	}
+
+	stopped := make(map[string]bool)
+	for _, ev := range res {
+		if ev.Type == EventStopped {
+			stopped[ev.Instance] = true
+		}
+	}
+	require.Equal(t, map[string]bool{"a": true, "b": true}, stopped)
+
+	late, lateUnsubscribe := cm.Subscribe()
+	defer lateUnsubscribe()
+	_, ok := <-late
+	require.False(t, ok, "subscribing after Stop should return a closed channel")
+}
+
+func TestBasicManager_SubscribeWithSnapshot(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "b"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+
+	events, unsubscribe := cm.SubscribeWithSnapshot()
+	defer unsubscribe()
+
+	for _, name := range []string{"a", "b"} {
+		select {
+		case ev := <-events:
+			require.Equal(t, Event{Type: EventStarted, Instance: name, Time: ev.Time}, ev)
+			require.False(t, ev.Time.IsZero())
+		case <-time.After(time.Second):
+			require.FailNow(t, "did not receive snapshot event", "expected event for %s", name)
+		}
+	}
+
+	// Events after the snapshot are still delivered.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	requireEvent(t, events, EventStarted, "")
+
+	select {
+	case ev := <-events:
+		require.FailNow(t, "unexpected event", "%+v", ev)
+	default:
+	}
+}
+
+func requireEvent(t *testing.T, events <-chan Event, typ EventType, cause RestartCause) {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		require.Equal(t, "test", ev.Instance)
+		require.Equal(t, typ, ev.Type)
+		require.Equal(t, cause, ev.Cause)
+		require.False(t, ev.Time.IsZero())
+	case <-time.After(time.Second):
+		require.FailNow(t, "did not receive event", "expected %s event", typ)
+	}
+}
@@ -0,0 +1,76 @@
+package instance
+
+import "sync"
+
+// ListGroup returns the managed instances whose config has the given Group,
+// keyed by instance name.
+func (m *BasicManager) ListGroup(group string) map[string]ManagedInstance {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	res := make(map[string]ManagedInstance)
+	for name, proc := range m.processes {
+		if group != "" && proc.cfg.Group == group {
+			res[name] = proc.inst
+		}
+	}
+	return res
+}
+
+// DeleteGroup deletes the configs of all managed instances in the given
+// group, as if they were passed to DeleteConfigs. The returned map holds the
+// error for each instance that could not be deleted and is empty if all
+// deletes succeeded, including when the group has no instances.
+func (m *BasicManager) DeleteGroup(group string) map[string]error {
+	m.applyMut.Lock()
+	defer m.applyMut.Unlock()
+	return m.deleteConfigs(m.groupNames(group))
+}
+
+// StopGroup stops all managed instances in the given group in parallel, as
+// Stop does for every instance of the BasicManager. Unlike DeleteGroup,
+// OnConfigDeleted isn't invoked and the previous configs of the instances are
+// kept for Rollback: the configs are only expected to be gone from this
+// BasicManager, and re-applying them starts the instances again. The metric
+// series and silences of the instances are removed as by DeleteGroup.
+func (m *BasicManager) StopGroup(group string) {
+	m.applyMut.Lock()
+	defer m.applyMut.Unlock()
+
+	m.mut.Lock()
+	procs := make(map[*managedProcess]Config)
+	for _, proc := range m.processes {
+		if group != "" && proc.cfg.Group == group {
+			procs[proc] = proc.cfg
+		}
+	}
+	m.mut.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(procs))
+	for proc, cfg := range procs {
+		go func(proc *managedProcess, cfg Config) {
+			defer wg.Done()
+			m.stopProcess(proc, cfg)
+		}(proc, cfg)
+	}
+	wg.Wait()
+
+	for proc, cfg := range procs {
+		m.tearDownInstance(cfg.Name, proc)
+	}
+}
+
+// groupNames returns the names of the managed instances in the given group.
+func (m *BasicManager) groupNames(group string) []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	var names []string
+	for name, proc := range m.processes {
+		if group != "" && proc.cfg.Group == group {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -14,10 +14,15 @@ import (
 // A GroupManager wraps around another Manager and groups all incoming Configs
 // into a smaller set of configs, causing less managed instances to be spawned.
 //
-// Configs are grouped by all settings for a Config *except* scrape configs.
-// Any difference found in any flag will cause a Config to be placed in another
-// group. One exception to this rule is that remote_writes are compared
-// unordered, but the sets of remote_writes should otherwise be identical.
+// Configs are grouped by all settings for a Config *except* scrape configs
+// and labels. Any difference found in any flag will cause a Config to be
+// placed in another group. One exception to this rule is that remote_writes
+// are compared unordered, but the sets of remote_writes should otherwise be
+// identical. The labels of a group are the union of the labels of its
+// configs; see groupConfigs.
+//
+// Configs with different startup probes are placed in different groups, as
+// an instance only waits for a single startup probe.
 //
 // GroupManagers drastically improve the performance of the Agent when a
 // significant number of instances are spawned, as the overhead of each
@@ -68,6 +73,12 @@ func (m *GroupManager) ListInstances() map[string]ManagedInstance {
 	return m.inner.ListInstances()
 }
 
+// InstanceStatuses returns the statuses of all currently grouped managed
+// instances. The key will be the group's hash of shared settings.
+func (m *GroupManager) InstanceStatuses() map[string]InstanceStatus {
+	return m.inner.InstanceStatuses()
+}
+
 // ListConfigs returns the UNGROUPED instance configs with their original
 // settings. To see the grouped instances, call ListInstances instead.
 func (m *GroupManager) ListConfigs() map[string]Config {
@@ -186,7 +197,7 @@ func (m *GroupManager) DeleteConfig(name string) error {
 func (m *GroupManager) deleteConfig(name string) error {
 	groupName, ok := m.groupLookup[name]
 	if !ok {
-		return fmt.Errorf("config does not exist")
+		return ErrConfigNotFound
 	}
 
 	// Grab a copy of the stored group and delete our entry. We can
@@ -237,8 +248,8 @@ func (m *GroupManager) Stop() {
 }
 
 // hashConfig determines the hash of a Config used for grouping. It ignores
-// the name and scrape_configs and also orders remote_writes by name prior to
-// hashing.
+// the name, labels, depends_on and scrape_configs and also orders
+// remote_writes by name prior to hashing.
 func hashConfig(c Config) (string, error) {
 	// We need a deep copy since we're going to mutate the remote_write
 	// pointers.
@@ -247,8 +258,11 @@ func hashConfig(c Config) (string, error) {
 		return "", err
 	}
 
-	// Ignore name and scrape configs when hashing
+	// Ignore name, labels, dependencies and scrape configs when hashing.
+	// Dependencies refer to ungrouped configs, so groups don't have any.
 	groupable.Name = ""
+	groupable.Labels = nil
+	groupable.DependsOn = nil
 	groupable.ScrapeConfigs = nil
 
 	// Assign names to remote_write configs if they're not present already.
@@ -314,7 +328,9 @@ func copyConfig(c Config) (Config, error) {
 }
 
 // groupConfig creates a grouped Config where all fields are copied from
-// the first config except for scrape_configs, which are appended together.
+// the first config except for scrape_configs, which are appended together,
+// and labels, which are merged. When configs set the same label to different
+// values, the value from the config whose name sorts first is used.
 func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 	if len(grouped) == 0 {
 		return Config{}, fmt.Errorf("no configs")
@@ -334,6 +350,7 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 	}
 	combined.Name = groupName
 	combined.ScrapeConfigs = []*config.ScrapeConfig{}
+	combined.DependsOn = nil
 
 	// Assign all remote_write configs in the group a consistent set of remote_names.
 	// If the grouped configs are coming from the scraping service, defaults will have
@@ -362,5 +379,16 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 		combined.ScrapeConfigs = append(combined.ScrapeConfigs, cfg.ScrapeConfigs...)
 	}
 
+	// Merge the labels in reverse so the configs which sort first win.
+	combined.Labels = nil
+	for i := len(cfgs) - 1; i >= 0; i-- {
+		for name, value := range cfgs[i].Labels {
+			if combined.Labels == nil {
+				combined.Labels = make(map[string]string)
+			}
+			combined.Labels[name] = value
+		}
+	}
+
 	return combined, nil
 }
@@ -39,6 +39,13 @@ remote_write:
 	insts := gm.ListInstances()
 	require.Equal(t, 2, len(insts))
 
+	// InstanceStatuses should match the grouped instances.
+	statuses := gm.InstanceStatuses()
+	require.Equal(t, 2, len(statuses))
+	for name := range insts {
+		require.Containsf(t, statuses, name, "%s not in statuses", name)
+	}
+
 	// ...but ListConfigs should return the ungrouped configs.
 	confs := gm.ListConfigs()
 	require.Equal(t, 3, len(confs))
@@ -274,6 +281,13 @@ func newFakeManager() Manager {
 		ListConfigsFunc: func() map[string]Config {
 			return configs
 		},
+		InstanceStatusesFunc: func() map[string]InstanceStatus {
+			statuses := make(map[string]InstanceStatus, len(instances))
+			for name := range instances {
+				statuses[name] = InstanceStatus{State: InstanceStateRunning}
+			}
+			return statuses
+		},
 		ApplyConfigFunc: func(c Config) error {
 			instances[c.Name] = &mockInstance{}
 			configs[c.Name] = c
@@ -307,6 +321,40 @@ remote_write: []`
 		require.Equal(t, hashA, hashB)
 	})
 
+	t.Run("labels are ignored", func(t *testing.T) {
+		configAText := `
+name: configA
+labels:
+  team: a
+scrape_configs: []
+remote_write: []`
+
+		configBText := `
+name: configB
+scrape_configs: []
+remote_write: []`
+
+		hashA, hashB := getHashesFromConfigs(t, configAText, configBText)
+		require.Equal(t, hashA, hashB)
+	})
+
+	t.Run("startup probes must match", func(t *testing.T) {
+		configAText := `
+name: configA
+startup_probe:
+  address: localhost:9009
+scrape_configs: []
+remote_write: []`
+
+		configBText := `
+name: configB
+scrape_configs: []
+remote_write: []`
+
+		hashA, hashB := getHashesFromConfigs(t, configAText, configBText)
+		require.NotEqual(t, hashA, hashB)
+	})
+
 	t.Run("remote_writes are unordered", func(t *testing.T) {
 		configAText := `
 name: configA
@@ -444,3 +492,31 @@ remote_write:
 		require.Equal(t, *expect, actual)
 	}
 }
+
+func Test_groupConfigs_Labels(t *testing.T) {
+	configA := testUnmarshalConfig(t, `
+name: configA
+labels:
+  team: a
+  env: prod
+scrape_configs: []
+remote_write: []`)
+
+	configB := testUnmarshalConfig(t, `
+name: configB
+labels:
+  team: b
+  region: us
+scrape_configs: []
+remote_write: []`)
+
+	groupName, err := hashConfig(configA)
+	require.NoError(t, err)
+
+	actual, err := groupConfigs(groupName, groupedConfigs{
+		"configA": configA,
+		"configB": configB,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": "a", "env": "prod", "region": "us"}, actual.Labels)
+}
@@ -0,0 +1,74 @@
+package instance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicManager_Groups(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	var deleted []string
+
+	cfg := DefaultBasicManagerConfig
+	cfg.OnConfigDeleted = func(name string) { deleted = append(deleted, name) }
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for _, c := range []Config{
+		{Name: "a-1", Group: "tenant-a"},
+		{Name: "a-2", Group: "tenant-a"},
+		{Name: "b-1", Group: "tenant-b"},
+		{Name: "c-1", Group: "tenant-c"},
+		{Name: "ungrouped"},
+	} {
+		require.NoError(t, cm.ApplyConfig(c))
+	}
+
+	keys := func(m map[string]ManagedInstance) []string {
+		var res []string
+		for k := range m {
+			res = append(res, k)
+		}
+		return res
+	}
+	require.ElementsMatch(t, []string{"a-1", "a-2"}, keys(cm.ListGroup("tenant-a")))
+	require.Empty(t, cm.ListGroup(""))
+
+	require.Empty(t, cm.DeleteGroup("tenant-a"))
+	require.ElementsMatch(t, []string{"a-1", "a-2"}, deleted)
+	require.Empty(t, cm.ListGroup("tenant-a"))
+	require.Empty(t, cm.DeleteGroup("tenant-a"))
+
+	// Stopping a group doesn't report its configs as deleted, but clears
+	// what's left of its instances.
+	require.NoError(t, cm.Silence("b-1", time.Now().Add(time.Hour)))
+	instanceAbnormalExits.WithLabelValues("b-1").Inc()
+	cm.StopGroup("tenant-b")
+	require.Empty(t, cm.ListGroup("tenant-b"))
+	require.ElementsMatch(t, []string{"a-1", "a-2"}, deleted)
+	_, silenced := cm.Silenced("b-1")
+	require.False(t, silenced)
+	var exits dto.Metric
+	require.NoError(t, instanceAbnormalExits.WithLabelValues("b-1").Write(&exits))
+	require.Zero(t, exits.GetCounter().GetValue())
+
+	// Moving an instance to another group is a dynamic update.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "ungrouped", Group: "tenant-c"}))
+	require.ElementsMatch(t, []string{"c-1", "ungrouped"}, keys(cm.ListGroup("tenant-c")))
+	require.Len(t, cm.ListInstances(), 2)
+}
@@ -0,0 +1,86 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// handoffMut serializes calls to Handoff, which lock the name of the instance
+// in two managers. Without it, handoffs of the same name in opposite
+// directions could deadlock.
+var handoffMut sync.Mutex
+
+// Handoff moves the managed instance with the given name from m to dst.
+//
+// This is a stop/start: the process can't be moved between managers, so the
+// instance is stopped in m and then launched in dst with the same config.
+// Since the new instance picks up the storage of the old one, no data is
+// lost. This requires the StorageDir of the config to be the same in both
+// managers; Handoff fails without stopping the instance otherwise. If the
+// instance can't be launched in dst, it is launched in m again.
+//
+// The name of the instance stays locked in both managers until the instance
+// runs again, so concurrent applies of the name can't launch a second
+// instance writing to the same storage. The instance isn't deleted from m:
+// OnConfigDeleted isn't invoked and its metric series are only removed if dst
+// labels them differently.
+//
+// Returns ErrConfigNotFound if m has no instance with the given name.
+func (m *BasicManager) Handoff(name string, dst *BasicManager) error {
+	if dst == m {
+		return wrapError(name, CodeValidation, fmt.Errorf("cannot hand off instance %s to the manager running it", name))
+	}
+	if err := dst.waitUnsaturated(context.Background(), name); err != nil {
+		return wrapError(name, CodeLimitReached, fmt.Errorf("cannot hand off instance %s: %w", name, err))
+	}
+
+	handoffMut.Lock()
+	defer handoffMut.Unlock()
+	defer m.lockName(name)()
+	defer dst.lockName(name)()
+
+	m.mut.Lock()
+	if m.state != ManagerStateRunning {
+		m.mut.Unlock()
+		return wrapError(name, CodeStopped, ErrManagerStopped)
+	}
+	proc, ok := m.processes[name]
+	if !ok {
+		m.mut.Unlock()
+		return wrapError(name, CodeNotFound, ErrConfigNotFound)
+	}
+	cfg := proc.cfg
+	m.mut.Unlock()
+
+	srcDir, dstDir := m.StorageDir(cfg), dst.StorageDir(cfg)
+	if srcDir != dstDir {
+		return wrapError(name, CodeValidation, fmt.Errorf("cannot hand off instance %s: storage directory %q of the destination doesn't match %q", name, dstDir, srcDir))
+	}
+	if dst.State() != ManagerStateRunning {
+		return wrapError(name, CodeStopped, fmt.Errorf("cannot hand off instance %s: %w", name, ErrManagerStopped))
+	}
+	if _, exists := dst.Instance(name); exists {
+		return wrapError(name, CodeValidation, fmt.Errorf("cannot hand off instance %s: the destination already has an instance with that name", name))
+	}
+
+	// The process removes itself from m once stopped.
+	m.stopProcess(proc, cfg)
+	m.forgetPrevious(name)
+
+	err := dst.applyAndRecord(context.Background(), cfg)
+	if err == nil {
+		dst.mut.Lock()
+		dstProc, running := dst.processes[name]
+		dst.mut.Unlock()
+		if !running || dstProc.metricLabel != proc.metricLabel {
+			m.deleteInstanceMetrics(proc.metricLabel)
+		}
+		return nil
+	}
+
+	if rollbackErr := m.applyAndRecord(context.Background(), cfg); rollbackErr != nil {
+		return wrapError(name, CodeLaunchFailed, fmt.Errorf("failed to hand off instance %s: %w (relaunching it in the source manager also failed: %s)", name, err, rollbackErr))
+	}
+	return wrapError(name, CodeLaunchFailed, fmt.Errorf("failed to hand off instance %s: %w", name, err))
+}
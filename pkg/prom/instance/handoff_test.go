@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicManager_Handoff(t *testing.T) {
+	newManager := func(t *testing.T, cfg BasicManagerConfig, launchErr error) *BasicManager {
+		cm := NewBasicManager(cfg, log.NewNopLogger(), func(c Config) (ManagedInstance, error) {
+			if launchErr != nil {
+				return nil, launchErr
+			}
+			return &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					<-ctx.Done()
+					return nil
+				},
+			}, nil
+		})
+		t.Cleanup(cm.Stop)
+		return cm
+	}
+
+	t.Run("moves instance", func(t *testing.T) {
+		src := newManager(t, DefaultBasicManagerConfig, nil)
+		dst := newManager(t, DefaultBasicManagerConfig, nil)
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "test"}))
+		require.NoError(t, src.Handoff("test", dst))
+		require.Empty(t, src.ListConfigs())
+		require.Contains(t, dst.ListConfigs(), "test")
+
+		require.ErrorIs(t, src.Handoff("missing", dst), ErrConfigNotFound)
+	})
+
+	t.Run("tears down source", func(t *testing.T) {
+		var deleted []string
+		srcCfg := DefaultBasicManagerConfig
+		srcCfg.MetricLabelFunc = func(name string) string { return "handoff/" + name }
+		srcCfg.OnConfigDeleted = func(name string) { deleted = append(deleted, name) }
+
+		src := newManager(t, srcCfg, nil)
+		dst := newManager(t, DefaultBasicManagerConfig, nil)
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "test"}))
+		require.NoError(t, src.Silence("test", time.Now().Add(time.Hour)))
+		instanceAbnormalExits.WithLabelValues("handoff/test").Inc()
+
+		require.NoError(t, src.Handoff("test", dst))
+		_, silenced := src.Silenced("test")
+		require.False(t, silenced)
+
+		var exits dto.Metric
+		require.NoError(t, instanceAbnormalExits.WithLabelValues("handoff/test").Write(&exits))
+		require.Zero(t, exits.GetCounter().GetValue())
+
+		// The instance was moved, not deleted.
+		require.Empty(t, deleted)
+	})
+
+	t.Run("keeps shared metric series", func(t *testing.T) {
+		src := newManager(t, DefaultBasicManagerConfig, nil)
+		dst := newManager(t, DefaultBasicManagerConfig, nil)
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "shared"}))
+		instanceAbnormalExits.DeleteLabelValues("shared")
+		instanceAbnormalExits.WithLabelValues("shared").Inc()
+		require.NoError(t, src.Handoff("shared", dst))
+
+		var exits dto.Metric
+		require.NoError(t, instanceAbnormalExits.WithLabelValues("shared").Write(&exits))
+		require.Equal(t, 1.0, exits.GetCounter().GetValue())
+	})
+
+	t.Run("rolls back failed launch", func(t *testing.T) {
+		src := newManager(t, DefaultBasicManagerConfig, nil)
+		dst := newManager(t, DefaultBasicManagerConfig, fmt.Errorf("cannot launch"))
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "test"}))
+		require.EqualError(t, src.Handoff("test", dst), "failed to hand off instance test: cannot launch")
+		require.Contains(t, src.ListConfigs(), "test")
+		require.Empty(t, dst.ListConfigs())
+	})
+
+	t.Run("requires same storage directory", func(t *testing.T) {
+		dstCfg := DefaultBasicManagerConfig
+		dstCfg.StorageDirectory = t.TempDir()
+
+		src := newManager(t, DefaultBasicManagerConfig, nil)
+		dst := newManager(t, dstCfg, nil)
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "test"}))
+		require.Error(t, src.Handoff("test", dst))
+		require.Contains(t, src.ListConfigs(), "test")
+
+		// The storage directory of the config is compared, not the root.
+		dir := t.TempDir()
+		srcCfg := DefaultBasicManagerConfig
+		srcCfg.StorageDirFunc = func(c Config) string { return filepath.Join(dir, "src", c.Name) }
+		dstCfg = DefaultBasicManagerConfig
+		dstCfg.StorageDirFunc = func(c Config) string { return filepath.Join(dir, "dst", c.Name) }
+
+		src = newManager(t, srcCfg, nil)
+		dst = newManager(t, dstCfg, nil)
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "test"}))
+		require.Equal(t, CodeValidation, ErrorCodeOf(src.Handoff("test", dst)))
+		require.Contains(t, src.ListConfigs(), "test")
+	})
+
+	t.Run("destination already has instance", func(t *testing.T) {
+		src := newManager(t, DefaultBasicManagerConfig, nil)
+		dst := newManager(t, DefaultBasicManagerConfig, nil)
+
+		require.NoError(t, src.ApplyConfig(Config{Name: "test"}))
+		require.NoError(t, dst.ApplyConfig(Config{Name: "test"}))
+		require.Error(t, src.Handoff("test", dst))
+		require.Contains(t, src.ListConfigs(), "test")
+	})
+}
@@ -0,0 +1,76 @@
+package instance
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// keyedApply is an apply made by ApplyConfigWithKey.
+type keyedApply struct {
+	cfg  Config
+	done chan struct{} // Closed once err is set
+	err  error
+}
+
+// ApplyConfigWithKey applies c like ApplyConfig, unless a config was applied
+// with the same idempotency key within IdempotencyKeyTTL. In that case c
+// isn't applied again and the result of the previous apply is returned
+// instead, waiting for it to finish if it's still in progress. This keeps
+// callers which retry applies, such as a control plane delivering changes at
+// least once, from applying the same change twice.
+//
+// Only successful applies and applies failing with CodeValidation are
+// remembered for IdempotencyKeyTTL. Other errors, such as ErrSaturated or a
+// failed launch, may not happen again, so the key is forgotten as soon as the
+// apply fails and a retry with the same key applies c again. Callers waiting
+// on the failed apply still get its error.
+//
+// Reusing a key for a different config returns ErrIdempotencyKeyReused. An
+// empty key applies c without deduplication.
+func (m *BasicManager) ApplyConfigWithKey(key string, c Config) error {
+	if key == "" {
+		return m.ApplyConfig(c)
+	}
+
+	m.keyedAppliesMut.Lock()
+	apply, seen := m.keyedApplies[key]
+	if !seen {
+		apply = &keyedApply{cfg: c, done: make(chan struct{})}
+		m.keyedApplies[key] = apply
+	}
+	m.keyedAppliesMut.Unlock()
+
+	if seen {
+		if apply.cfg.Name != c.Name || !sameConfig(apply.cfg, c) {
+			return wrapError(c.Name, CodeValidation, ErrIdempotencyKeyReused)
+		}
+		level.Debug(m.logger).Log("msg", "ignoring duplicate apply", "instance", c.Name, "key", key)
+		<-apply.done
+		return apply.err
+	}
+
+	apply.err = m.ApplyConfig(c)
+	close(apply.done)
+
+	if apply.err != nil && ErrorCodeOf(apply.err) != CodeValidation {
+		m.forgetKey(key, apply)
+		return apply.err
+	}
+
+	ttl := m.ManagerConfig().IdempotencyKeyTTL
+	if ttl == 0 {
+		ttl = DefaultBasicManagerConfig.IdempotencyKeyTTL
+	}
+	time.AfterFunc(ttl, func() { m.forgetKey(key, apply) })
+	return apply.err
+}
+
+// forgetKey forgets key if it still refers to apply.
+func (m *BasicManager) forgetKey(key string, apply *keyedApply) {
+	m.keyedAppliesMut.Lock()
+	defer m.keyedAppliesMut.Unlock()
+	if m.keyedApplies[key] == apply {
+		delete(m.keyedApplies, key)
+	}
+}
@@ -0,0 +1,70 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_ApplyConfigWithKey(t *testing.T) {
+	var (
+		launches = atomic.NewInt64(0)
+		updates  = atomic.NewInt64(0)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		launches.Inc()
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				updates.Inc()
+				if c.HostFilter {
+					return fmt.Errorf("update failed")
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.IdempotencyKeyTTL = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// Duplicate applies are ignored.
+	require.NoError(t, cm.ApplyConfigWithKey("a", Config{Name: "test"}))
+	require.NoError(t, cm.ApplyConfigWithKey("a", Config{Name: "test"}))
+	require.Equal(t, int64(1), launches.Load())
+	require.Equal(t, int64(0), updates.Load())
+
+	// Failed applies aren't remembered, so they can be retried.
+	err := cm.ApplyConfigWithKey("b", Config{Name: "test", HostFilter: true})
+	require.EqualError(t, err, "failed to update instance test: update failed")
+	require.Equal(t, CodeUpdateFailed, ErrorCodeOf(err))
+	require.Error(t, cm.ApplyConfigWithKey("b", Config{Name: "test", HostFilter: true}))
+	require.Equal(t, int64(2), updates.Load())
+
+	// Keys can't be reused for a different config.
+	err = cm.ApplyConfigWithKey("a", Config{Name: "test", Labels: map[string]string{"a": "b"}})
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+	require.Equal(t, CodeValidation, ErrorCodeOf(err))
+
+	// Keys are forgotten after the TTL.
+	require.Eventually(t, func() bool {
+		return cm.ApplyConfigWithKey("a", Config{Name: "test", Labels: map[string]string{"a": "b"}}) == nil
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]string{"a": "b"}, cm.ListConfigs()["test"].Labels)
+
+	// Empty keys don't deduplicate.
+	require.NoError(t, cm.ApplyConfigWithKey("", Config{Name: "test"}))
+	require.NoError(t, cm.ApplyConfigWithKey("", Config{Name: "test"}))
+	require.Equal(t, int64(5), updates.Load())
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -22,6 +23,7 @@ import (
 	"github.com/grafana/agent/pkg/util"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/pkg/relabel"
@@ -71,6 +73,39 @@ type Config struct {
 
 	RemoteFlushDeadline  time.Duration `yaml:"remote_flush_deadline,omitempty"`
 	WriteStaleOnShutdown bool          `yaml:"write_stale_on_shutdown,omitempty"`
+
+	// Labels are arbitrary key/value pairs used to organize instances. They
+	// don't affect the instance itself.
+	Labels map[string]string `yaml:"labels,omitempty"`
+
+	// StartupProbe, when set, delays running the instance until a dependency
+	// is reachable.
+	StartupProbe *StartupProbeConfig `yaml:"startup_probe,omitempty"`
+
+	// DependsOn lists the names of instances which must be running before
+	// this one is applied by BasicManager.ApplyConfigs. It has no effect on
+	// configs applied individually.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+
+	// NoRestart stops the instance for good once it exits, whether it
+	// exited abnormally or not, instead of restarting it. Useful for
+	// one-shot jobs.
+	NoRestart bool `yaml:"no_restart,omitempty"`
+
+	// StorageDirectory overrides the directory the instance keeps its
+	// storage in. It's set by BasicManager.MigrateStorage.
+	StorageDirectory string `yaml:"storage_directory,omitempty"`
+
+	// MaxStorageBytes caps the size of the WAL. When a BasicManager finds the
+	// WAL to be larger, the WAL is truncated right away, dropping samples
+	// older than MinWALTime even if remote_write hasn't sent them yet. There
+	// is no cap if MaxStorageBytes is 0.
+	MaxStorageBytes int64 `yaml:"max_storage_bytes,omitempty"`
+
+	// Group bundles instances which are listed, deleted or stopped together
+	// through the group methods of BasicManager, such as the scrape jobs of
+	// a tenant. Instances without a Group aren't part of any group.
+	Group string `yaml:"group,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -113,6 +148,20 @@ func (c *Config) ApplyDefaults(global *GlobalConfig) error {
 		return errors.New("remote_flush_deadline must be greater than 0s")
 	case c.MinWALTime > c.MaxWALTime:
 		return errors.New("min_wal_time must be less than max_wal_time")
+	case c.MaxStorageBytes < 0:
+		return errors.New("max_storage_bytes must not be negative")
+	}
+
+	for name := range c.Labels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+
+	if c.StartupProbe != nil {
+		if err := c.StartupProbe.Validate(); err != nil {
+			return err
+		}
 	}
 
 	jobNames := map[string]struct{}{}
@@ -186,7 +235,7 @@ func (c *Config) ApplyDefaults(global *GlobalConfig) error {
 	return nil
 }
 
-type walStorageFactory func(reg prometheus.Registerer) (walStorage, error)
+type walStorageFactory func(logger log.Logger, reg prometheus.Registerer) (walStorage, error)
 
 // Instance is an individual metrics collector and remote_writer.
 type Instance struct {
@@ -202,6 +251,11 @@ type Instance struct {
 	readyScrapeManager *readyScrapeManager
 	remoteStore        *remote.Storage
 	storage            storage.Storage
+	ready              bool      // Set once initialized by the current run
+	started            time.Time // Start of the current run
+
+	// truncateMut serializes truncations of the WAL.
+	truncateMut sync.Mutex
 
 	globalCfg GlobalConfig
 	logger    log.Logger
@@ -210,6 +264,21 @@ type Instance struct {
 	newWal walStorageFactory
 
 	vc *MetricValueCollector
+
+	targetsChanged chan struct{}
+
+	// storageHook is set through SetStorageHook. Guarded by mut.
+	storageHook func(op StorageOp, dir string)
+}
+
+// DefaultStorageDir returns the directory under root where the instance for
+// c keeps its storage by default. The StorageDirectory of c is returned
+// instead if set.
+func DefaultStorageDir(root string, c Config) string {
+	if c.StorageDirectory != "" {
+		return c.StorageDirectory
+	}
+	return filepath.Join(root, c.Name)
 }
 
 // New creates a new Instance with a directory for storing the WAL. The instance
@@ -217,9 +286,9 @@ type Instance struct {
 func New(reg prometheus.Registerer, globalCfg GlobalConfig, cfg Config, walDir string, logger log.Logger) (*Instance, error) {
 	logger = log.With(logger, "instance", cfg.Name)
 
-	instWALDir := filepath.Join(walDir, cfg.Name)
+	instWALDir := DefaultStorageDir(walDir, cfg)
 
-	newWal := func(reg prometheus.Registerer) (walStorage, error) {
+	newWal := func(logger log.Logger, reg prometheus.Registerer) (walStorage, error) {
 		return wal.NewStorage(logger, reg, instWALDir)
 	}
 
@@ -239,6 +308,7 @@ func newInstance(globalCfg GlobalConfig, cfg Config, reg prometheus.Registerer,
 		newWal: newWal,
 
 		readyScrapeManager: &readyScrapeManager{},
+		targetsChanged:     make(chan struct{}, 1),
 	}
 
 	return i, nil
@@ -257,8 +327,17 @@ func (i *Instance) Run(ctx context.Context) error {
 	// now.
 	i.mut.Lock()
 	cfg := i.cfg
+	i.ready = false
+	i.started = time.Now()
 	i.mut.Unlock()
 
+	defer func() {
+		i.mut.Lock()
+		i.ready = false
+		i.started = time.Time{}
+		i.mut.Unlock()
+	}()
+
 	level.Debug(i.logger).Log("msg", "initializing instance", "name", cfg.Name)
 
 	// trackingReg wraps the register for the instance to make sure that if Run
@@ -271,6 +350,7 @@ func (i *Instance) Run(ctx context.Context) error {
 		level.Error(i.logger).Log("msg", "failed to initialize instance", "err", err)
 		return fmt.Errorf("failed to initialize instance: %w", err)
 	}
+	i.notifyStorage(StorageOpened)
 
 	// The actors defined here are defined in the order we want them to shut down.
 	// Primarily, we want to ensure that the following shutdown order is
@@ -310,9 +390,11 @@ func (i *Instance) Run(ctx context.Context) error {
 		}
 
 		// Scrape manager
+		syncCtx, syncCancel := context.WithCancel(context.Background())
+		defer syncCancel()
 		rg.Add(
 			func() error {
-				err := sm.Run(i.discovery.SyncCh())
+				err := sm.Run(i.notifyTargetsChanged(syncCtx, i.discovery.SyncCh()))
 				level.Info(i.logger).Log("msg", "scrape manager stopped")
 				return err
 			},
@@ -321,6 +403,7 @@ func (i *Instance) Run(ctx context.Context) error {
 				// markers without receiving new samples from scraping in the meantime.
 				level.Info(i.logger).Log("msg", "stopping scrape manager...")
 				sm.Stop()
+				syncCancel()
 
 				// On a graceful shutdown, write staleness markers. If something went
 				// wrong, then the instance will be relaunched.
@@ -338,6 +421,7 @@ func (i *Instance) Run(ctx context.Context) error {
 				if err := i.storage.Close(); err != nil {
 					level.Error(i.logger).Log("msg", "error stopping storage", "err", err)
 				}
+				i.notifyStorage(StorageClosed)
 			},
 		)
 	}
@@ -360,7 +444,7 @@ func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cf
 
 	var err error
 
-	i.wal, err = i.newWal(reg)
+	i.wal, err = i.newWal(i.logger, reg)
 	if err != nil {
 		return fmt.Errorf("error creating WAL: %w", err)
 	}
@@ -395,10 +479,19 @@ func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cf
 	}
 
 	i.readyScrapeManager.Set(scrapeManager)
+	i.ready = true
 
 	return nil
 }
 
+// Ready implements ReadinessReporter. The instance is ready once Run has
+// initialized its Prometheus components.
+func (i *Instance) Ready() bool {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.ready
+}
+
 // Update accepts a new Config for the Instance and will dynamically update any
 // running Prometheus components with the new values from Config. Update will
 // return an ErrInvalidUpdate if the Update could not be applied.
@@ -408,22 +501,29 @@ func (i *Instance) Update(c Config) (err error) {
 
 	// It's only (currently) valid to update scrape_configs and remote_write, so
 	// if any other field has changed here, return the error.
-	switch {
-	// This first case should never happen in practice but it's included here for
+	var immutable []string
+	// This first check should never fail in practice but it's included here for
 	// completions sake.
-	case i.cfg.Name != c.Name:
-		err = errImmutableField{Field: "name"}
-	case i.cfg.HostFilter != c.HostFilter:
-		err = errImmutableField{Field: "host_filter"}
-	case i.cfg.WALTruncateFrequency != c.WALTruncateFrequency:
-		err = errImmutableField{Field: "wal_truncate_frequency"}
-	case i.cfg.RemoteFlushDeadline != c.RemoteFlushDeadline:
-		err = errImmutableField{Field: "remote_flush_deadline"}
-	case i.cfg.WriteStaleOnShutdown != c.WriteStaleOnShutdown:
-		err = errImmutableField{Field: "write_stale_on_shutdown"}
+	if i.cfg.Name != c.Name {
+		immutable = append(immutable, "name")
 	}
-	if err != nil {
-		return ErrInvalidUpdate{Inner: err}
+	if i.cfg.HostFilter != c.HostFilter {
+		immutable = append(immutable, "host_filter")
+	}
+	if i.cfg.WALTruncateFrequency != c.WALTruncateFrequency {
+		immutable = append(immutable, "wal_truncate_frequency")
+	}
+	if i.cfg.RemoteFlushDeadline != c.RemoteFlushDeadline {
+		immutable = append(immutable, "remote_flush_deadline")
+	}
+	if i.cfg.WriteStaleOnShutdown != c.WriteStaleOnShutdown {
+		immutable = append(immutable, "write_stale_on_shutdown")
+	}
+	if i.cfg.StorageDirectory != c.StorageDirectory {
+		immutable = append(immutable, "storage_directory")
+	}
+	if len(immutable) > 0 {
+		return ErrInvalidUpdate{Inner: errImmutableFields{Fields: immutable}, Fields: immutable}
 	}
 
 	// Check to see if the components exist yet.
@@ -503,10 +603,202 @@ func (i *Instance) TargetsActive() map[string][]*scrape.Target {
 	return mgr.TargetsActive()
 }
 
+// HealthMessage implements HealthReporter. A message is reported while the
+// Instance is starting up or when any of its targets are failing to be
+// scraped.
+func (i *Instance) HealthMessage() string {
+	targets := i.TargetsActive()
+	if targets == nil {
+		return "waiting for scrape manager to start"
+	}
+
+	var (
+		total, failing int
+		lastErr        error
+	)
+	for _, tgs := range targets {
+		for _, tg := range tgs {
+			total++
+			if err := tg.LastError(); err != nil {
+				failing++
+				lastErr = err
+			}
+		}
+	}
+	if failing > 0 {
+		return fmt.Sprintf("%d/%d targets failing to be scraped: %s", failing, total, lastErr)
+	}
+	return ""
+}
+
+// TargetsChanged implements TargetsNotifier. The returned channel receives a
+// value whenever service discovery sends a new set of targets to the scrape
+// manager.
+func (i *Instance) TargetsChanged() <-chan struct{} {
+	return i.targetsChanged
+}
+
+// notifyTargetsChanged forwards target groups from in to the returned channel,
+// signaling TargetsChanged for each set of target groups. Forwarding stops
+// when ctx is canceled.
+func (i *Instance) notifyTargetsChanged(ctx context.Context, in GroupChannel) GroupChannel {
+	out := make(chan DiscoveredGroups)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case tgs := <-in:
+				select {
+				case <-ctx.Done():
+					return
+				case out <- tgs:
+				}
+			}
+
+			select {
+			case i.targetsChanged <- struct{}{}:
+			default:
+				// A notification is already pending.
+			}
+		}
+	}()
+
+	return out
+}
+
+// CaptureLogs implements LogCapturer. It must be called before Run, as the
+// logger of the instance isn't guarded by a mutex.
+func (i *Instance) CaptureLogs(w io.Writer) {
+	i.logger = teeLogger(log.NewLogfmtLogger(w), i.logger)
+}
+
+// SetStorageHook implements StorageNotifier. The WAL is reported opened once
+// Run initialized it, flushed after each truncation, and closed when Run
+// stops.
+func (i *Instance) SetStorageHook(hook func(op StorageOp, dir string)) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.storageHook = hook
+}
+
+// notifyStorage invokes the storage hook, if any, with op. i.mut must not be
+// held when calling notifyStorage.
+func (i *Instance) notifyStorage(op StorageOp) {
+	i.mut.Lock()
+	hook, wal := i.storageHook, i.wal
+	i.mut.Unlock()
+
+	if hook != nil && wal != nil {
+		hook(op, wal.Directory())
+	}
+}
+
 // StorageDirectory returns the directory where this Instance is writing series
-// and samples to for the WAL.
+// and samples to for the WAL. Returns an empty string if the WAL has not been
+// created yet.
 func (i *Instance) StorageDirectory() string {
-	return i.wal.Directory()
+	i.mut.Lock()
+	wal := i.wal
+	i.mut.Unlock()
+
+	if wal == nil {
+		return ""
+	}
+	return wal.Directory()
+}
+
+// StorageSize returns the size in bytes of the WAL directory. Returns 0 if the
+// WAL has not been created yet.
+func (i *Instance) StorageSize() (int64, error) {
+	i.mut.Lock()
+	wal := i.wal
+	i.mut.Unlock()
+
+	if wal == nil {
+		return 0, nil
+	}
+	return dirSize(wal.Directory())
+}
+
+// Truncate truncates the WAL right away, dropping samples older than
+// MinWALTime even if remote_write hasn't sent them yet. Does nothing if the
+// WAL has not been created yet.
+func (i *Instance) Truncate(_ context.Context) error {
+	i.mut.Lock()
+	wal, minWALTime := i.wal, i.cfg.MinWALTime
+	i.mut.Unlock()
+
+	if wal == nil {
+		return nil
+	}
+
+	i.truncateMut.Lock()
+	defer i.truncateMut.Unlock()
+	if err := wal.Truncate(timestamp.FromTime(time.Now().Add(-minWALTime))); err != nil {
+		return err
+	}
+	i.notifyStorage(StorageFlushed)
+	return nil
+}
+
+// LastScrapeTime returns the most recent time any of the Instance's active
+// targets was scraped.
+func (i *Instance) LastScrapeTime() time.Time {
+	var last time.Time
+	for _, tgs := range i.TargetsActive() {
+		for _, tg := range tgs {
+			if ts := tg.LastScrape(); ts.After(last) {
+				last = ts
+			}
+		}
+	}
+	return last
+}
+
+// Stats implements StatsReporter. Errors determining the storage size are
+// logged and leave it at 0.
+func (i *Instance) Stats() InstanceStats {
+	var stats InstanceStats
+	for _, tgs := range i.TargetsActive() {
+		stats.ActiveTargets += len(tgs)
+		for _, tg := range tgs {
+			if ts := tg.LastScrape(); ts.After(stats.LastScrapeTime) {
+				stats.LastScrapeTime = ts
+			}
+		}
+	}
+
+	size, err := i.StorageSize()
+	if err != nil {
+		level.Warn(i.logger).Log("msg", "failed to get storage size", "err", err)
+	}
+	stats.StorageSize = size
+
+	i.mut.Lock()
+	stats.StartTime = i.started
+	i.mut.Unlock()
+	return stats
+}
+
+// dirSize returns the total size of all regular files within dir. A dir that
+// does not exist has a size of 0.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
 }
 
 type discoveryService struct {
@@ -628,7 +920,12 @@ func (i *Instance) truncateLoop(ctx context.Context, wal walStorage, cfg *Config
 			lastTs = ts
 
 			level.Debug(i.logger).Log("msg", "truncating the WAL", "ts", ts)
+			i.truncateMut.Lock()
 			err := wal.Truncate(ts)
+			if err == nil {
+				i.notifyStorage(StorageFlushed)
+			}
+			i.truncateMut.Unlock()
 			if err != nil {
 				// The only issue here is larger disk usage and a greater replay time,
 				// so we'll only log this as a warning.
@@ -233,6 +233,14 @@ remote_write: []
 			mut:    func(c *Config) { c.WriteStaleOnShutdown = true },
 			expect: "write_stale_on_shutdown cannot be changed dynamically",
 		},
+		{
+			name: "multiple fields changed",
+			mut: func(c *Config) {
+				c.HostFilter = true
+				c.WriteStaleOnShutdown = true
+			},
+			expect: "host_filter, write_stale_on_shutdown cannot be changed dynamically",
+		},
 	}
 
 	for _, tc := range tt {
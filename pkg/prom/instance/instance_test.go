@@ -135,6 +135,16 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			},
 			fmt.Errorf("found duplicate remote write configs with name \"foo\""),
 		},
+		{
+			"invalid label name",
+			func(c *Config) { c.Labels = map[string]string{"not-valid": "value"} },
+			fmt.Errorf("invalid label name \"not-valid\""),
+		},
+		{
+			"invalid startup probe",
+			func(c *Config) { c.StartupProbe = &StartupProbeConfig{Network: "tcp"} },
+			fmt.Errorf("startup probe address must be set"),
+		},
 	}
 
 	for _, tc := range tt {
@@ -234,7 +244,7 @@ func TestInstance(t *testing.T) {
 		series:    make(map[uint64]int),
 		directory: walDir,
 	}
-	newWal := func(_ prometheus.Registerer) (walStorage, error) { return &mockStorage, nil }
+	newWal := func(_ log.Logger, _ prometheus.Registerer) (walStorage, error) { return &mockStorage, nil }
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	inst, err := newInstance(globalConfig, cfg, nil, logger, newWal)
@@ -249,6 +259,51 @@ func TestInstance(t *testing.T) {
 	})
 }
 
+func TestInstance_StorageHook(t *testing.T) {
+	scrapeAddr, closeSrv := getTestServer(t)
+	defer closeSrv()
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	globalConfig := getTestGlobalConfig(t)
+
+	cfg := getTestConfig(t, &globalConfig, scrapeAddr)
+	cfg.WALTruncateFrequency = time.Hour
+	cfg.RemoteFlushDeadline = time.Hour
+
+	mockStorage := mockWalStorage{
+		series:    make(map[uint64]int),
+		directory: walDir,
+	}
+	newWal := func(_ log.Logger, _ prometheus.Registerer) (walStorage, error) { return &mockStorage, nil }
+
+	inst, err := newInstance(globalConfig, cfg, nil, log.NewNopLogger(), newWal)
+	require.NoError(t, err)
+
+	ops := make(chan StorageOp, 3)
+	inst.SetStorageHook(func(op StorageOp, dir string) {
+		require.Equal(t, walDir, dir)
+		ops <- op
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		_ = inst.Run(ctx)
+	}()
+	require.Equal(t, StorageOpened, <-ops)
+
+	require.NoError(t, inst.Truncate(context.Background()))
+	require.Equal(t, StorageFlushed, <-ops)
+
+	cancel()
+	<-exited
+	require.Equal(t, StorageClosed, <-ops)
+}
+
 // TestInstance_Recreate ensures that creating an instance with the same name twice
 // does not cause any duplicate metrics registration that leads to a panic.
 func TestInstance_Recreate(t *testing.T) {
@@ -0,0 +1,157 @@
+// Package instancetest provides utilities for testing code that manages
+// Prometheus instances.
+package instancetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/prometheus/prometheus/scrape"
+)
+
+// ErrFailed is returned by failing runs of a FakeInstance when Err is not set.
+var ErrFailed = errors.New("fake instance failed")
+
+// FakeInstance is a deterministic instance.ManagedInstance for testing
+// restart, backoff, and quarantine logic of managers.
+//
+// Each call to Run either fails immediately or succeeds. Runs fail with the
+// values of Errors in order, or, if Errors is empty, with Err for the first
+// FailTimes calls. A run that succeeds blocks until its context is canceled
+// or Block is closed, and then returns nil.
+//
+// The fields must not be changed once the FakeInstance is in use.
+type FakeInstance struct {
+	// FailTimes is the number of runs which fail with Err before Run
+	// succeeds. It is ignored if Errors is set.
+	FailTimes int
+
+	// Err is returned by failing runs. Defaults to ErrFailed.
+	Err error
+
+	// Errors are returned by consecutive runs. A nil error makes the run
+	// succeed. Run succeeds once all Errors have been returned.
+	Errors []error
+
+	// Block, when non-nil, causes successful runs to exit once it's closed.
+	Block <-chan struct{}
+
+	// UpdateErr is returned by Update. Use instance.ErrInvalidUpdate to force
+	// managers to restart the instance when it's updated.
+	UpdateErr error
+
+	// Dir is returned by StorageDirectory.
+	Dir string
+
+	mut  sync.Mutex
+	runs int
+	cfg  *instance.Config
+}
+
+var _ instance.ManagedInstance = (*FakeInstance)(nil)
+
+// Factory returns an instance.Factory which launches f. Every launch is a
+// distinct instance.ManagedInstance, like a real Factory would return, but
+// all launches share the runs and config of f.
+func (f *FakeInstance) Factory() instance.Factory {
+	return func(c instance.Config) (instance.ManagedInstance, error) {
+		f.mut.Lock()
+		defer f.mut.Unlock()
+		f.cfg = &c
+		return &launchedInstance{FakeInstance: f}, nil
+	}
+}
+
+// launchedInstance is a single launch of a FakeInstance through its Factory.
+type launchedInstance struct {
+	*FakeInstance
+}
+
+// Run implements instance.ManagedInstance.
+func (f *FakeInstance) Run(ctx context.Context) error {
+	f.mut.Lock()
+	run := f.runs
+	f.runs++
+	f.mut.Unlock()
+
+	if err := f.runError(run); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-f.Block:
+	}
+	return nil
+}
+
+func (f *FakeInstance) runError(run int) error {
+	if len(f.Errors) > 0 {
+		if run < len(f.Errors) {
+			return f.Errors[run]
+		}
+		return nil
+	}
+
+	if run < f.FailTimes {
+		if f.Err != nil {
+			return f.Err
+		}
+		return ErrFailed
+	}
+	return nil
+}
+
+// Runs returns the number of times Run has been called.
+func (f *FakeInstance) Runs() int {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.runs
+}
+
+// Config returns the config the instance was last launched or successfully
+// updated with. ok is false if the instance was never launched through
+// Factory.
+func (f *FakeInstance) Config() (c instance.Config, ok bool) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if f.cfg == nil {
+		return instance.Config{}, false
+	}
+	return *f.cfg, true
+}
+
+// Update implements instance.ManagedInstance.
+func (f *FakeInstance) Update(c instance.Config) error {
+	if f.UpdateErr != nil {
+		return f.UpdateErr
+	}
+
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.cfg = &c
+	return nil
+}
+
+// TargetsActive implements instance.ManagedInstance.
+func (f *FakeInstance) TargetsActive() map[string][]*scrape.Target {
+	return nil
+}
+
+// StorageDirectory implements instance.ManagedInstance.
+func (f *FakeInstance) StorageDirectory() string {
+	return f.Dir
+}
+
+// StorageSize implements instance.ManagedInstance.
+func (f *FakeInstance) StorageSize() (int64, error) {
+	return 0, nil
+}
+
+// LastScrapeTime implements instance.ManagedInstance.
+func (f *FakeInstance) LastScrapeTime() time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,123 @@
+package instancetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/prom/instance"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeInstance_Run(t *testing.T) {
+	t.Run("fails then succeeds", func(t *testing.T) {
+		errFake := errors.New("fake")
+		inst := &FakeInstance{FailTimes: 2, Err: errFake}
+
+		require.Equal(t, errFake, inst.Run(context.Background()))
+		require.Equal(t, errFake, inst.Run(context.Background()))
+		requireBlocks(t, inst)
+		require.Equal(t, 3, inst.Runs())
+	})
+
+	t.Run("default error", func(t *testing.T) {
+		inst := &FakeInstance{FailTimes: 1}
+		require.Equal(t, ErrFailed, inst.Run(context.Background()))
+	})
+
+	t.Run("specific errors", func(t *testing.T) {
+		errA, errB := errors.New("a"), errors.New("b")
+		inst := &FakeInstance{Errors: []error{errA, errB}, FailTimes: 10}
+
+		require.Equal(t, errA, inst.Run(context.Background()))
+		require.Equal(t, errB, inst.Run(context.Background()))
+		requireBlocks(t, inst)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		block := make(chan struct{})
+		inst := &FakeInstance{Block: block}
+
+		exited := make(chan error)
+		go func() { exited <- inst.Run(context.Background()) }()
+
+		close(block)
+		select {
+		case err := <-exited:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			require.FailNow(t, "run did not exit after closing block")
+		}
+	})
+}
+
+// TestFakeInstance_Quarantine shows how FakeInstance can be used to test the
+// crash loop handling of a manager.
+func TestFakeInstance_Quarantine(t *testing.T) {
+	inst := &FakeInstance{FailTimes: 3}
+
+	cfg := instance.DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.QuarantineThreshold = 3
+	cfg.QuarantineInterval = time.Hour
+
+	cm := instance.NewBasicManager(cfg, log.NewNopLogger(), inst.Factory())
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(instance.Config{Name: "crashloop"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("crashloop")
+		return state == instance.InstanceStateQuarantined
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 3, inst.Runs())
+
+	c, ok := inst.Config()
+	require.True(t, ok)
+	require.Equal(t, "crashloop", c.Name)
+}
+
+// TestFakeInstance_InvalidUpdate ensures that a FakeInstance which is
+// restarted by a manager on update keeps being tracked by the manager.
+func TestFakeInstance_InvalidUpdate(t *testing.T) {
+	inst := &FakeInstance{UpdateErr: instance.ErrInvalidUpdate{Inner: errors.New("fake")}}
+
+	cm := instance.NewBasicManager(instance.DefaultBasicManagerConfig, log.NewNopLogger(), inst.Factory())
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(instance.Config{Name: "test"}))
+	require.Eventually(t, func() bool { return inst.Runs() == 1 }, time.Second, time.Millisecond)
+	first := cm.ListInstances()["test"]
+
+	// Every update restarts the instance. The replaced launches must not take
+	// their successors with them when they're removed from the manager.
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, cm.ApplyConfig(instance.Config{Name: "test", MinWALTime: time.Duration(i) * time.Minute}))
+		require.Eventually(t, func() bool { return inst.Runs() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	// Give the replaced launches a chance to remove themselves from the
+	// manager.
+	time.Sleep(50 * time.Millisecond)
+	insts := cm.ListInstances()
+	require.Len(t, insts, 1)
+	require.True(t, insts["test"] != first, "restarted instance should be a new launch")
+
+	c, ok := inst.Config()
+	require.True(t, ok)
+	require.Equal(t, 10*time.Minute, c.MinWALTime)
+}
+
+// requireBlocks ensures that the next run of inst blocks until its context is
+// canceled.
+func requireBlocks(t *testing.T, inst *FakeInstance) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	require.NoError(t, inst.Run(ctx))
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+}
@@ -0,0 +1,110 @@
+package instance
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instanceLabels exposes the labels of each managed instance through the
+// agent_prometheus_instance_labels metric.
+var instanceLabels = newInstanceLabelsCollector()
+
+func init() {
+	prometheus.MustRegister(instanceLabels)
+}
+
+// instanceLabelsCollector is a prometheus.Collector which exposes the Labels
+// of instance configs as an info-style metric. Each label of a config is
+// exposed as a label_<name> label. Instances which don't have a label used
+// by another instance get an empty value for it, since all series of a
+// metric must have the same label names.
+type instanceLabelsCollector struct {
+	mut    sync.Mutex
+	labels map[string]map[string]string
+}
+
+func newInstanceLabelsCollector() *instanceLabelsCollector {
+	return &instanceLabelsCollector{labels: make(map[string]map[string]string)}
+}
+
+// Set sets the labels for the named instance, removing the instance from
+// the metric if it has no labels.
+func (c *instanceLabelsCollector) Set(name string, labels map[string]string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if len(labels) == 0 {
+		delete(c.labels, name)
+		return
+	}
+	c.labels[name] = labels
+}
+
+// Delete removes the named instance from the metric.
+func (c *instanceLabelsCollector) Delete(name string) {
+	c.Set(name, nil)
+}
+
+// Describe implements prometheus.Collector. No descriptions are sent since
+// the label names of the metric change at runtime.
+func (c *instanceLabelsCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *instanceLabelsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if len(c.labels) == 0 {
+		return
+	}
+
+	var names []string
+	seen := make(map[string]struct{})
+	for _, labels := range c.labels {
+		for name := range labels {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	labelNames := make([]string, 0, len(names)+1)
+	labelNames = append(labelNames, "instance_name")
+	for _, name := range names {
+		labelNames = append(labelNames, "label_"+name)
+	}
+
+	desc := prometheus.NewDesc(
+		"agent_prometheus_instance_labels",
+		"Labels of each Prometheus instance. Always has a value of 1.",
+		labelNames, nil,
+	)
+	for instance, labels := range c.labels {
+		values := make([]string, 0, len(labelNames))
+		values = append(values, instance)
+		for _, name := range names {
+			values = append(values, labels[name])
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+	}
+}
+
+// InstancesByLabel returns the managed instances whose config has the label
+// key set to value.
+func (m *BasicManager) InstancesByLabel(key, value string) map[string]ManagedInstance {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	res := make(map[string]ManagedInstance)
+	for name, proc := range m.processes {
+		if v, ok := proc.cfg.Labels[key]; ok && v == value {
+			res[name] = proc.inst
+		}
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicManager_InstancesByLabel(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a", Labels: map[string]string{"team": "payments"}}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "b", Labels: map[string]string{"team": "payments", "env": "prod"}}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "c", Labels: map[string]string{"team": "search"}}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "d"}))
+
+	requireNames := func(expect []string, res map[string]ManagedInstance) {
+		t.Helper()
+		names := make([]string, 0, len(res))
+		for name := range res {
+			names = append(names, name)
+		}
+		require.ElementsMatch(t, expect, names)
+	}
+	requireNames([]string{"a", "b"}, cm.InstancesByLabel("team", "payments"))
+	requireNames([]string{"b"}, cm.InstancesByLabel("env", "prod"))
+	requireNames(nil, cm.InstancesByLabel("env", ""))
+
+	// Labels can be changed dynamically.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "c", Labels: map[string]string{"team": "payments"}}))
+	requireNames([]string{"a", "b", "c"}, cm.InstancesByLabel("team", "payments"))
+}
+
+func TestInstanceLabelsCollector(t *testing.T) {
+	c := newInstanceLabelsCollector()
+	c.Set("a", map[string]string{"team": "payments"})
+	c.Set("b", map[string]string{"team": "search", "env": "prod"})
+	c.Set("c", nil)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	gather := func() map[string]map[string]string {
+		t.Helper()
+
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+
+		res := make(map[string]map[string]string)
+		for _, mf := range mfs {
+			require.Equal(t, "agent_prometheus_instance_labels", mf.GetName())
+			for _, m := range mf.GetMetric() {
+				require.Equal(t, float64(1), m.GetGauge().GetValue())
+
+				labels := make(map[string]string)
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				res[labels["instance_name"]] = labels
+			}
+		}
+		return res
+	}
+
+	require.Equal(t, map[string]map[string]string{
+		"a": {"instance_name": "a", "label_env": "", "label_team": "payments"},
+		"b": {"instance_name": "b", "label_env": "prod", "label_team": "search"},
+	}, gather())
+
+	c.Delete("a")
+	c.Delete("b")
+	require.Empty(t, gather())
+}
@@ -0,0 +1,113 @@
+package instance
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+)
+
+// logBuffer is an io.Writer which keeps the most recent lines written to it.
+// Each call to Write is treated as a single line.
+type logBuffer struct {
+	mut   sync.Mutex
+	lines []string
+	next  int // Index in lines to write the next line to
+	full  bool
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{lines: make([]string, size)}
+}
+
+// Write implements io.Writer.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.lines[b.next] = string(bytes.TrimRight(p, "\n"))
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered lines, oldest first.
+func (b *logBuffer) Lines() []string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	res := make([]string, 0, len(b.lines))
+	res = append(res, b.lines[b.next:]...)
+	return append(res, b.lines[:b.next]...)
+}
+
+// LogCapturer may optionally be implemented by a ManagedInstance to copy the
+// lines it logs to w, in addition to its own logger. The BasicManager calls
+// CaptureLogs before running the instance when InstanceLogLines is set, so
+// the logs of the instance itself are kept for InstanceLogs.
+type LogCapturer interface {
+	CaptureLogs(w io.Writer)
+}
+
+// teeLogger returns a logger which logs to both a and b. Errors of a are
+// ignored.
+func teeLogger(a, b log.Logger) log.Logger {
+	return log.LoggerFunc(func(keyvals ...interface{}) error {
+		_ = a.Log(keyvals...)
+		return b.Log(keyvals...)
+	})
+}
+
+// instanceLogger returns the logger to use for logs about a single instance.
+// When lines is greater than 0, the last lines logged are also kept in the
+// returned logBuffer, which otherwise is nil.
+func (m *BasicManager) instanceLogger(lines int) (log.Logger, *logBuffer) {
+	if lines <= 0 {
+		return m.logger, nil
+	}
+
+	buf := newLogBuffer(lines)
+	return teeLogger(log.NewLogfmtLogger(buf), m.logger), buf
+}
+
+// contextLogValues returns the log key/value pairs for the values of ctx
+// listed in fields, sorted by field name. Keys without a value are skipped.
+func contextLogValues(ctx context.Context, fields map[string]interface{}) []interface{} {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var kv []interface{}
+	for _, name := range names {
+		if v := ctx.Value(fields[name]); v != nil {
+			kv = append(kv, name, v)
+		}
+	}
+	return kv
+}
+
+// InstanceLogs returns the most recent lines logged for the named instance,
+// oldest first: those logged by the BasicManager about the instance and, if
+// the instance implements LogCapturer, those logged by the instance itself.
+// Up to InstanceLogLines lines are kept per instance. nil is returned if
+// there is no such managed instance or if InstanceLogLines was 0 when the
+// instance was launched.
+func (m *BasicManager) InstanceLogs(name string) []string {
+	m.mut.Lock()
+	proc, ok := m.processes[name]
+	m.mut.Unlock()
+	if !ok || proc.logs == nil {
+		return nil
+	}
+	return proc.logs.Lines()
+}
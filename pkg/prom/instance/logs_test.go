@@ -0,0 +1,184 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestLogBuffer(t *testing.T) {
+	buf := newLogBuffer(3)
+	require.Empty(t, buf.Lines())
+
+	for i := 1; i <= 2; i++ {
+		fmt.Fprintf(buf, "line %d\n", i)
+	}
+	require.Equal(t, []string{"line 1", "line 2"}, buf.Lines())
+
+	for i := 3; i <= 5; i++ {
+		fmt.Fprintf(buf, "line %d\n", i)
+	}
+	require.Equal(t, []string{"line 3", "line 4", "line 5"}, buf.Lines())
+}
+
+func TestBasicManager_InstanceLogs(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if runs.Inc() <= 3 {
+					return fmt.Errorf("failure %d", runs.Load())
+				}
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.InstanceLogLines = 2
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.Nil(t, cm.InstanceLogs("test"))
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool { return runs.Load() == 4 }, time.Second, 10*time.Millisecond)
+
+	lines := cm.InstanceLogs("test")
+	require.Len(t, lines, 2)
+	require.True(t, strings.Contains(lines[0], `err="failure 2"`), lines[0])
+	require.True(t, strings.Contains(lines[1], `err="failure 3"`), lines[1])
+
+	t.Run("disabled", func(t *testing.T) {
+		cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+		defer cm.Stop()
+
+		require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+		require.Nil(t, cm.InstanceLogs("test"))
+	})
+}
+
+// logCapturerInstance is a mockInstance implementing LogCapturer.
+type logCapturerInstance struct {
+	*mockInstance
+	logger log.Logger
+}
+
+func (i *logCapturerInstance) CaptureLogs(w io.Writer) { i.logger = log.NewLogfmtLogger(w) }
+
+func TestBasicManager_InstanceLogs_Captured(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		inst := &logCapturerInstance{logger: log.NewNopLogger()}
+		inst.mockInstance = &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				_ = inst.logger.Log("msg", "scraping")
+				<-ctx.Done()
+				return nil
+			},
+		}
+		return inst, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceLogLines = 10
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		for _, line := range cm.InstanceLogs("test") {
+			if line == "msg=scraping" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+type testContextKey string
+
+func TestBasicManager_ApplyConfigContext(t *testing.T) {
+	const traceIDKey = testContextKey("trace_id")
+
+	runs := atomic.NewInt64(0)
+	values := make(chan interface{}, 2)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				values <- ctx.Value(traceIDKey)
+				if runs.Inc() == 1 {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.InstanceLogLines = 10
+	cfg.ContextLogFields = map[string]interface{}{
+		"trace_id": traceIDKey,
+		"user":     testContextKey("user"),
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// The instance must keep running after the context of the request is
+	// canceled, and still see its values when restarted.
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceIDKey, "abc123"))
+	require.NoError(t, cm.ApplyConfigContext(ctx, Config{Name: "test"}))
+	cancel()
+	require.Equal(t, "abc123", <-values)
+	require.Equal(t, "abc123", <-values)
+	require.Equal(t, InstanceStateRunning, cm.InstanceStatuses()["test"].State)
+
+	lines := cm.InstanceLogs("test")
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		require.True(t, strings.Contains(line, "trace_id=abc123"), line)
+		require.False(t, strings.Contains(line, "user="), line)
+	}
+}
+
+func TestBasicManager_ApplyConfigContext_Canceled(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+	cfg.BlockOnSaturation = true
+	cfg.MaxRestartsInFlight = 1
+	cfg.SaturationTimeout = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.Eventually(t, func() bool {
+		_, ok := cm.BackoffRemaining("a")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, cm.ApplyConfigContext(ctx, Config{Name: "b"}), context.DeadlineExceeded)
+}
@@ -1,9 +1,20 @@
 package instance
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +23,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/scrape"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/atomic"
 )
 
 var (
@@ -20,17 +33,154 @@ var (
 		Help: "Total number of times a Prometheus instance exited unexpectedly, causing it to be restarted.",
 	}, []string{"instance_name"})
 
+	instancePanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_prometheus_instance_panics_total",
+		Help: "Total number of times a Prometheus instance panicked, causing it to be restarted.",
+	}, []string{"instance_name"})
+
+	circuitBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_manager_circuit_breaker_state",
+		Help: "State of the circuit breaker rejecting applies after repeated failures to construct instances: 0 when closed, 1 when open, 2 when half-open.",
+	})
+
+	instanceStartupTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_prometheus_instance_startup_timeouts_total",
+		Help: "Total number of times a Prometheus instance didn't become ready within the startup timeout, causing it to be restarted.",
+	}, []string{"instance_name"})
+
 	currentActiveInstances = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "agent_prometheus_active_instances",
 		Help: "Current number of active instances being used by the agent.",
 	})
 
+	instanceStorageBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_prometheus_instance_storage_bytes",
+		Help: "Size in bytes of the storage directory used by a Prometheus instance.",
+	}, []string{"instance_name"})
+
+	instanceStorageCapExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_prometheus_instance_storage_cap_exceeded_total",
+		Help: "Total number of times the storage of a Prometheus instance was found to be larger than its max_storage_bytes.",
+	}, []string{"instance_name"})
+
+	instanceLastScrapeTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_prometheus_instance_last_scrape_timestamp_seconds",
+		Help: "Unix timestamp of the most recent scrape performed by a Prometheus instance.",
+	}, []string{"instance_name"})
+
+	instanceSilenced = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_prometheus_instance_silenced",
+		Help: "Set to 1 while abnormal exits of a Prometheus instance are silenced with Silence.",
+	}, []string{"instance_name"})
+
+	currentQuarantinedInstances = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_quarantined_instances",
+		Help: "Current number of instances that have been quarantined after repeatedly exiting unexpectedly.",
+	})
+
+	reconcileDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "agent_prometheus_manager_reconcile_duration_seconds",
+		Help:    "How long it took to apply a batch of configs with ApplyConfigs.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	reconcileInstances = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_manager_reconcile_instances",
+		Help: "Number of instances whose config was successfully applied by the most recent batch of configs applied with ApplyConfigs.",
+	})
+
+	instanceLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_manager_instance_limit",
+		Help: "Maximum number of instances the manager will run. 0 means there is no limit.",
+	})
+
+	instanceLimitRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "agent_prometheus_manager_instance_limit_rejections_total",
+		Help: "Total number of configs rejected because applying them would have exceeded the instance limit.",
+	})
+
+	applyOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_prometheus_manager_apply_outcomes_total",
+		Help: "Total number of configs applied with ApplyConfig, by outcome.",
+	}, []string{"outcome"})
+
+	pendingApplies = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_prometheus_manager_pending_applies",
+		Help: "Current number of configs waiting for saturation to clear before being applied.",
+	})
+
 	// DefaultBasicManagerConfig is the default config for the BasicManager.
 	DefaultBasicManagerConfig = BasicManagerConfig{
-		InstanceRestartBackoff: 5 * time.Second,
+		InstanceRestartBackoff:  5 * time.Second,
+		StorageSizeInterval:     time.Minute,
+		StorageCapInterval:      30 * time.Second,
+		LastScrapeInterval:      30 * time.Second,
+		TargetSampleInterval:    time.Minute,
+		TargetDropThreshold:     0.5,
+		PreparedConfigTTL:       5 * time.Minute,
+		IdempotencyKeyTTL:       10 * time.Minute,
+		QuarantineInterval:      10 * time.Minute,
+		RestartStreakResetAfter: time.Minute,
+		OnBeforeStopTimeout:     10 * time.Second,
+		RepeatedErrorLogEvery:   10,
+		StopConcurrency:         runtime.GOMAXPROCS(0) * 4,
+		SaturationTimeout:       30 * time.Second,
+		StorageDirectoryMode:    0750,
+		DependencyTimeout:       time.Minute,
+		ProbationPeriod:         30 * time.Second,
 	}
 )
 
+// InstanceState describes what a managed instance is currently doing.
+type InstanceState string
+
+// Possible states of a managed instance.
+const (
+	// InstanceStateRunning is used when the instance is currently running.
+	InstanceStateRunning InstanceState = "running"
+
+	// InstanceStateBackingOff is used when the instance exited abnormally and
+	// is waiting for InstanceRestartBackoff to elapse before restarting.
+	InstanceStateBackingOff InstanceState = "backing_off"
+
+	// InstanceStateQuarantined is used when the instance exited abnormally too
+	// many times in a row. Quarantined instances are only restarted every
+	// QuarantineInterval and stay quarantined until their streak of
+	// abnormal exits is reset.
+	InstanceStateQuarantined InstanceState = "quarantined"
+
+	// InstanceStateWaitingForDependency is used when the instance has a
+	// startup probe and is waiting for it to succeed before running.
+	InstanceStateWaitingForDependency InstanceState = "waiting_for_dependency"
+
+	// InstanceStateStarting is used when the instance implements
+	// ReadinessReporter, StartupTimeout is set and the instance was run but
+	// isn't ready yet.
+	InstanceStateStarting InstanceState = "starting"
+
+	// InstanceStateSuspended is used when the instance exited abnormally
+	// while restarts are suspended with SuspendRestarts and is waiting for
+	// the suspension to end before restarting.
+	InstanceStateSuspended InstanceState = "suspended"
+)
+
+// ManagerState describes the lifecycle of a BasicManager.
+type ManagerState string
+
+// Possible states of a BasicManager.
+const (
+	// ManagerStateRunning is used when the manager accepts configs.
+	ManagerStateRunning ManagerState = "running"
+
+	// ManagerStateStopping is used while Stop is stopping the managed
+	// instances.
+	ManagerStateStopping ManagerState = "stopping"
+
+	// ManagerStateStopped is used once Stop has returned. Stopped managers
+	// can't be reused; a new manager must be created instead.
+	ManagerStateStopped ManagerState = "stopped"
+)
+
 // Manager represents a set of methods for manipulating running instances at
 // runtime.
 type Manager interface {
@@ -42,6 +192,11 @@ type Manager interface {
 	// instance. The key will be the Name field from Config.
 	ListConfigs() map[string]Config
 
+	// InstanceStatuses returns the status of all currently managed instances
+	// running within the Manager. The keys will match the keys from
+	// ListInstances.
+	InstanceStatuses() map[string]InstanceStatus
+
 	// ApplyConfig creates a new Config or updates an existing Config if
 	// one with Config.Name already exists.
 	ApplyConfig(Config) error
@@ -60,28 +215,415 @@ type ManagedInstance interface {
 	Update(c Config) error
 	TargetsActive() map[string][]*scrape.Target
 	StorageDirectory() string
+
+	// StorageSize returns the size in bytes of StorageDirectory.
+	StorageSize() (int64, error)
+
+	// LastScrapeTime returns the time of the most recent scrape of any
+	// target. The zero time is returned if nothing has been scraped yet.
+	LastScrapeTime() time.Time
+}
+
+// TargetsNotifier may optionally be implemented by a ManagedInstance to notify
+// the Manager when its active targets change.
+type TargetsNotifier interface {
+	// TargetsChanged returns a channel which receives a value whenever the
+	// result of TargetsActive may have changed.
+	TargetsChanged() <-chan struct{}
+}
+
+// ReadinessReporter may optionally be implemented by a ManagedInstance to
+// report when it finished initializing after being run. The BasicManager uses
+// it to detect instances stuck while starting; see
+// BasicManagerConfig.StartupTimeout.
+type ReadinessReporter interface {
+	// Ready returns whether the instance finished initializing since Run was
+	// last called.
+	Ready() bool
+}
+
+// Truncater may optionally be implemented by a ManagedInstance to free up
+// storage on demand. The BasicManager calls Truncate when the storage of the
+// instance grows over the MaxStorageBytes of its config.
+type Truncater interface {
+	Truncate(ctx context.Context) error
+}
+
+// StatsReporter may optionally be implemented by a ManagedInstance to report
+// all of its runtime statistics at once. See BasicManager.InstanceStats.
+type StatsReporter interface {
+	Stats() InstanceStats
+}
+
+// InstanceStats is a snapshot of the runtime statistics of an instance.
+// Statistics an instance doesn't report are left zero-valued. New statistics
+// may be added over time.
+type InstanceStats struct {
+	ActiveTargets  int       // Number of active targets
+	StorageSize    int64     // Size in bytes of the storage directory
+	LastScrapeTime time.Time // Most recent scrape of any target
+	StartTime      time.Time // When the instance was last run
+}
+
+// Uptime returns how long the instance has been running since StartTime.
+// Returns 0 if StartTime isn't known.
+func (s InstanceStats) Uptime() time.Duration {
+	if s.StartTime.IsZero() {
+		return 0
+	}
+	return time.Since(s.StartTime)
+}
+
+// HealthReporter may optionally be implemented by a ManagedInstance to report
+// a human-readable description of its health.
+type HealthReporter interface {
+	// HealthMessage returns a description of the instance's health, such as
+	// the reason it isn't working. An empty string is returned if there is
+	// nothing to report.
+	HealthMessage() string
+}
+
+// InstanceStatus describes the current status of a managed instance.
+type InstanceStatus struct {
+	State InstanceState
+
+	// HealthMessage is reported by instances that implement HealthReporter.
+	// It is always empty for other instances.
+	HealthMessage string
+}
+
+// BackoffStrategy decides how long to wait before restarting an instance that
+// exited abnormally. Each managed instance gets its own BackoffStrategy, and
+// calls to it are serialized.
+type BackoffStrategy interface {
+	// Next returns the backoff before the next restart, where attempt is the
+	// number of consecutive abnormal exits so far, starting at 1.
+	Next(attempt int) time.Duration
+
+	// Reset is called whenever the streak of consecutive abnormal exits is
+	// reset.
+	Reset()
 }
 
+// configBackoff is the default BackoffStrategy, always backing off for the
+// currently configured InstanceRestartBackoff.
+type configBackoff struct{ m *BasicManager }
+
+func (b configBackoff) Next(int) time.Duration {
+	b.m.cfgMut.Lock()
+	defer b.m.cfgMut.Unlock()
+	return b.m.cfg.InstanceRestartBackoff
+}
+
+func (b configBackoff) Reset() {}
+
 // BasicManagerConfig controls the operations of a BasicManager.
 type BasicManagerConfig struct {
 	InstanceRestartBackoff time.Duration
+
+	// NewBackoffStrategy creates the BackoffStrategy for a new instance. If
+	// nil, instances always back off for InstanceRestartBackoff.
+	NewBackoffStrategy func() BackoffStrategy
+
+	// StorageSizeInterval is how often the storage size of each instance is
+	// measured for the agent_prometheus_instance_storage_bytes metric. The
+	// metric is not periodically updated if StorageSizeInterval is 0.
+	StorageSizeInterval time.Duration
+
+	// StorageCapInterval is how often the storage size of each instance is
+	// compared to the MaxStorageBytes of its config. Instances over their
+	// cap are truncated if they implement Truncater, and logged about
+	// otherwise. Storage sizes are never compared if StorageCapInterval is
+	// 0.
+	StorageCapInterval time.Duration
+
+	// LastScrapeInterval is how often the last scrape time of each instance
+	// is checked for the
+	// agent_prometheus_instance_last_scrape_timestamp_seconds metric. The
+	// metric is not periodically updated if LastScrapeInterval is 0.
+	LastScrapeInterval time.Duration
+
+	// QuarantineThreshold is the number of consecutive abnormal exits after
+	// which an instance is quarantined. Quarantined instances are restarted
+	// every QuarantineInterval instead of every InstanceRestartBackoff.
+	// Instances are never quarantined if QuarantineThreshold is 0.
+	QuarantineThreshold int
+	QuarantineInterval  time.Duration
+
+	// RestartStreakResetAfter is how long an instance must run for its streak
+	// of consecutive abnormal exits to be reset. An instance that exits
+	// abnormally after running for at least RestartStreakResetAfter starts a
+	// new streak of 1; an instance that exits any sooner increments its
+	// streak. Streaks are only reset by ResetBackoffs if
+	// RestartStreakResetAfter is 0.
+	RestartStreakResetAfter time.Duration
+
+	// OnBeforeStop, if set, is invoked with the name and config of an
+	// instance right before it is stopped, whether it is being deleted,
+	// restarted with a new config, or the BasicManager itself is stopping.
+	// OnBeforeStop must not call back into the BasicManager.
+	//
+	// The instance is stopped once OnBeforeStop returns or after
+	// OnBeforeStopTimeout elapses, whichever comes first. A zero
+	// OnBeforeStopTimeout uses the timeout from DefaultBasicManagerConfig.
+	OnBeforeStop        func(name string, cfg Config)
+	OnBeforeStopTimeout time.Duration
+
+	// StorageDirectory is the root directory under which instances keep their
+	// storage, with one subdirectory per instance name. When set, new
+	// instances are only launched if StorageDirectory exists and is writable,
+	// unless SkipStorageCheck is true.
+	//
+	// StorageDirectory and any missing parents are created before launching
+	// a new instance, with the permissions of StorageDirectoryMode before the
+	// umask is applied. A zero StorageDirectoryMode uses the mode from
+	// DefaultBasicManagerConfig. Nothing is created if SkipStorageCheck is
+	// true.
+	StorageDirectory     string
+	SkipStorageCheck     bool
+	StorageDirectoryMode os.FileMode
+
+	// StorageDirFunc, if set, returns the directory the instance for a
+	// config keeps its storage in. When nil, DefaultStorageDir is used with
+	// StorageDirectory, which is also the layout used by New. Factories
+	// used with a custom StorageDirFunc must store the data of their
+	// instances in the directory returned by BasicManager.StorageDir so it
+	// matches the one PurgeOrphanedStorage keeps. PurgeOrphanedStorage only
+	// considers direct children of StorageDirectory.
+	StorageDirFunc func(c Config) string
+
+	// RepeatedErrorLogEvery limits logging of an instance that keeps exiting
+	// with the same error. The first occurrence of an error is always logged;
+	// consecutive repeats of it are only logged every RepeatedErrorLogEvery
+	// occurrences along with the number of times it repeated. Every
+	// occurrence is logged if RepeatedErrorLogEvery is 0 or 1.
+	RepeatedErrorLogEvery int
+
+	// StopConcurrency is the maximum number of instances that Stop will stop
+	// at the same time. There is no limit if StopConcurrency is 0.
+	StopConcurrency int
+
+	// ValidateConfig validates configs applied through ApplyConfigFromReader,
+	// applying defaults to them. When nil, Config.ApplyDefaults is used with
+	// DefaultGlobalConfig.
+	ValidateConfig func(c *Config) error
+
+	// InstanceLogLines is the number of lines logged by or about each
+	// instance that are kept in memory for InstanceLogs. No lines are kept
+	// if InstanceLogLines is 0.
+	InstanceLogLines int
+
+	// BlockOnSaturation makes ApplyConfig wait before launching a new
+	// instance while MaxRestartsInFlight or more instances are backing off
+	// before being restarted. ApplyConfig fails with ErrSaturated if that's
+	// still the case after SaturationTimeout. BlockOnSaturation has no effect
+	// if MaxRestartsInFlight is 0.
+	BlockOnSaturation   bool
+	MaxRestartsInFlight int
+	SaturationTimeout   time.Duration
+
+	// OnConfigApplied, if set, is invoked after ApplyConfig successfully
+	// applies a config, along with how it was applied. OnConfigDeleted, if
+	// set, is invoked after a config is removed through DeleteConfig or
+	// DeleteConfigs. Instances moved away with Handoff aren't deleted.
+	//
+	// Calls to both hooks are serialized, in the order the changes were made
+	// for each instance.
+	// The hooks may read from the BasicManager but must not apply or delete
+	// configs.
+	OnConfigApplied func(c Config, result ApplyConfigResult)
+	OnConfigDeleted func(name string)
+
+	// OnConfigRestarted, if set, is invoked right after OnConfigApplied when
+	// ApplyConfig restarted the instance because it couldn't be updated
+	// dynamically. reason is the error returned by the Update of the
+	// instance; its Fields list the fields whose change required the
+	// restart. The same restrictions as OnConfigApplied apply.
+	OnConfigRestarted func(c Config, reason ErrInvalidUpdate)
+
+	// OnStorageOpen, OnStorageFlush and OnStorageClose, if set, are invoked
+	// when an instance opens, flushes or closes its storage, along with the
+	// name of the instance and the directory of its storage. This allows
+	// coordinating backups of the storage with the instances writing to it.
+	// Only instances implementing StorageNotifier report these operations.
+	//
+	// The hooks are invoked synchronously by the instance, which waits for
+	// them to return: they must not block for long.
+	OnStorageOpen  func(name, dir string)
+	OnStorageFlush func(name, dir string)
+	OnStorageClose func(name, dir string)
+
+	// Tracer, if set, records a span for each ApplyConfig, DeleteConfig and
+	// instance restart, along with the name of the instance and the outcome.
+	// Restarts after an abnormal exit include the exit error and span the
+	// backoff period.
+	Tracer trace.Tracer
+
+	// MetricLabelFunc, if set, transforms instance names before they're used
+	// as the instance_name label of metrics, for example to hash or namespace
+	// them. Logs and lookups keep using the real name. The label of an
+	// instance is computed when it's launched and kept until it's removed.
+	// Names that are transformed into the same label share series.
+	MetricLabelFunc func(name string) string
+
+	// MaxInstances is the maximum number of instances the BasicManager runs.
+	// ApplyConfig fails with ErrInstanceLimitReached instead of launching an
+	// instance beyond MaxInstances; existing instances can always be updated.
+	// There is no limit if MaxInstances is 0.
+	MaxInstances int
+
+	// ContextLogFields maps log field names to context keys. When the
+	// context given to ApplyConfigContext holds a value for one of the keys,
+	// logs about the instance it launches include the value under the field
+	// name. This allows correlating instances with the requests that caused
+	// them to be launched, for example through a trace ID.
+	ContextLogFields map[string]interface{}
+
+	// DependencyTimeout is how long ApplyConfigs waits for the dependencies
+	// of a config to be running before failing to apply it. A zero
+	// DependencyTimeout uses the timeout from DefaultBasicManagerConfig.
+	DependencyTimeout time.Duration
+
+	// StartupDelay delays the first run of newly launched instances by
+	// StartupDelay plus a random duration of up to StartupJitter. This
+	// spreads out the load of starting many instances at once, such as when
+	// the agent boots. Instances restarted because of a new config aren't
+	// delayed.
+	StartupDelay  time.Duration
+	StartupJitter time.Duration
+
+	// StartupTimeout is how long an instance implementing ReadinessReporter
+	// may take to become ready each time it's run. Instances which aren't
+	// ready in time are stopped and restarted as if they exited abnormally,
+	// which catches initialization hanging on things like DNS resolution.
+	// Instances are in InstanceStateStarting until they're ready. Readiness
+	// isn't checked if StartupTimeout is 0.
+	StartupTimeout time.Duration
+
+	// OnAbnormalExit, if set, is invoked with the name of an instance and the
+	// error it exited with every time it exits abnormally, unless the
+	// instance is silenced with Silence. It's called from the goroutine
+	// running the instance before it backs off, so it should return quickly.
+	OnAbnormalExit func(name string, err error)
+
+	// OnTargetDrop, if set, is invoked with the name of an instance when its
+	// number of active targets drops by more than TargetDropThreshold, a
+	// fraction between 0 and 1, from one sample to the next. This catches
+	// service discovery outages which don't make the instance exit. Active
+	// targets are sampled every TargetSampleInterval, which can't be changed
+	// for instances that are already running. Targets aren't sampled if
+	// TargetSampleInterval is 0.
+	OnTargetDrop         func(name string, from, to int)
+	TargetSampleInterval time.Duration
+	TargetDropThreshold  float64
+
+	// PreparedConfigTTL is how long a config prepared with PrepareConfig
+	// waits to be committed before being discarded. A zero
+	// PreparedConfigTTL uses the TTL from DefaultBasicManagerConfig.
+	PreparedConfigTTL time.Duration
+
+	// IdempotencyKeyTTL is how long ApplyConfigWithKey remembers the result
+	// of an apply made with a key. A zero IdempotencyKeyTTL uses the TTL from
+	// DefaultBasicManagerConfig.
+	IdempotencyKeyTTL time.Duration
+
+	// ProbationPeriod is how long ApplyConfigTx watches an instance after
+	// applying its config before considering the config good. A zero
+	// ProbationPeriod uses the period from DefaultBasicManagerConfig.
+	ProbationPeriod time.Duration
+
+	// CircuitBreakerThreshold is the number of consecutive failures of the
+	// Factory, across all instances, after which ApplyConfig, Rollback and
+	// PrepareConfig fail with ErrCircuitOpen instead of calling the Factory.
+	// This keeps applies from hammering a failing resource shared by all
+	// instances, such as a read-only storage volume. Dynamic updates of
+	// running instances don't call the Factory and aren't affected. After
+	// CircuitBreakerCooldown, a single call to the Factory is let through:
+	// the breaker closes if it succeeds and opens again if it fails. There is
+	// no circuit breaker if CircuitBreakerThreshold is 0.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+
+	// CacheListConfigs makes ListConfigs serve a cached snapshot of the
+	// configs without locking the BasicManager. The snapshot is dropped
+	// whenever an instance is added, removed or updated, so it's never
+	// stale. This helps when ListConfigs is called much more often than
+	// configs change, such as by a frequently polled status endpoint.
+	CacheListConfigs bool
 }
 
 // BasicManager creates a new BasicManager, implementing the Manager interface.
 // BasicManager will directly launch instances and perform no extra processing.
 //
 // Other implementations of Manager usually wrap a BasicManager.
+//
+// Errors returned by the operations of BasicManager, such as ApplyConfig and
+// DeleteConfig, wrap a *ManagerError whose Code classifies the failure.
 type BasicManager struct {
 	cfgMut sync.Mutex
 	cfg    BasicManagerConfig
 	logger log.Logger
 
+	// applyMut serializes changes to the set of instances so mut can be
+	// released while they wait for a process to stop. Changes to a single
+	// instance hold it for reading along with the lock for the instance name
+	// in nameLocks (see lockName); changes spanning several instances hold it
+	// for writing.
+	applyMut  sync.RWMutex
+	nameLocks nameLocks
+
+	// hooksMut serializes calls to the OnConfig hooks.
+	hooksMut sync.Mutex
+
 	// Take care when locking mut: if you hold onto a lock of mut while calling
 	// Stop on a process, you will deadlock.
 	mut       sync.Mutex
+	state     ManagerState
 	processes map[string]*managedProcess
 
+	// launch is guarded by mut so it may be swapped out at runtime through
+	// SetFactory.
 	launch Factory
+
+	targetSubsMut sync.Mutex
+	targetSubs    map[chan string]struct{}
+
+	eventSubsMut sync.Mutex
+	eventSubs    map[chan Event]struct{}
+	eventsClosed bool
+
+	// silences holds the active silences by instance name. Guarded by mut.
+	silences map[string]*silence
+
+	// suspension is the active suspension of restarts set by SuspendRestarts,
+	// if any. Guarded by mut.
+	suspension *restartSuspension
+
+	// prepared holds the configs staged by PrepareConfig by token. Guarded
+	// by mut.
+	prepared map[string]*preparedConfig
+
+	// keyedApplies holds the applies made by ApplyConfigWithKey by key.
+	// Guarded by keyedAppliesMut.
+	keyedAppliesMut sync.Mutex
+	keyedApplies    map[string]*keyedApply
+
+	// previous holds the config each instance had before it was last
+	// changed, for Rollback. Guarded by mut.
+	previous map[string]Config
+
+	// pending counts the applies waiting for saturation to clear by config
+	// name. Guarded by mut.
+	pending map[string]int
+
+	// exitWatchers receive the abnormal exits of instances by instance name
+	// while ApplyConfigTx watches them.
+	exitWatchersMut sync.Mutex
+	exitWatchers    map[string]map[chan error]struct{}
+
+	breaker circuitBreaker
+
+	configsCache configsCache
 }
 
 // managedProcess represents a goroutine running a ManagedInstance. cancel
@@ -92,13 +634,249 @@ type managedProcess struct {
 	inst   ManagedInstance
 	cancel context.CancelFunc
 	done   chan bool
+
+	logger log.Logger
+	logs   *logBuffer // Lines logged to logger; nil if not kept
+
+	metricLabel string // Value of the instance_name label of metrics
+
+	// Runtime state of the process, updated by the goroutine running the
+	// process.
+	stateMut    sync.Mutex
+	state       InstanceState
+	streak      int           // Consecutive abnormal exits
+	lastErr     error         // Error of the last abnormal exit
+	wake        chan struct{} // Closed to cut short an in-progress backoff
+	wakeAt      time.Time     // End of the in-progress backoff, if any
+	strategy    BackoffStrategy
+	lastBackoff time.Duration // Last backoff computed by restartBackoff
+
+	restartRun       context.CancelFunc // Cancels the current run of the instance
+	restartRequested bool               // Set by requestRestart
+	replaced         bool               // Set when replaced by a new process
 }
 
-func (p managedProcess) Stop() {
+func (p *managedProcess) Stop() {
 	p.cancel()
 	<-p.done
 }
 
+// State returns the current state of the process.
+func (p *managedProcess) State() InstanceState {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	return p.state
+}
+
+// Status returns the current status of the process.
+func (p *managedProcess) Status() InstanceStatus {
+	status := InstanceStatus{State: p.State()}
+	if hr, ok := p.inst.(HealthReporter); ok {
+		status.HealthMessage = hr.HealthMessage()
+	}
+	return status
+}
+
+func (p *managedProcess) setState(s InstanceState) {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	p.setStateLocked(s)
+}
+
+// swapState puts the process into state to if it's in state from.
+func (p *managedProcess) swapState(from, to InstanceState) {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	if p.state == from {
+		p.setStateLocked(to)
+	}
+}
+
+func (p *managedProcess) setStateLocked(s InstanceState) {
+	switch {
+	case p.state != InstanceStateQuarantined && s == InstanceStateQuarantined:
+		currentQuarantinedInstances.Inc()
+	case p.state == InstanceStateQuarantined && s != InstanceStateQuarantined:
+		currentQuarantinedInstances.Dec()
+	}
+	p.state = s
+}
+
+// nextBackoff returns the backoff to use after attempt consecutive abnormal
+// exits.
+func (p *managedProcess) nextBackoff(attempt int) time.Duration {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	return p.strategy.Next(attempt)
+}
+
+// setBackoff records d as the current backoff of the process.
+func (p *managedProcess) setBackoff(d time.Duration) {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	p.lastBackoff = d
+}
+
+// currentBackoff returns the backoff recorded by setBackoff.
+func (p *managedProcess) currentBackoff() time.Duration {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	return p.lastBackoff
+}
+
+// failed records an abnormal exit caused by err and returns the new streak of
+// consecutive abnormal exits.
+func (p *managedProcess) failed(err error) int {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	p.streak++
+	p.lastErr = err
+	return p.streak
+}
+
+// resetStreak resets the streak of consecutive abnormal exits, taking the
+// process out of quarantine.
+func (p *managedProcess) resetStreak() {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	p.resetStreakLocked()
+}
+
+func (p *managedProcess) resetStreakLocked() {
+	p.streak = 0
+	p.lastBackoff = 0
+	p.strategy.Reset()
+	if p.state == InstanceStateQuarantined {
+		p.setStateLocked(InstanceStateRunning)
+	}
+}
+
+// resetBackoff resets the streak of consecutive abnormal exits and cuts short
+// any in-progress backoff.
+func (p *managedProcess) resetBackoff() {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+
+	p.resetStreakLocked()
+	if p.wake != nil {
+		close(p.wake)
+		p.wake = nil
+	}
+}
+
+// requestRestart restarts the instance, interrupting either the current run
+// or an in-progress backoff.
+func (p *managedProcess) requestRestart() {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+
+	p.restartRequested = true
+	if p.restartRun != nil {
+		p.restartRun()
+	}
+	if p.wake != nil {
+		close(p.wake)
+		p.wake = nil
+	}
+}
+
+// takeRestartRequest returns whether a restart was requested, clearing the
+// request.
+func (p *managedProcess) takeRestartRequest() bool {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+
+	requested := p.restartRequested
+	p.restartRequested = false
+	return requested
+}
+
+// runContext returns a child of ctx to run the instance with, which is
+// canceled by requestRestart.
+func (p *managedProcess) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	runCtx, cancel := context.WithCancel(ctx)
+
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	p.restartRun = cancel
+	return runCtx, cancel
+}
+
+func (p *managedProcess) setReplaced() {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	p.replaced = true
+}
+
+func (p *managedProcess) wasReplaced() bool {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	return p.replaced
+}
+
+// backoff puts the process into state s and waits for d to elapse. backoff
+// returns early if the backoff is cut short by resetBackoff. Returns false if
+// ctx was canceled while waiting.
+func (p *managedProcess) backoff(ctx context.Context, d time.Duration, s InstanceState) bool {
+	wake := make(chan struct{})
+
+	p.stateMut.Lock()
+	p.setStateLocked(s)
+	p.wake = wake
+	p.wakeAt = time.Now().Add(d)
+	p.stateMut.Unlock()
+
+	defer func() {
+		p.stateMut.Lock()
+		p.wake = nil
+		p.wakeAt = time.Time{}
+		p.stateMut.Unlock()
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	case <-wake:
+		return true
+	}
+}
+
+// backoffRemaining returns the time left before the in-progress backoff ends.
+// Returns false if the process isn't backing off.
+func (p *managedProcess) backoffRemaining() (time.Duration, bool) {
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+	return p.backoffRemainingLocked()
+}
+
+// backoffRemainingLocked implements backoffRemaining. stateMut must be held
+// when calling backoffRemainingLocked.
+func (p *managedProcess) backoffRemainingLocked() (time.Duration, bool) {
+	if p.wake == nil {
+		return 0, false
+	}
+	if left := time.Until(p.wakeAt); left > 0 {
+		return left, true
+	}
+	return 0, true
+}
+
+// valuesContext is a context holding the values of its parent without being
+// canceled along with it.
+type valuesContext struct {
+	parent context.Context
+}
+
+func (valuesContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (valuesContext) Done() <-chan struct{}               { return nil }
+func (valuesContext) Err() error                          { return nil }
+func (c valuesContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
 // Factory should return an unstarted instance given some config.
 type Factory func(c Config) (ManagedInstance, error)
 
@@ -110,19 +888,95 @@ type Factory func(c Config) (ManagedInstance, error)
 // if stopped, updated if the config changes, or removed when the Config is
 // deleted.
 func NewBasicManager(cfg BasicManagerConfig, logger log.Logger, launch Factory) *BasicManager {
-	return &BasicManager{
+	instanceLimit.Set(float64(cfg.MaxInstances))
+
+	m := &BasicManager{
 		cfg:       cfg,
 		logger:    logger,
+		state:     ManagerStateRunning,
 		processes: make(map[string]*managedProcess),
 		launch:    launch,
+
+		targetSubs: make(map[chan string]struct{}),
+		eventSubs:  make(map[chan Event]struct{}),
+		silences:   make(map[string]*silence),
+		prepared:   make(map[string]*preparedConfig),
+		previous:   make(map[string]Config),
+		pending:    make(map[string]int),
+
+		exitWatchers: make(map[string]map[chan error]struct{}),
+		keyedApplies: make(map[string]*keyedApply),
 	}
+	m.configsCache.enabled.Store(cfg.CacheListConfigs)
+	return m
+}
+
+// State returns the current lifecycle state of the BasicManager.
+func (m *BasicManager) State() ManagerState {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.state
 }
 
 // UpdateManagerConfig updates the BasicManagerConfig.
+//
+// Lowering MaxInstances or MaxRestartsInFlight below the current usage doesn't
+// stop any instance: existing instances keep running and can still be
+// updated, but new instances aren't admitted until usage drops below the new
+// limit. A warning is logged when that happens.
 func (m *BasicManager) UpdateManagerConfig(c BasicManagerConfig) {
 	m.cfgMut.Lock()
-	defer m.cfgMut.Unlock()
 	m.cfg = c
+	instanceLimit.Set(float64(c.MaxInstances))
+	m.cfgMut.Unlock()
+
+	m.mut.Lock()
+	m.configsCache.enabled.Store(c.CacheListConfigs)
+	m.configsCache.invalidate()
+	m.mut.Unlock()
+
+	m.warnOverCapacity(c)
+}
+
+// warnOverCapacity logs a warning for each limit of c which current usage is
+// already at or over.
+func (m *BasicManager) warnOverCapacity(c BasicManagerConfig) {
+	m.mut.Lock()
+	var (
+		instances = len(m.processes)
+		inFlight  int
+	)
+	for _, proc := range m.processes {
+		if proc.State() == InstanceStateBackingOff {
+			inFlight++
+		}
+	}
+	m.mut.Unlock()
+
+	if c.MaxInstances > 0 && instances >= c.MaxInstances {
+		level.Warn(m.logger).Log("msg", "instance limit is at or below the number of running instances, new instances will be rejected until some are deleted", "limit", c.MaxInstances, "instances", instances)
+	}
+	if c.BlockOnSaturation && c.MaxRestartsInFlight > 0 && inFlight >= c.MaxRestartsInFlight {
+		level.Warn(m.logger).Log("msg", "restarts in flight limit is at or below the number of instances backing off, new instances will block until restarts complete", "limit", c.MaxRestartsInFlight, "restarts_in_flight", inFlight)
+	}
+}
+
+// ManagerConfig returns a copy of the BasicManagerConfig currently in effect.
+func (m *BasicManager) ManagerConfig() BasicManagerConfig {
+	m.cfgMut.Lock()
+	defer m.cfgMut.Unlock()
+	return m.cfg
+}
+
+// SetFactory replaces the Factory used to launch new instances. Existing
+// instances are unaffected and keep running their old implementation,
+// including when they are restarted after an abnormal exit. The new Factory
+// will only be used the next time an instance is spawned: when a new config is
+// applied or when an update to an existing config forces a full restart.
+func (m *BasicManager) SetFactory(f Factory) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.launch = f
 }
 
 // ListInstances returns the current active instances managed by BasicManager.
@@ -137,211 +991,1847 @@ func (m *BasicManager) ListInstances() map[string]ManagedInstance {
 	return res
 }
 
-// ListConfigs lists the current active configs managed by BasicManager.
-func (m *BasicManager) ListConfigs() map[string]Config {
+// listProcesses returns a copy of the managed processes, keyed by instance
+// name.
+func (m *BasicManager) listProcesses() map[string]*managedProcess {
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
-	res := make(map[string]Config, len(m.processes))
-	for name, process := range m.processes {
-		res[name] = process.cfg
+	res := make(map[string]*managedProcess, len(m.processes))
+	for name, proc := range m.processes {
+		res[name] = proc
 	}
 	return res
 }
 
-// ApplyConfig takes a Config and either starts a new managed instance or
-// updates an existing managed instance. The value for Name in c is used to
-// uniquely identify the Config and determine whether the Config has an
-// existing associated managed instance.
-func (m *BasicManager) ApplyConfig(c Config) error {
+// Instance returns the active instance with the given name, if any, without
+// copying the whole set of instances like ListInstances does.
+func (m *BasicManager) Instance(name string) (ManagedInstance, bool) {
 	m.mut.Lock()
 	defer m.mut.Unlock()
 
-	// If the config already exists, we need to update it.
-	proc, ok := m.processes[c.Name]
-	if ok {
-		err := proc.inst.Update(c)
-
-		// If the instance could not be dynamically updated, we need to force the
-		// update by restarting it. If it failed for another reason, something
-		// serious went wrong and we'll completely give up without stopping the
-		// existing job.
-		if errors.Is(err, ErrInvalidUpdate{}) {
-			level.Info(m.logger).Log("msg", "could not dynamically update instance, will manually restart", "instance", c.Name, "reason", err)
+	proc, ok := m.processes[name]
+	if !ok {
+		return nil, false
+	}
+	return proc.inst, true
+}
 
-			// NOTE: we don't return here; we fall through to spawn the new instance.
-			proc.Stop()
-		} else if err != nil {
-			return fmt.Errorf("failed to update instance %s: %w", c.Name, err)
-		} else {
-			level.Info(m.logger).Log("msg", "dynamically updated instance", "instance", c.Name)
+// ResetBackoffs resets the restart backoff state of all managed instances:
+// streaks of abnormal exits are cleared, quarantined instances are taken out of
+// quarantine, and instances currently waiting out a backoff are restarted
+// immediately. Any future abnormal exits will use the BasicManagerConfig that
+// is in effect at the time of the exit.
+//
+// UpdateManagerConfig does not call ResetBackoffs; call it after
+// UpdateManagerConfig to have changes to backoff settings take effect
+// immediately.
+func (m *BasicManager) ResetBackoffs() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
 
-			proc.cfg = c
-			return nil
-		}
+	for _, proc := range m.processes {
+		proc.resetBackoff()
 	}
+}
 
-	// Spawn a new process for the new config.
-	err := m.spawnProcess(c)
-	if err != nil {
-		return err
-	}
+// InstanceState returns the current state of the managed instance with the
+// given name. Returns false if there is no such managed instance.
+func (m *BasicManager) InstanceState(name string) (InstanceState, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
 
-	currentActiveInstances.Inc()
-	return nil
+	proc, ok := m.processes[name]
+	if !ok {
+		return "", false
+	}
+	return proc.State(), true
 }
 
-func (m *BasicManager) spawnProcess(c Config) error {
-	inst, err := m.launch(c)
+// CurrentBackoff returns the backoff the named instance last waited for, or
+// is waiting for, before being restarted after an abnormal exit. Quarantined
+// instances report the quarantine interval. The backoff is 0 if the instance
+// hasn't exited abnormally since its streak of abnormal exits was last reset.
+// ErrConfigNotFound is returned if there is no instance with the given name.
+func (m *BasicManager) CurrentBackoff(name string) (time.Duration, error) {
+	m.mut.Lock()
+	proc, ok := m.processes[name]
+	m.mut.Unlock()
+	if !ok {
+		return 0, wrapError(name, CodeNotFound, ErrConfigNotFound)
+	}
+	return proc.currentBackoff(), nil
+}
+
+// BackoffRemaining returns the time left before the named instance is
+// restarted, if it is currently backing off after an abnormal exit or
+// quarantined. Returns false if there is no instance with the given name or
+// if it isn't backing off.
+func (m *BasicManager) BackoffRemaining(name string) (time.Duration, bool) {
+	m.mut.Lock()
+	proc, ok := m.processes[name]
+	m.mut.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return proc.backoffRemaining()
+}
+
+// runningPollInterval is how often WaitAllRunning and ApplyConfigs check
+// whether instances are running.
+const runningPollInterval = 50 * time.Millisecond
+
+// WaitAllRunning blocks until all current instances are in
+// InstanceStateRunning, returning nil immediately if there are no instances.
+// When ctx is done first, the returned error lists the instances which
+// still weren't running along with their states and wraps the error of ctx.
+func (m *BasicManager) WaitAllRunning(ctx context.Context) error {
+	return m.waitRunning(ctx, nil)
+}
+
+// waitRunning implements WaitAllRunning for the named instances, or for all
+// instances if names is nil.
+func (m *BasicManager) waitRunning(ctx context.Context, names []string) error {
+	ticker := time.NewTicker(runningPollInterval)
+	defer ticker.Stop()
+
+	for {
+		notRunning := m.instancesNotRunning(names)
+		if len(notRunning) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("instances not running: %s: %w", strings.Join(notRunning, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// instancesNotRunning returns the sorted names and states of the named
+// instances, or of all instances if names is nil, which aren't in
+// InstanceStateRunning, formatted as "name (state)". Named instances which
+// don't exist are reported as not found.
+func (m *BasicManager) instancesNotRunning(names []string) []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if names == nil {
+		for name := range m.processes {
+			names = append(names, name)
+		}
+	}
+
+	var res []string
+	for _, name := range names {
+		proc, ok := m.processes[name]
+		if !ok {
+			res = append(res, fmt.Sprintf("%s (not found)", name))
+		} else if state := proc.State(); state != InstanceStateRunning {
+			res = append(res, fmt.Sprintf("%s (%s)", name, state))
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// InstanceStatuses implements Manager.
+func (m *BasicManager) InstanceStatuses() map[string]InstanceStatus {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	res := make(map[string]InstanceStatus, len(m.processes))
+	for name, proc := range m.processes {
+		res[name] = proc.Status()
+	}
+	return res
+}
+
+// FilterInstances returns the managed instances for which pred returns true,
+// keyed by instance name. pred is called without holding any locks on the
+// BasicManager.
+func (m *BasicManager) FilterInstances(pred func(name string, inst ManagedInstance, status InstanceStatus) bool) map[string]ManagedInstance {
+	m.mut.Lock()
+	procs := make(map[string]*managedProcess, len(m.processes))
+	for name, proc := range m.processes {
+		procs[name] = proc
+	}
+	m.mut.Unlock()
+
+	res := make(map[string]ManagedInstance)
+	for name, proc := range procs {
+		if pred(name, proc.inst, proc.Status()) {
+			res[name] = proc.inst
+		}
+	}
+	return res
+}
+
+// ListConfigs lists the current active configs managed by BasicManager.
+//
+// If CacheListConfigs is set, ListConfigs is served from a snapshot taken
+// after the last change to the managed instances without locking the
+// BasicManager.
+func (m *BasicManager) ListConfigs() map[string]Config {
+	if res, ok := m.configsCache.load(); ok {
+		return res
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	res := make(map[string]Config, len(m.processes))
+	for name, process := range m.processes {
+		res[name] = process.cfg
+	}
+	m.configsCache.fill(res)
+	return res
+}
+
+// ApplyConfigs applies each of the given configs in order. Unlike calling
+// ApplyConfig in a loop, ApplyConfigs continues past individual failures.
+// The returned map holds the error for each config name that could not be
+// applied and is empty if all configs were applied.
+//
+// Configs are reordered so that each one is applied after the configs it
+// depends on, and only once its dependencies are running; see DependsOn and
+// DependencyTimeout. A config isn't applied if one of its dependencies
+// failed, isn't known, or if it's part of a dependency cycle.
+//
+// Configs without a name or whose name appears more than once in cs are not
+// applied.
+func (m *BasicManager) ApplyConfigs(cs []Config) map[string]error {
+	start := time.Now()
+	errs := make(map[string]error)
+
+	var applied int
+	defer func() {
+		reconcileDuration.Observe(time.Since(start).Seconds())
+		reconcileInstances.Set(float64(applied))
+	}()
+
+	counts := make(map[string]int, len(cs))
+	for _, c := range cs {
+		counts[c.Name]++
+	}
+
+	valid := make([]Config, 0, len(cs))
+	for _, c := range cs {
+		if err := checkConfigName(c, counts); err != nil {
+			errs[c.Name] = wrapError(c.Name, CodeValidation, err)
+			continue
+		}
+		valid = append(valid, c)
+	}
+
+	for _, c := range orderByDependencies(valid, errs) {
+		if err := m.waitDependencies(c, errs); err != nil {
+			errs[c.Name] = wrapError(c.Name, CodeLaunchFailed, err)
+			continue
+		}
+
+		if err := m.ApplyConfig(c); err != nil {
+			errs[c.Name] = err
+			continue
+		}
+		applied++
+	}
+	return errs
+}
+
+// ApplyConfigFromReader unmarshals one or more configs from r and applies
+// them with ApplyConfigs. See UnmarshalConfigs for the supported formats.
+// No configs are applied if r can't be parsed or if any of its configs fails
+// BasicManagerConfig.ValidateConfig.
+func (m *BasicManager) ApplyConfigFromReader(r io.Reader, format string) error {
+	cfgs, err := UnmarshalConfigs(r, format)
+	if err != nil {
+		return wrapError("", CodeValidation, err)
+	}
+
+	m.cfgMut.Lock()
+	validate := m.cfg.ValidateConfig
+	m.cfgMut.Unlock()
+
+	if validate == nil {
+		validate = func(c *Config) error {
+			global := DefaultGlobalConfig
+			return c.ApplyDefaults(&global)
+		}
+	}
+	for i := range cfgs {
+		if err := validate(&cfgs[i]); err != nil {
+			return wrapError(cfgs[i].Name, CodeValidation, fmt.Errorf("invalid config %s: %w", cfgs[i].Name, err))
+		}
+	}
+
+	errs := m.ApplyConfigs(cfgs)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, errs[name]))
+	}
+	return &ManagerError{
+		Code: ErrorCodeOf(errs[names[0]]),
+		Err:  fmt.Errorf("failed to apply %d of %d configs: %s", len(errs), len(cfgs), strings.Join(msgs, "; ")),
+	}
+}
+
+// checkConfigName returns an error if c has no name or if its name was
+// counted more than once in a batch of configs.
+func checkConfigName(c Config, counts map[string]int) error {
+	switch {
+	case c.Name == "":
+		return errors.New("missing instance name")
+	case counts[c.Name] > 1:
+		return fmt.Errorf("found multiple configs named %q", c.Name)
+	default:
+		return nil
+	}
+}
+
+// ApplyConfigResult describes how ApplyConfig applied a config.
+type ApplyConfigResult string
+
+// Possible results of ApplyConfig.
+const (
+	// ApplyConfigCreated is used when a new instance was launched for the
+	// config.
+	ApplyConfigCreated ApplyConfigResult = "created"
+
+	// ApplyConfigUpdated is used when the existing instance was updated
+	// dynamically with the config.
+	ApplyConfigUpdated ApplyConfigResult = "updated"
+
+	// ApplyConfigRestarted is used when the existing instance couldn't be
+	// updated dynamically and was restarted with the config.
+	ApplyConfigRestarted ApplyConfigResult = "restarted"
+)
+
+// ApplyConfig takes a Config and either starts a new managed instance or
+// updates an existing managed instance. The value for Name in c is used to
+// uniquely identify the Config and determine whether the Config has an
+// existing associated managed instance.
+//
+// When the existing instance can't be updated dynamically, ApplyConfig
+// restarts it and waits for it to stop, including its OnBeforeStop hook.
+// Other calls to ApplyConfig, DeleteConfig, DeleteConfigs and Stop block in
+// the meantime; the remaining methods of the BasicManager do not.
+//
+// If BlockOnSaturation is set, ApplyConfig may also block before launching a
+// new instance; see BasicManagerConfig.
+func (m *BasicManager) ApplyConfig(c Config) error {
+	return m.ApplyConfigContext(context.Background(), c)
+}
+
+// ApplyConfigContext is like ApplyConfig, but the values of ctx are passed
+// on to the context of the instance if one is launched, and logs about the
+// instance include the values listed in ContextLogFields. Only waiting for
+// saturation to clear is interrupted when ctx is canceled; the instance keeps
+// running after ctx is done.
+//
+// If a Tracer is set, the apply is recorded as a child span of the span of
+// ctx, if any.
+func (m *BasicManager) ApplyConfigContext(ctx context.Context, c Config) (err error) {
+	ctx, span := m.startSpan(ctx, "ApplyConfig", c.Name)
+	defer func() { endSpan(ctx, span, err) }()
+
+	if err := m.waitUnsaturated(ctx, c.Name); err != nil {
+		applyOutcomes.WithLabelValues(applyOutcomeFailed).Inc()
+		return wrapError(c.Name, CodeLimitReached, err)
+	}
+
+	defer m.lockName(c.Name)()
+	return wrapError(c.Name, CodeLaunchFailed, m.applyAndRecord(ctx, c))
+}
+
+// applyAndRecord applies c, records the outcome, keeps the config it replaced
+// for Rollback and invokes OnConfigApplied. The lock for the name of c must
+// be held when calling applyAndRecord.
+func (m *BasicManager) applyAndRecord(ctx context.Context, c Config) error {
+	m.mut.Lock()
+	var prev *Config
+	if proc, ok := m.processes[c.Name]; ok {
+		cfg := proc.cfg
+		prev = &cfg
+	}
+	m.mut.Unlock()
+
+	result, reason, err := m.applyConfig(ctx, c)
+	outcome := applyOutcome(prev, c, result, err)
+	applyOutcomes.WithLabelValues(outcome).Inc()
+	setSpanOutcome(ctx, outcome)
+	if err != nil {
+		return err
+	}
+	if outcome == applyOutcomeDynamicUpdate || outcome == applyOutcomeRestartUpdate {
+		m.setPrevious(*prev)
+	}
+
+	m.hooksMut.Lock()
+	defer m.hooksMut.Unlock()
+
+	mcfg := m.ManagerConfig()
+	if mcfg.OnConfigApplied != nil {
+		mcfg.OnConfigApplied(c, result)
+	}
+	if result == ApplyConfigRestarted && mcfg.OnConfigRestarted != nil {
+		mcfg.OnConfigRestarted(c, reason)
+	}
+	return nil
+}
+
+// Values of the outcome label of agent_prometheus_manager_apply_outcomes_total.
+const (
+	applyOutcomeCreated       = "created"
+	applyOutcomeDynamicUpdate = "dynamic_update"
+	applyOutcomeRestartUpdate = "restart_update"
+	applyOutcomeUnchanged     = "unchanged"
+	applyOutcomeFailed        = "failed"
+)
+
+// applyOutcome returns the outcome of applying c, given the result and error
+// of applyConfig. prev is the config of the instance c was applied to, if any.
+// A dynamic update with a config identical to prev is reported as unchanged.
+func applyOutcome(prev *Config, c Config, result ApplyConfigResult, err error) string {
+	switch {
+	case err != nil:
+		return applyOutcomeFailed
+	case result == ApplyConfigCreated:
+		return applyOutcomeCreated
+	case result == ApplyConfigRestarted:
+		return applyOutcomeRestartUpdate
+	case prev != nil && sameConfig(*prev, c):
+		return applyOutcomeUnchanged
+	default:
+		return applyOutcomeDynamicUpdate
+	}
+}
+
+// sameConfig returns true if a and b marshal to the same YAML, including
+// their secrets.
+func sameConfig(a, b Config) bool {
+	aBytes, err := MarshalConfig(&a, false)
+	if err != nil {
+		return false
+	}
+	bBytes, err := MarshalConfig(&b, false)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
+
+// applyConfig implements ApplyConfig. When the instance is restarted,
+// applyConfig also returns the ErrInvalidUpdate which caused the restart.
+// The lock for the name of c must be held when calling applyConfig.
+func (m *BasicManager) applyConfig(ctx context.Context, c Config) (ApplyConfigResult, ErrInvalidUpdate, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.state != ManagerStateRunning {
+		return "", ErrInvalidUpdate{}, ErrManagerStopped
+	}
+
+	var reason ErrInvalidUpdate
+
+	// If the config already exists, we need to update it.
+	proc, ok := m.processes[c.Name]
+	if ok {
+		// Release mut while updating so a slow Update doesn't block other
+		// instances. The lock for the name keeps proc from being replaced.
+		m.mut.Unlock()
+		err := proc.inst.Update(c)
+		m.mut.Lock()
+
+		// If the instance could not be dynamically updated, we need to force the
+		// update by restarting it. If it failed for another reason, something
+		// serious went wrong and we'll completely give up without stopping the
+		// existing job.
+		if errors.As(err, &reason) {
+			level.Info(proc.logger).Log("msg", "could not dynamically update instance, will manually restart", "instance", c.Name, "reason", err)
+
+			// NOTE: we don't return here; we fall through to spawn the new instance.
+			proc.setReplaced()
+
+			// Release mut while the old process stops so a slow OnBeforeStop
+			// hook doesn't block the rest of the BasicManager. The lock for the
+			// name keeps the process from being replaced in the meantime.
+			cfg := proc.cfg
+			m.mut.Unlock()
+			m.stopProcess(proc, cfg)
+			m.mut.Lock()
+		} else if err != nil {
+			return "", reason, wrapError(c.Name, CodeUpdateFailed, fmt.Errorf("failed to update instance %s: %w", c.Name, err))
+		} else {
+			level.Info(proc.logger).Log("msg", "dynamically updated instance", "instance", c.Name)
+
+			proc.cfg = c
+			m.configsCache.invalidate()
+			instanceLabels.Set(proc.metricLabel, c.Labels)
+			return ApplyConfigUpdated, reason, nil
+		}
+	}
+
+	if max := m.ManagerConfig().MaxInstances; !ok && max > 0 && len(m.processes) >= max {
+		instanceLimitRejections.Inc()
+		return "", reason, ErrInstanceLimitReached
+	}
+
+	// Spawn a new process for the new config.
+	var (
+		cause  RestartCause
+		result = ApplyConfigCreated
+	)
+	if ok {
+		cause = RestartCauseForcedByUpdate
+		result = ApplyConfigRestarted
+	}
+	err := m.spawnProcess(ctx, c, cause)
 	if err != nil {
+		if ok {
+			// The replaced process left emitting EventStopped and clearing
+			// its silence to its successor, which failed to start.
+			m.emit(EventStopped, c.Name, "")
+			m.clearSilenceLocked(c.Name)
+		}
+		return "", reason, err
+	}
+
+	currentActiveInstances.Inc()
+	return result, reason, nil
+}
+
+// spawnProcess launches an instance for c. The context of the instance holds
+// the values of applyCtx. An EventRestarted with cause is emitted if cause is
+// set, otherwise an EventStarted is emitted.
+func (m *BasicManager) spawnProcess(applyCtx context.Context, c Config, cause RestartCause) error {
+	if err := m.checkStorage(); err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	done := make(chan bool)
+	inst, err := m.launchInstance(m.launch, c)
+	if err != nil {
+		return err
+	}
+	m.startProcess(applyCtx, c, inst, cause)
+	return nil
+}
+
+// checkStorage creates the StorageDirectory if needed and checks that it's
+// writable, unless SkipStorageCheck is set.
+func (m *BasicManager) checkStorage() error {
+	m.cfgMut.Lock()
+	storageDir, storageMode, skipCheck := m.cfg.StorageDirectory, m.cfg.StorageDirectoryMode, m.cfg.SkipStorageCheck
+	m.cfgMut.Unlock()
+
+	if storageDir == "" || skipCheck {
+		return nil
+	}
+	if storageMode == 0 {
+		storageMode = DefaultBasicManagerConfig.StorageDirectoryMode
+	}
+	if err := os.MkdirAll(storageDir, storageMode); err != nil {
+		return fmt.Errorf("failed to create storage directory %s: %w", storageDir, err)
+	}
+	if err := checkWritable(storageDir); err != nil {
+		return fmt.Errorf("storage directory %s is not writable: %w", storageDir, err)
+	}
+	return nil
+}
+
+// startProcess starts running inst, which was launched for c. See
+// spawnProcess.
+func (m *BasicManager) startProcess(applyCtx context.Context, c Config, inst ManagedInstance, cause RestartCause) {
+	ctx, cancel := context.WithCancel(valuesContext{applyCtx})
+	done := make(chan bool)
+
+	m.cfgMut.Lock()
+	newStrategy, logLines, labelFunc := m.cfg.NewBackoffStrategy, m.cfg.InstanceLogLines, m.cfg.MetricLabelFunc
+	logFields := m.cfg.ContextLogFields
+	startupDelay, startupJitter := m.cfg.StartupDelay, m.cfg.StartupJitter
+	m.cfgMut.Unlock()
+
+	if cause != "" {
+		startupDelay, startupJitter = 0, 0
+	}
+	if startupJitter > 0 {
+		startupDelay += time.Duration(rand.Int63n(int64(startupJitter)))
+	}
+
+	metricLabel := c.Name
+	if labelFunc != nil {
+		metricLabel = labelFunc(c.Name)
+	}
+
+	var strategy BackoffStrategy = configBackoff{m: m}
+	if newStrategy != nil {
+		strategy = newStrategy()
+	}
+
+	logger, logs := m.instanceLogger(logLines)
+	if kv := contextLogValues(applyCtx, logFields); len(kv) > 0 {
+		logger = log.With(logger, kv...)
+	}
+
+	// Instances with a startup probe start out waiting for it, so they're
+	// never reported as running before the probe succeeded.
+	state := InstanceStateRunning
+	if c.StartupProbe != nil {
+		state = InstanceStateWaitingForDependency
+	}
+
+	proc := &managedProcess{
+		cancel:   cancel,
+		done:     done,
+		cfg:      c,
+		inst:     inst,
+		logger:   logger,
+		logs:     logs,
+		state:    state,
+		strategy: strategy,
+
+		metricLabel: metricLabel,
+	}
+	m.processes[c.Name] = proc
+	m.configsCache.invalidate()
+	instanceLabels.Set(metricLabel, c.Labels)
+
+	go m.storageSizeLoop(ctx, c.Name, proc)
+	go m.storageCapLoop(ctx, c.Name, proc)
+	go m.lastScrapeLoop(ctx, c.Name, proc)
+	go m.targetSampleLoop(ctx, c.Name, proc)
+	if tn, ok := inst.(TargetsNotifier); ok {
+		go m.watchTargets(ctx, c.Name, tn)
+	}
+	if sn, ok := inst.(StorageNotifier); ok {
+		sn.SetStorageHook(m.storageHook(c.Name))
+	}
+	if lc, ok := inst.(LogCapturer); ok && logs != nil {
+		lc.CaptureLogs(logs)
+	}
+
+	if cause != "" {
+		m.emit(EventRestarted, c.Name, cause)
+	} else {
+		m.emit(EventStarted, c.Name, "")
+	}
+
+	go func() {
+		// Label the goroutine so CPU profiles can attribute time to the
+		// instance. Goroutines started by the instance inherit the labels.
+		pprof.Do(ctx, pprof.Labels("instance", c.Name), func(ctx context.Context) {
+			if !waitStartupDelay(ctx, startupDelay) {
+				level.Info(proc.logger).Log("msg", "stopped instance before its startup delay elapsed", "instance", c.Name)
+				return
+			}
+			if !m.waitForStartupProbe(ctx, c.Name, proc, c.StartupProbe) {
+				level.Info(proc.logger).Log("msg", "stopped instance before its startup probe succeeded", "instance", c.Name)
+				return
+			}
+			m.runProcess(ctx, c.Name, proc)
+		})
+
+		// Now that the process has stopped, we can remove it from our managed
+		// list. This happens before closing done so the process is gone by the
+		// time Stop returns; callers must not hold mut while waiting on done.
+		//
+		// However, it's possible that a new Config may have been applied and
+		// overwrote the initial value in our map. We only want to delete the
+		// process from the map if it hasn't changed from what we initially
+		// set it to.
+		//
+		// We only use the instance for comparing (which will never change) because
+		// the instance may have dynamically been given a new config since this
+		// goroutine started.
+		m.mut.Lock()
+
+		// A replaced process lives on in its successor, which emits its own
+		// EventRestarted. The event is emitted before closing done so that
+		// it's sent by the time Stop returns, and with mut held so that it
+		// can't race with SubscribeWithSnapshot.
+		if !proc.wasReplaced() {
+			m.emit(EventStopped, c.Name, "")
+		}
+
+		if storedProc, exist := m.processes[c.Name]; exist && storedProc.inst == inst {
+			delete(m.processes, c.Name)
+			m.configsCache.invalidate()
+
+			// Silences outlive restarts, but not the instance itself.
+			if !proc.wasReplaced() {
+				m.clearSilenceLocked(c.Name)
+			}
+			instanceStorageBytes.DeleteLabelValues(metricLabel)
+			instanceLastScrapeTimestamp.DeleteLabelValues(metricLabel)
+			instanceLabels.Delete(metricLabel)
+		}
+		m.mut.Unlock()
+
+		currentActiveInstances.Dec()
+		close(done)
+	}()
+
+}
+
+// waitStartupDelay waits for d to elapse. Returns false if ctx was canceled
+// first.
+func waitStartupDelay(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// runProcess runs and instance and keeps it alive until it is explicitly stopped
+// by cancelling the context.
+func (m *BasicManager) runProcess(ctx context.Context, name string, proc *managedProcess) {
+	// Make sure the process no longer counts towards the quarantined instances
+	// once it stops.
+	defer proc.setState(InstanceStateRunning)
+
+	var errLimiter errorLogLimiter
+
+	for {
+		// An instance that has been running for long enough is considered
+		// healthy again, even if it's currently quarantined.
+		var (
+			started = time.Now()
+			window  = m.restartStreakResetAfter()
+			healthy *time.Timer
+		)
+		if window > 0 {
+			healthy = time.AfterFunc(window, proc.resetStreak)
+		}
+
+		runCtx, cancelRun := proc.runContext(ctx)
+		stopWatch := m.watchStartup(runCtx, cancelRun, name, proc)
+		err := m.runInstance(runCtx, name, proc)
+		cancelRun()
+		timedOut := stopWatch()
+		if healthy != nil {
+			healthy.Stop()
+		}
+		if ctx.Err() == nil && proc.takeRestartRequest() {
+			level.Info(proc.logger).Log("msg", "manually restarting instance", "instance", name)
+			spanCtx, span := m.startSpan(context.Background(), "Restart", name)
+			span.SetAttributes(causeKey.String(string(RestartCauseManualRestart)))
+			setSpanOutcome(spanCtx, spanOutcomeRestarted)
+			endSpan(spanCtx, span, nil)
+			m.emit(EventRestarted, name, RestartCauseManualRestart)
+			continue
+		}
+		if ctx.Err() == nil && timedOut {
+			err = errStartupTimeout
+		}
+		if err == nil || err == context.Canceled {
+			level.Info(proc.logger).Log("msg", "stopped instance", "instance", name)
+			return
+		}
+		instanceAbnormalExits.WithLabelValues(proc.metricLabel).Inc()
+		m.notifyExitWatchers(name, err)
+		m.abnormalExit(name, err)
+
+		if m.noRestart(proc) {
+			level.Error(proc.logger).Log("msg", "instance stopped abnormally, not restarting because no_restart is set", "err", err, "instance", name)
+			return
+		}
+
+		// Exits while restarts are suspended are expected and don't count
+		// towards the streak.
+		if until, suspended := m.RestartsSuspended(); suspended {
+			level.Warn(proc.logger).Log("msg", "instance stopped abnormally while restarts are suspended, restarting once the suspension ends", "err", err, "instance", name, "until", until)
+			if !m.restartResumed(ctx, name, proc, err) {
+				level.Info(proc.logger).Log("msg", "stopped instance", "instance", name)
+				return
+			}
+			continue
+		}
+
+		if resetsStreak(time.Since(started), window) {
+			proc.resetStreak()
+		}
+		streak := proc.failed(err)
+
+		// Quarantined restarts are already infrequent, so only the logs for
+		// regular restarts are limited.
+		backoff, next := m.restartBackoff(proc, streak)
+		shouldLog, repeated := errLimiter.Observe(err, m.repeatedErrorLogEvery())
+		if next == InstanceStateQuarantined {
+			level.Error(proc.logger).Log("msg", "instance stopped abnormally too many times, quarantining and restarting after quarantine interval", "err", err, "backoff", backoff, "instance", name, "streak", streak)
+		} else if shouldLog && repeated > 0 {
+			level.Error(proc.logger).Log("msg", "instance stopped abnormally, restarting after backoff period", "err", err, "backoff", backoff, "instance", name, "repeated", repeated)
+		} else if shouldLog {
+			level.Error(proc.logger).Log("msg", "instance stopped abnormally, restarting after backoff period", "err", err, "backoff", backoff, "instance", name)
+		}
+
+		// The restart span covers the backoff and is a root span, since the
+		// restart isn't caused by the apply which launched the instance.
+		spanCtx, span := m.startSpan(context.Background(), "Restart", name)
+		span.SetAttributes(backoffKey.String(backoff.String()))
+		span.RecordError(spanCtx, err)
+
+		if !proc.backoff(ctx, backoff, next) {
+			level.Info(proc.logger).Log("msg", "stopped instance", "instance", name)
+			setSpanOutcome(spanCtx, spanOutcomeStopped)
+			endSpan(spanCtx, span, nil)
+			return
+		}
+
+		cause := RestartCauseAbnormalExit
+		switch {
+		case proc.takeRestartRequest():
+			cause = RestartCauseManualRestart
+		case next == InstanceStateQuarantined:
+			cause = RestartCauseRecoveredFromQuarantine
+		case timedOut:
+			cause = RestartCauseStartupTimeout
+		}
+		span.SetAttributes(causeKey.String(string(cause)))
+		setSpanOutcome(spanCtx, spanOutcomeRestarted)
+		endSpan(spanCtx, span, nil)
+		m.emit(EventRestarted, name, cause)
+
+		// Quarantined instances stay quarantined while they're retried.
+		if next != InstanceStateQuarantined {
+			proc.setState(InstanceStateRunning)
+		}
+	}
+}
+
+// errStartupTimeout is the error an instance is considered to have exited
+// with when it's restarted for not becoming ready within StartupTimeout.
+var errStartupTimeout = errors.New("instance did not become ready within the startup timeout")
+
+// startupPollInterval is how often watchStartup checks whether an instance is
+// ready.
+const startupPollInterval = 100 * time.Millisecond
+
+// watchStartup puts proc into InstanceStateStarting while its instance isn't
+// ready if it implements ReadinessReporter and StartupTimeout is set. If the
+// instance isn't ready before StartupTimeout elapses, its run is stopped
+// through cancelRun. The returned function stops watching and returns whether
+// the run was stopped.
+func (m *BasicManager) watchStartup(runCtx context.Context, cancelRun context.CancelFunc, name string, proc *managedProcess) func() bool {
+	rr, ok := proc.inst.(ReadinessReporter)
+	timeout := m.ManagerConfig().StartupTimeout
+	if !ok || timeout <= 0 {
+		return func() bool { return false }
+	}
+
+	// Quarantined instances stay quarantined while they're retried.
+	proc.swapState(InstanceStateRunning, InstanceStateStarting)
+
+	var (
+		done     = make(chan struct{})
+		exited   = make(chan struct{})
+		timedOut bool
+	)
+	go func() {
+		defer close(exited)
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		ticker := time.NewTicker(startupPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if rr.Ready() {
+				proc.swapState(InstanceStateStarting, InstanceStateRunning)
+				return
+			}
+
+			select {
+			case <-done:
+				return
+			case <-runCtx.Done():
+				return
+			case <-timer.C:
+				instanceStartupTimeouts.WithLabelValues(proc.metricLabel).Inc()
+				level.Error(proc.logger).Log("msg", "instance did not become ready within the startup timeout, restarting it", "instance", name, "timeout", timeout)
+				timedOut = true
+				cancelRun()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		<-exited
+		return timedOut
+	}
+}
+
+// noRestart returns whether the current config of proc disables restarts.
+func (m *BasicManager) noRestart(proc *managedProcess) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return proc.cfg.NoRestart
+}
+
+// repeatedErrorLogEvery returns how often a repeated run error of an
+// instance is logged. See BasicManagerConfig.RepeatedErrorLogEvery.
+func (m *BasicManager) repeatedErrorLogEvery() int {
+	m.cfgMut.Lock()
+	defer m.cfgMut.Unlock()
+	return m.cfg.RepeatedErrorLogEvery
+}
+
+// errorLogLimiter tracks consecutive repeats of the same error to limit how
+// often it gets logged.
+type errorLogLimiter struct {
+	last    string
+	repeats int
+}
+
+// Observe records an occurrence of err and returns whether it should be
+// logged, given that repeats are only logged every n occurrences. repeated is
+// the number of times err has repeated since it was first seen.
+func (l *errorLogLimiter) Observe(err error, n int) (shouldLog bool, repeated int) {
+	if msg := err.Error(); msg != l.last {
+		l.last, l.repeats = msg, 0
+	} else {
+		l.repeats++
+	}
+	return l.repeats == 0 || n <= 1 || l.repeats%n == 0, l.repeats
+}
+
+func (m *BasicManager) restartStreakResetAfter() time.Duration {
+	m.cfgMut.Lock()
+	defer m.cfgMut.Unlock()
+	return m.cfg.RestartStreakResetAfter
+}
+
+// saturationPollInterval is how often waitUnsaturated checks the number of
+// restarts in flight.
+const saturationPollInterval = 50 * time.Millisecond
+
+// waitUnsaturated blocks until fewer than MaxRestartsInFlight instances are
+// backing off before a restart if BlockOnSaturation is set and applying the
+// config with the given name would launch a new instance. Returns
+// ErrSaturated if SaturationTimeout elapses first, or the error of ctx if it's
+// done first.
+func (m *BasicManager) waitUnsaturated(ctx context.Context, name string) error {
+	m.cfgMut.Lock()
+	var (
+		block   = m.cfg.BlockOnSaturation
+		limit   = m.cfg.MaxRestartsInFlight
+		timeout = m.cfg.SaturationTimeout
+	)
+	m.cfgMut.Unlock()
+
+	if !block || limit <= 0 {
+		return nil
+	}
+	if exists, inFlight := m.restartsInFlight(name); exists || inFlight < limit {
+		return nil
+	}
+
+	m.addPending(name, 1)
+	defer m.addPending(name, -1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(saturationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		exists, inFlight := m.restartsInFlight(name)
+		if exists || inFlight < limit {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return ErrSaturated
+		case <-ticker.C:
+		}
+	}
+}
+
+// addPending adds delta to the number of applies of the named config waiting
+// for saturation to clear.
+func (m *BasicManager) addPending(name string, delta int) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.pending[name] += delta
+	if m.pending[name] <= 0 {
+		delete(m.pending, name)
+	}
+	pendingApplies.Add(float64(delta))
+}
+
+// PendingApplies returns the sorted names of the configs whose apply is
+// waiting for saturation to clear (see BlockOnSaturation). A name is listed
+// once even if several applies of it are waiting.
+func (m *BasicManager) PendingApplies() []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	names := make([]string, 0, len(m.pending))
+	for name := range m.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// restartsInFlight returns whether the named instance exists and how many
+// instances are backing off before a restart.
+func (m *BasicManager) restartsInFlight(name string) (exists bool, inFlight int) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	_, exists = m.processes[name]
+	for _, proc := range m.processes {
+		if proc.State() == InstanceStateBackingOff {
+			inFlight++
+		}
+	}
+	return exists, inFlight
+}
+
+// resetsStreak returns true if an instance which ran for ran before exiting
+// abnormally should have its restart streak reset. Running for exactly window
+// is long enough.
+func resetsStreak(ran, window time.Duration) bool {
+	return window > 0 && ran >= window
+}
+
+// runInstance runs the instance of proc, converting a panic into an error so
+// the instance gets restarted like any other abnormal exit.
+func (m *BasicManager) runInstance(ctx context.Context, name string, proc *managedProcess) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			instancePanics.WithLabelValues(proc.metricLabel).Inc()
+			level.Error(proc.logger).Log("msg", "instance panicked", "instance", name, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("instance panicked: %v", r)
+		}
+	}()
+	return proc.inst.Run(ctx)
+}
+
+// restartBackoff returns how long to wait before restarting an instance that
+// has exited abnormally streak times in a row, along with the state the
+// instance should be in while waiting.
+func (m *BasicManager) restartBackoff(proc *managedProcess, streak int) (time.Duration, InstanceState) {
+	m.cfgMut.Lock()
+	threshold, interval := m.cfg.QuarantineThreshold, m.cfg.QuarantineInterval
+	m.cfgMut.Unlock()
+
+	if threshold > 0 && streak >= threshold {
+		proc.setBackoff(interval)
+		return interval, InstanceStateQuarantined
+	}
+
+	backoff := proc.nextBackoff(streak)
+	proc.setBackoff(backoff)
+	return backoff, InstanceStateBackingOff
+}
+
+// storageSizeLoop periodically updates the storage size metric for an
+// instance until ctx is canceled.
+func (m *BasicManager) storageSizeLoop(ctx context.Context, name string, proc *managedProcess) {
+	m.cfgMut.Lock()
+	interval := m.cfg.StorageSizeInterval
+	m.cfgMut.Unlock()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			size, err := proc.inst.StorageSize()
+			if err != nil {
+				level.Warn(m.logger).Log("msg", "failed to get instance storage size", "instance", name, "err", err)
+				continue
+			}
+			instanceStorageBytes.WithLabelValues(proc.metricLabel).Set(float64(size))
+		}
+	}
+}
+
+// storageCapLoop periodically checks whether the storage of an instance is
+// larger than the MaxStorageBytes of its config until ctx is canceled.
+func (m *BasicManager) storageCapLoop(ctx context.Context, name string, proc *managedProcess) {
+	m.cfgMut.Lock()
+	interval := m.cfg.StorageCapInterval
+	m.cfgMut.Unlock()
 
-	proc := &managedProcess{
-		cancel: cancel,
-		done:   done,
-		cfg:    c,
-		inst:   inst,
+	if interval <= 0 {
+		return
 	}
-	m.processes[c.Name] = proc
 
-	go func() {
-		m.runProcess(ctx, c.Name, inst)
-		close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		// Now that the process has stopped, we can remove it from our managed
-		// list.
-		//
-		// However, it's possible that a new Config may have been applied and
-		// overwrote the initial value in our map. We only want to delete the
-		// process from the map if it hasn't changed from what we initially
-		// set it to.
-		//
-		// We only use the instance for comparing (which will never change) because
-		// the instance may have dynamically been given a new config since this
-		// goroutine started.
-		m.mut.Lock()
-		if storedProc, exist := m.processes[c.Name]; exist && storedProc.inst == inst {
-			delete(m.processes, c.Name)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.checkStorageCap(ctx, name, proc)
 		}
-		m.mut.Unlock()
+	}
+}
 
-		currentActiveInstances.Dec()
-	}()
+// checkStorageCap truncates the storage of an instance, if possible, when it's
+// larger than the MaxStorageBytes of its config.
+func (m *BasicManager) checkStorageCap(ctx context.Context, name string, proc *managedProcess) {
+	// The config of the process changes when it's updated dynamically.
+	m.mut.Lock()
+	maxBytes := proc.cfg.MaxStorageBytes
+	m.mut.Unlock()
 
-	return nil
+	if maxBytes <= 0 {
+		return
+	}
+
+	size, err := proc.inst.StorageSize()
+	if err != nil {
+		level.Warn(m.logger).Log("msg", "failed to get instance storage size", "instance", name, "err", err)
+		return
+	} else if size <= maxBytes {
+		return
+	}
+	instanceStorageCapExceeded.WithLabelValues(proc.metricLabel).Inc()
+
+	t, ok := proc.inst.(Truncater)
+	if !ok {
+		level.Warn(proc.logger).Log("msg", "instance storage is over max_storage_bytes and the instance can't be truncated", "instance", name, "size", size, "max_storage_bytes", maxBytes)
+		return
+	}
+
+	level.Warn(proc.logger).Log("msg", "instance storage is over max_storage_bytes, truncating", "instance", name, "size", size, "max_storage_bytes", maxBytes)
+	if err := t.Truncate(ctx); err != nil {
+		level.Error(proc.logger).Log("msg", "failed to truncate instance storage", "instance", name, "err", err)
+	}
 }
 
-// runProcess runs and instance and keeps it alive until it is explicitly stopped
-// by cancelling the context.
-func (m *BasicManager) runProcess(ctx context.Context, name string, inst ManagedInstance) {
+// watchTargets notifies target change subscribers whenever the active targets of
+// the instance with the given name change. watchTargets runs until ctx is
+// canceled.
+func (m *BasicManager) watchTargets(ctx context.Context, name string, tn TargetsNotifier) {
+	changed := tn.TargetsChanged()
+
 	for {
-		err := inst.Run(ctx)
-		if err != nil && err != context.Canceled {
-			backoff := m.instanceRestartBackoff()
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			m.targetSubsMut.Lock()
+			for sub := range m.targetSubs {
+				select {
+				case sub <- name:
+				default:
+					level.Debug(m.logger).Log("msg", "dropping target change notification for slow subscriber", "instance", name)
+				}
+			}
+			m.targetSubsMut.Unlock()
+		}
+	}
+}
 
-			instanceAbnormalExits.WithLabelValues(name).Inc()
-			level.Error(m.logger).Log("msg", "instance stopped abnormally, restarting after backoff period", "err", err, "backoff", backoff, "instance", name)
-			time.Sleep(backoff)
-		} else {
-			level.Info(m.logger).Log("msg", "stopped instance", "instance", name)
-			break
+// SubscribeTargetChanges returns a channel that receives the name of an
+// instance whenever its active targets may have changed. Only instances that
+// implement TargetsNotifier are observed; call TargetsActive on the instance
+// to retrieve its new targets.
+//
+// Notifications are dropped if the subscriber isn't reading from the channel
+// fast enough. The returned function unsubscribes and closes the channel.
+func (m *BasicManager) SubscribeTargetChanges() (<-chan string, func()) {
+	ch := make(chan string, 16)
+
+	m.targetSubsMut.Lock()
+	m.targetSubs[ch] = struct{}{}
+	m.targetSubsMut.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			m.targetSubsMut.Lock()
+			defer m.targetSubsMut.Unlock()
+			delete(m.targetSubs, ch)
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+// StorageDir returns the directory the instance for c keeps its storage in,
+// as computed by StorageDirFunc.
+func (m *BasicManager) StorageDir(c Config) string {
+	m.cfgMut.Lock()
+	root, dirFunc := m.cfg.StorageDirectory, m.cfg.StorageDirFunc
+	m.cfgMut.Unlock()
+
+	if dirFunc != nil {
+		return dirFunc(c)
+	}
+	return DefaultStorageDir(root, c)
+}
+
+// PurgeOrphanedStorage deletes every directory in the configured
+// StorageDirectory that isn't the StorageDir of one of knownConfigs or of a
+// currently managed instance. Only the names of knownConfigs are passed to
+// StorageDirFunc. The paths of the purged directories are returned. Purging
+// continues past directories that fail to be deleted; the first such error
+// is returned.
+func (m *BasicManager) PurgeOrphanedStorage(knownConfigs []string) ([]string, error) {
+	m.cfgMut.Lock()
+	root := m.cfg.StorageDirectory
+	m.cfgMut.Unlock()
+
+	if root == "" {
+		return nil, fmt.Errorf("no storage directory configured")
+	}
+
+	keep := make(map[string]struct{}, len(knownConfigs))
+	for _, name := range knownConfigs {
+		keep[filepath.Clean(m.StorageDir(Config{Name: name}))] = struct{}{}
+	}
+	m.mut.Lock()
+	cfgs := make([]Config, 0, len(m.processes))
+	for _, proc := range m.processes {
+		cfgs = append(cfgs, proc.cfg)
+	}
+	m.mut.Unlock()
+	for _, c := range cfgs {
+		keep[filepath.Clean(m.StorageDir(c))] = struct{}{}
+	}
+
+	infos, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %w", err)
+	}
+
+	var (
+		purged   []string
+		firstErr error
+	)
+	for _, info := range infos {
+		dir := filepath.Join(root, info.Name())
+		if _, ok := keep[dir]; ok || !info.IsDir() {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to purge orphaned storage", "dir", dir, "err", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to purge %s: %w", dir, err)
+			}
+			continue
 		}
+
+		level.Info(m.logger).Log("msg", "purged orphaned storage", "dir", dir)
+		purged = append(purged, dir)
+	}
+	return purged, firstErr
+}
+
+// checkWritable ensures that files can be written to dir.
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".write-probe-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write([]byte{0}); err != nil {
+		_ = f.Close()
+		return err
 	}
+	return f.Close()
 }
 
-func (m *BasicManager) instanceRestartBackoff() time.Duration {
+// lastScrapeLoop periodically updates the last scrape timestamp metric for an
+// instance until ctx is canceled.
+func (m *BasicManager) lastScrapeLoop(ctx context.Context, name string, proc *managedProcess) {
 	m.cfgMut.Lock()
-	defer m.cfgMut.Unlock()
-	return m.cfg.InstanceRestartBackoff
+	interval := m.cfg.LastScrapeInterval
+	m.cfgMut.Unlock()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			setLastScrapeTimestamp(proc.metricLabel, proc.inst.LastScrapeTime())
+		}
+	}
+}
+
+func setLastScrapeTimestamp(metricLabel string, ts time.Time) {
+	if ts.IsZero() {
+		return
+	}
+	instanceLastScrapeTimestamp.WithLabelValues(metricLabel).Set(float64(ts.UnixNano()) / 1e9)
+}
+
+// targetSampleLoop periodically samples the number of active targets of an
+// instance, invoking OnTargetDrop when it drops too much, until ctx is
+// canceled.
+func (m *BasicManager) targetSampleLoop(ctx context.Context, name string, proc *managedProcess) {
+	m.cfgMut.Lock()
+	interval := m.cfg.TargetSampleInterval
+	m.cfgMut.Unlock()
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := -1
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.cfgMut.Lock()
+		hook, threshold := m.cfg.OnTargetDrop, m.cfg.TargetDropThreshold
+		m.cfgMut.Unlock()
+
+		if hook == nil {
+			last = -1
+			continue
+		}
+
+		count := countTargets(proc.inst)
+		if isTargetDrop(last, count, threshold) {
+			level.Warn(proc.logger).Log("msg", "number of active targets dropped", "instance", name, "from", last, "to", count)
+			hook(name, last, count)
+		}
+		last = count
+	}
+}
+
+// isTargetDrop returns true if going from from to to active targets is a drop
+// of more than threshold. A negative from means there's no previous sample.
+func isTargetDrop(from, to int, threshold float64) bool {
+	if from <= 0 || to >= from {
+		return false
+	}
+	return float64(from-to)/float64(from) > threshold
+}
+
+// countTargets returns the number of active targets of inst across all of its
+// jobs.
+func countTargets(inst ManagedInstance) int {
+	var n int
+	for _, targets := range inst.TargetsActive() {
+		n += len(targets)
+	}
+	return n
+}
+
+// InstanceStats returns the runtime statistics of every managed instance,
+// keyed by instance name. Instances which don't implement StatsReporter are
+// included with zero-valued statistics. Statistics are gathered without
+// holding any locks on the BasicManager.
+func (m *BasicManager) InstanceStats() map[string]InstanceStats {
+	procs := m.listProcesses()
+
+	res := make(map[string]InstanceStats, len(procs))
+	for name, proc := range procs {
+		var stats InstanceStats
+		if sr, ok := proc.inst.(StatsReporter); ok {
+			stats = sr.Stats()
+		}
+		res[name] = stats
+	}
+	return res
+}
+
+// LastScrapeTimes returns the time of the most recent scrape of every managed
+// instance, keyed by instance name. Instances which haven't scraped anything
+// yet report the zero time.
+func (m *BasicManager) LastScrapeTimes() map[string]time.Time {
+	procs := m.listProcesses()
+
+	res := make(map[string]time.Time, len(procs))
+	for name, proc := range procs {
+		ts := proc.inst.LastScrapeTime()
+		setLastScrapeTimestamp(proc.metricLabel, ts)
+		res[name] = ts
+	}
+	return res
+}
+
+// StorageSizes returns the size in bytes of the storage directory of every
+// managed instance, keyed by instance name. Instances whose size could not
+// be determined are omitted.
+func (m *BasicManager) StorageSizes() map[string]int64 {
+	procs := m.listProcesses()
+
+	res := make(map[string]int64, len(procs))
+	for name, proc := range procs {
+		size, err := proc.inst.StorageSize()
+		if err != nil {
+			level.Warn(m.logger).Log("msg", "failed to get instance storage size", "instance", name, "err", err)
+			continue
+		}
+		instanceStorageBytes.WithLabelValues(proc.metricLabel).Set(float64(size))
+		res[name] = size
+	}
+	return res
+}
+
+// OverrideConfig temporarily applies c to the running instance with the given
+// name without persisting it. Unlike ApplyConfig, the stored config of the
+// instance is left unchanged: ListConfigs keeps returning it and the override
+// is undone the next time a config for the instance is applied through
+// ApplyConfig.
+//
+// Overrides are only applied dynamically; an error is returned if c would
+// require restarting the instance. Returns ErrConfigNotFound if there is no
+// such managed instance.
+func (m *BasicManager) OverrideConfig(name string, c Config) error {
+	if c.Name != name {
+		return wrapError(name, CodeValidation, fmt.Errorf("override config name %q does not match instance %q", c.Name, name))
+	}
+
+	defer m.lockName(name)()
+
+	m.mut.Lock()
+	proc, ok := m.processes[name]
+	m.mut.Unlock()
+	if !ok {
+		return wrapError(name, CodeNotFound, ErrConfigNotFound)
+	}
+	if err := proc.inst.Update(c); err != nil {
+		return wrapError(name, CodeUpdateFailed, fmt.Errorf("failed to override config of instance %s: %w", name, err))
+	}
+
+	level.Info(m.logger).Log("msg", "temporarily overrode instance config", "instance", name)
+	return nil
+}
+
+// RestartInstance restarts the instance with the given name without waiting
+// for any in-progress backoff. Returns ErrConfigNotFound if there is no such
+// managed instance.
+func (m *BasicManager) RestartInstance(name string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	proc, ok := m.processes[name]
+	if !ok {
+		return wrapError(name, CodeNotFound, ErrConfigNotFound)
+	}
+	proc.requestRestart()
+	return nil
 }
 
 // DeleteConfig removes a managed instance by its config name. Returns an error
 // if there is no such managed instance with the given name.
-func (m *BasicManager) DeleteConfig(name string) error {
+func (m *BasicManager) DeleteConfig(name string) (err error) {
+	ctx, span := m.startSpan(context.Background(), "DeleteConfig", name)
+	defer func() { endSpan(ctx, span, err) }()
+
+	defer m.lockName(name)()
+
 	m.mut.Lock()
+	if m.state != ManagerStateRunning {
+		m.mut.Unlock()
+		return wrapError(name, CodeStopped, ErrManagerStopped)
+	}
 	proc, ok := m.processes[name]
 	if !ok {
 		m.mut.Unlock()
-		return errors.New("config does not exist")
+		return wrapError(name, CodeNotFound, ErrConfigNotFound)
 	}
+	cfg := proc.cfg
 	m.mut.Unlock()
 
 	// spawnProcess is responsible for removing the process from the map after it
 	// stops so we don't need to delete anything from m.processes here.
-	proc.Stop()
+	m.stopProcess(proc, cfg)
+	m.tearDownInstance(name, proc)
+	m.configDeleted(name)
+	setSpanOutcome(ctx, spanOutcomeDeleted)
 	return nil
 }
 
+// tearDownInstance removes what's left of the named instance once its process
+// proc was stopped for good: its metric series and its silence.
+func (m *BasicManager) tearDownInstance(name string, proc *managedProcess) {
+	m.deleteInstanceMetrics(proc.metricLabel)
+	m.clearSilence(name)
+}
+
+// deleteInstanceMetrics removes the series of all metrics labeled with the
+// instance_name label of a deleted instance, so they don't keep reporting
+// their last values. Series shared with an instance that's still running,
+// through MetricLabelFunc, are kept.
+func (m *BasicManager) deleteInstanceMetrics(label string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for _, proc := range m.processes {
+		if proc.metricLabel == label {
+			return
+		}
+	}
+
+	instanceAbnormalExits.DeleteLabelValues(label)
+	instancePanics.DeleteLabelValues(label)
+	instanceStartupTimeouts.DeleteLabelValues(label)
+	instanceStorageBytes.DeleteLabelValues(label)
+	instanceStorageCapExceeded.DeleteLabelValues(label)
+	instanceLastScrapeTimestamp.DeleteLabelValues(label)
+	instanceLabels.Delete(label)
+}
+
+// configDeleted forgets the previous config of the named instance and
+// invokes the OnConfigDeleted hook, if any. The lock for name or applyMut
+// must be held when calling configDeleted.
+func (m *BasicManager) configDeleted(name string) {
+	m.forgetPrevious(name)
+
+	m.hooksMut.Lock()
+	defer m.hooksMut.Unlock()
+	if onDeleted := m.ManagerConfig().OnConfigDeleted; onDeleted != nil {
+		onDeleted(name)
+	}
+}
+
+// DeleteConfigs removes the managed instances for each of the given config
+// names. Unlike calling DeleteConfig in a loop, DeleteConfigs continues past
+// individual failures. The returned map holds the error for each name that
+// could not be deleted and is empty if all deletes succeeded.
+func (m *BasicManager) DeleteConfigs(names []string) map[string]error {
+	m.applyMut.Lock()
+	defer m.applyMut.Unlock()
+	return m.deleteConfigs(names)
+}
+
+// deleteConfigs implements DeleteConfigs. applyMut, or the lock for each of
+// names, must be held when calling deleteConfigs.
+func (m *BasicManager) deleteConfigs(names []string) map[string]error {
+	var (
+		wg    sync.WaitGroup
+		errs  = make(map[string]error)
+		procs = make(map[*managedProcess]Config, len(names))
+	)
+
+	m.mut.Lock()
+	for _, name := range names {
+		if m.state != ManagerStateRunning {
+			errs[name] = wrapError(name, CodeStopped, ErrManagerStopped)
+			continue
+		}
+		proc, ok := m.processes[name]
+		if !ok {
+			errs[name] = wrapError(name, CodeNotFound, ErrConfigNotFound)
+			continue
+		}
+		procs[proc] = proc.cfg
+	}
+	m.mut.Unlock()
+
+	// Stop the processes in parallel; as with DeleteConfig, the processes will
+	// remove themselves from m.processes.
+	wg.Add(len(procs))
+	for proc, cfg := range procs {
+		go func(proc *managedProcess, cfg Config) {
+			defer wg.Done()
+			m.stopProcess(proc, cfg)
+		}(proc, cfg)
+	}
+	wg.Wait()
+
+	for proc, cfg := range procs {
+		m.tearDownInstance(cfg.Name, proc)
+	}
+
+	notified := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, failed := errs[name]; failed || notified[name] {
+			continue
+		}
+		notified[name] = true
+		m.configDeleted(name)
+	}
+	return errs
+}
+
+// stopProcess stops proc after running the OnBeforeStop hook. cfg must be the
+// current config of proc.
+func (m *BasicManager) stopProcess(proc *managedProcess, cfg Config) {
+	m.cfgMut.Lock()
+	var (
+		hook    = m.cfg.OnBeforeStop
+		timeout = m.cfg.OnBeforeStopTimeout
+	)
+	m.cfgMut.Unlock()
+
+	if hook != nil {
+		if timeout == 0 {
+			timeout = DefaultBasicManagerConfig.OnBeforeStopTimeout
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			hook(cfg.Name, cfg)
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+		case <-timer.C:
+			level.Warn(proc.logger).Log("msg", "pre-stop hook did not finish in time, stopping instance anyway", "instance", cfg.Name, "timeout", timeout)
+		}
+	}
+
+	proc.Stop()
+}
+
 // Stop stops the BasicManager and stops all active processes for configs.
+// Channels returned by Subscribe are closed once every process has stopped.
+//
+// The BasicManager can't be used after it is stopped: ApplyConfig and
+// DeleteConfig will return ErrManagerStopped.
 func (m *BasicManager) Stop() {
-	var wg sync.WaitGroup
+	type stopRequest struct {
+		proc *managedProcess
+		cfg  Config
+	}
+
+	// Wait for in-flight calls to ApplyConfig so the processes they spawn are
+	// stopped too. Later calls fail with ErrManagerStopped.
+	m.applyMut.Lock()
 
 	// We don't need to change m.processes here; processes remove themselves
 	// from the map (in spawnProcess).
 	m.mut.Lock()
-	wg.Add(len(m.processes))
+	m.state = ManagerStateStopping
+	reqs := make(chan stopRequest, len(m.processes))
 	for _, proc := range m.processes {
-		go func(proc *managedProcess) {
-			proc.Stop()
-			wg.Done()
-		}(proc)
+		reqs <- stopRequest{proc: proc, cfg: proc.cfg}
 	}
+	close(reqs)
 	m.mut.Unlock()
+	m.applyMut.Unlock()
+
+	m.discardPrepared()
 
+	m.cfgMut.Lock()
+	workers := m.cfg.StopConcurrency
+	m.cfgMut.Unlock()
+
+	if workers <= 0 || workers > len(reqs) {
+		workers = len(reqs)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for req := range reqs {
+				m.stopProcess(req.proc, req.cfg)
+			}
+		}()
+	}
 	wg.Wait()
+	m.closeSubscribers()
+
+	m.mut.Lock()
+	m.state = ManagerStateStopped
+	m.mut.Unlock()
 }
 
 // MockManager exposes methods of the Manager interface as struct fields.
 // Useful for tests.
+//
+// If Calls is set, calls to each method are counted, including calls that
+// panic because the method isn't implemented, so tests can assert how the
+// MockManager was used.
 type MockManager struct {
-	ListInstancesFunc func() map[string]ManagedInstance
-	ListConfigsFunc   func() map[string]Config
-	ApplyConfigFunc   func(Config) error
-	DeleteConfigFunc  func(name string) error
-	StopFunc          func()
+	ListInstancesFunc    func() map[string]ManagedInstance
+	ListConfigsFunc      func() map[string]Config
+	InstanceStatusesFunc func() map[string]InstanceStatus
+	ApplyConfigFunc      func(Config) error
+	DeleteConfigFunc     func(name string) error
+	StopFunc             func()
+
+	Calls *MockManagerCalls
+}
+
+// MockManagerCalls counts the calls made to the methods of a MockManager.
+// Copies of a MockManager share its MockManagerCalls.
+type MockManagerCalls struct {
+	listInstances    atomic.Int64
+	listConfigs      atomic.Int64
+	instanceStatuses atomic.Int64
+	applyConfig      atomic.Int64
+	deleteConfig     atomic.Int64
+	stop             atomic.Int64
 }
 
 // ListInstances implements Manager.
 func (m MockManager) ListInstances() map[string]ManagedInstance {
+	if m.Calls != nil {
+		m.Calls.listInstances.Inc()
+	}
 	if m.ListInstancesFunc != nil {
 		return m.ListInstancesFunc()
 	}
 	panic("ListInstancesFunc not implemented")
 }
 
+// ListInstancesCalls returns the number of calls made to ListInstances. It's
+// always 0 if Calls isn't set.
+func (m MockManager) ListInstancesCalls() int {
+	if m.Calls == nil {
+		return 0
+	}
+	return int(m.Calls.listInstances.Load())
+}
+
 // ListConfigs implements Manager.
 func (m MockManager) ListConfigs() map[string]Config {
+	if m.Calls != nil {
+		m.Calls.listConfigs.Inc()
+	}
 	if m.ListConfigsFunc != nil {
 		return m.ListConfigsFunc()
 	}
 	panic("ListConfigsFunc not implemented")
 }
 
+// ListConfigsCalls returns the number of calls made to ListConfigs. It's
+// always 0 if Calls isn't set.
+func (m MockManager) ListConfigsCalls() int {
+	if m.Calls == nil {
+		return 0
+	}
+	return int(m.Calls.listConfigs.Load())
+}
+
+// InstanceStatuses implements Manager.
+func (m MockManager) InstanceStatuses() map[string]InstanceStatus {
+	if m.Calls != nil {
+		m.Calls.instanceStatuses.Inc()
+	}
+	if m.InstanceStatusesFunc != nil {
+		return m.InstanceStatusesFunc()
+	}
+	panic("InstanceStatusesFunc not implemented")
+}
+
+// InstanceStatusesCalls returns the number of calls made to InstanceStatuses.
+// It's always 0 if Calls isn't set.
+func (m MockManager) InstanceStatusesCalls() int {
+	if m.Calls == nil {
+		return 0
+	}
+	return int(m.Calls.instanceStatuses.Load())
+}
+
 // ApplyConfig implements Manager.
 func (m MockManager) ApplyConfig(c Config) error {
+	if m.Calls != nil {
+		m.Calls.applyConfig.Inc()
+	}
 	if m.ApplyConfigFunc != nil {
 		return m.ApplyConfigFunc(c)
 	}
 	panic("ApplyConfigFunc not implemented")
 }
 
+// ApplyConfigCalls returns the number of calls made to ApplyConfig. It's
+// always 0 if Calls isn't set.
+func (m MockManager) ApplyConfigCalls() int {
+	if m.Calls == nil {
+		return 0
+	}
+	return int(m.Calls.applyConfig.Load())
+}
+
 // DeleteConfig implements Manager.
 func (m MockManager) DeleteConfig(name string) error {
+	if m.Calls != nil {
+		m.Calls.deleteConfig.Inc()
+	}
 	if m.DeleteConfigFunc != nil {
 		return m.DeleteConfigFunc(name)
 	}
 	panic("DeleteConfigFunc not implemented")
 }
 
+// DeleteConfigCalls returns the number of calls made to DeleteConfig. It's
+// always 0 if Calls isn't set.
+func (m MockManager) DeleteConfigCalls() int {
+	if m.Calls == nil {
+		return 0
+	}
+	return int(m.Calls.deleteConfig.Load())
+}
+
 // Stop implements Manager.
 func (m MockManager) Stop() {
+	if m.Calls != nil {
+		m.Calls.stop.Inc()
+	}
 	if m.StopFunc != nil {
 		m.StopFunc()
 		return
 	}
 	panic("StopFunc not implemented")
 }
+
+// StopCalls returns the number of calls made to Stop. It's always 0 if Calls
+// isn't set.
+func (m MockManager) StopCalls() int {
+	if m.Calls == nil {
+		return 0
+	}
+	return int(m.Calls.stop.Load())
+}
@@ -2,13 +2,24 @@ package instance
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 )
 
 func TestBasicManager_ApplyConfig(t *testing.T) {
@@ -96,11 +107,1895 @@ func TestBasicManager_ApplyConfig(t *testing.T) {
 	})
 }
 
+func TestBasicManager_ApplyConfigs(t *testing.T) {
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	})
+	defer cm.Stop()
+
+	errs := cm.ApplyConfigs([]Config{{Name: "a"}, {Name: ""}, {Name: "b"}, {Name: "b"}})
+	require.Len(t, errs, 2)
+	require.EqualError(t, errs[""], "missing instance name")
+	require.EqualError(t, errs["b"], `found multiple configs named "b"`)
+
+	cfgs := cm.ListConfigs()
+	require.Len(t, cfgs, 1)
+	require.Contains(t, cfgs, "a")
+
+	var m dto.Metric
+	require.NoError(t, reconcileInstances.Write(&m))
+	require.Equal(t, float64(1), m.GetGauge().GetValue())
+}
+
+func TestBasicManager_Instance(t *testing.T) {
+	var launched ManagedInstance
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), func(c Config) (ManagedInstance, error) {
+		launched = &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}
+		return launched, nil
+	})
+	defer cm.Stop()
+
+	_, ok := cm.Instance("test")
+	require.False(t, ok)
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	inst, ok := cm.Instance("test")
+	require.True(t, ok)
+	require.Equal(t, launched, inst)
+}
+
+func TestBasicManager_MaxInstances(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.MaxInstances = 2
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	var limit dto.Metric
+	require.NoError(t, instanceLimit.Write(&limit))
+	require.Equal(t, float64(2), limit.GetGauge().GetValue())
+
+	var before dto.Metric
+	require.NoError(t, instanceLimitRejections.Write(&before))
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "b"}))
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "c"}), ErrInstanceLimitReached)
+	require.Len(t, cm.ListInstances(), 2)
+
+	// Existing instances can still be updated.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a", HostFilter: true}))
+
+	var after dto.Metric
+	require.NoError(t, instanceLimitRejections.Write(&after))
+	require.Equal(t, before.GetCounter().GetValue()+1, after.GetCounter().GetValue())
+
+	// Deleting an instance makes room for a new one.
+	require.NoError(t, cm.DeleteConfig("b"))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "c"}))
+}
+
+func TestBasicManager_MaxInstances_Lowered(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	logger := &warnRecorder{}
+	cm := NewBasicManager(DefaultBasicManagerConfig, logger, spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+
+	cfg := cm.ManagerConfig()
+	cfg.MaxInstances = 1
+	cm.UpdateManagerConfig(cfg)
+	require.Equal(t, 1, logger.Warnings())
+
+	// Instances over the new limit keep running and can still be updated, but
+	// no new instances are admitted.
+	require.Len(t, cm.ListInstances(), 3)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a", HostFilter: true}))
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "d"}), ErrInstanceLimitReached)
+
+	require.NoError(t, cm.DeleteConfig("a"))
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "d"}), ErrInstanceLimitReached)
+	require.Empty(t, cm.DeleteConfigs([]string{"b", "c"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "d"}))
+}
+
+func TestBasicManager_ApplyConfigFromReader(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		if c.Name == "broken" {
+			return nil, fmt.Errorf("cannot launch")
+		}
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	err := cm.ApplyConfigFromReader(strings.NewReader("name: a\n---\nname: broken\n---\nname: b\n"), "yaml")
+	require.EqualError(t, err, "failed to apply 1 of 3 configs: broken: cannot launch")
+	require.Len(t, cm.ListConfigs(), 2)
+
+	// Nothing should be applied when parsing fails.
+	err = cm.ApplyConfigFromReader(strings.NewReader(`[{"name": "c"}, {"name": 42, "bad": true}]`), "json")
+	require.Error(t, err)
+	require.NotContains(t, cm.ListConfigs(), "c")
+
+	// ...or when any config is invalid.
+	err = cm.ApplyConfigFromReader(strings.NewReader("name: d\n---\nname: e\nlabels:\n  0invalid: value\n"), "yaml")
+	require.EqualError(t, err, `invalid config e: invalid label name "0invalid"`)
+	require.NotContains(t, cm.ListConfigs(), "d")
+
+	// ...or when names are duplicated.
+	err = cm.ApplyConfigFromReader(strings.NewReader(`[{"name": "f"}, {"name": "f"}]`), "json")
+	require.Error(t, err)
+	require.NotContains(t, cm.ListConfigs(), "f")
+}
+
+func TestBasicManager_ApplyConfigFromReader_ValidateConfig(t *testing.T) {
+	cfg := DefaultBasicManagerConfig
+	cfg.ValidateConfig = func(c *Config) error {
+		if c.Name == "rejected" {
+			return fmt.Errorf("rejected for testing reasons")
+		}
+		return nil
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	})
+	defer cm.Stop()
+
+	err := cm.ApplyConfigFromReader(strings.NewReader("name: a\n---\nname: rejected\n"), "yaml")
+	require.EqualError(t, err, "invalid config rejected: rejected for testing reasons")
+	require.Empty(t, cm.ListConfigs())
+}
+
+func TestBasicManager_State(t *testing.T) {
+	stopping := make(chan struct{})
+	release := make(chan struct{})
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				close(stopping)
+				<-release
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	require.Equal(t, ManagerStateRunning, cm.State())
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+	stopped := make(chan struct{})
+	go func() {
+		cm.Stop()
+		close(stopped)
+	}()
+
+	<-stopping
+	require.Equal(t, ManagerStateStopping, cm.State())
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "new"}), ErrManagerStopped)
+
+	close(release)
+	<-stopped
+	require.Equal(t, ManagerStateStopped, cm.State())
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "test"}), ErrManagerStopped)
+	require.ErrorIs(t, cm.DeleteConfig("test"), ErrManagerStopped)
+	errs := cm.DeleteConfigs([]string{"test"})
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs["test"], ErrManagerStopped)
+	require.Empty(t, cm.ListConfigs())
+}
+
+func TestBasicManager_OverrideConfig(t *testing.T) {
+	var updates []Config
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				updates = append(updates, c)
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	stored := Config{Name: "test", MinWALTime: time.Hour}
+	override := Config{Name: "test", MinWALTime: time.Minute}
+
+	require.NoError(t, cm.ApplyConfig(stored))
+	require.NoError(t, cm.OverrideConfig("test", override))
+	require.Equal(t, []Config{override}, updates)
+	require.Equal(t, map[string]Config{"test": stored}, cm.ListConfigs(), "override should not be persisted")
+
+	// Re-applying the stored config should revert the override.
+	require.NoError(t, cm.ApplyConfig(stored))
+	require.Equal(t, []Config{override, stored}, updates)
+
+	require.Error(t, cm.OverrideConfig("test", Config{Name: "other"}))
+	require.ErrorIs(t, cm.OverrideConfig("missing", Config{Name: "missing"}), ErrConfigNotFound)
+}
+
+func TestBasicManager_ManagerConfig(t *testing.T) {
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), nil)
+	require.Equal(t, DefaultBasicManagerConfig.InstanceRestartBackoff, cm.ManagerConfig().InstanceRestartBackoff)
+
+	// Concurrent updates must be safe to read.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			cfg := DefaultBasicManagerConfig
+			cfg.InstanceRestartBackoff = time.Duration(i) * time.Second
+			cm.UpdateManagerConfig(cfg)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_ = cm.ManagerConfig()
+	}
+	<-done
+
+	require.Equal(t, 100*time.Second, cm.ManagerConfig().InstanceRestartBackoff)
+}
+
+func TestBasicManager_SetFactory(t *testing.T) {
+	newFactory := func(counter *int) Factory {
+		return func(c Config) (ManagedInstance, error) {
+			*counter++
+			return NoOpInstance{}, nil
+		}
+	}
+
+	var oldSpawned, newSpawned int
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), newFactory(&oldSpawned))
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "old"}))
+	cm.SetFactory(newFactory(&newSpawned))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "new"}))
+
+	// Re-applying the existing config is a dynamic update and must not spawn
+	// anything with either factory.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "old"}))
+
+	require.Equal(t, 1, oldSpawned)
+	require.Equal(t, 1, newSpawned)
+	require.Len(t, cm.ListInstances(), 2)
+}
+
+func TestBasicManager_StorageSizes(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			StorageSizeFunc: func() (int64, error) {
+				if c.Name == "broken" {
+					return 0, fmt.Errorf("failed to walk directory")
+				}
+				return int64(len(c.Name)), nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"a", "bb", "broken"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+
+	require.Equal(t, map[string]int64{"a": 1, "bb": 2}, cm.StorageSizes())
+}
+
+// truncatingInstance is a mockInstance implementing Truncater.
+type truncatingInstance struct {
+	*mockInstance
+	truncate func(ctx context.Context) error
+}
+
+func (i truncatingInstance) Truncate(ctx context.Context) error { return i.truncate(ctx) }
+
+func TestBasicManager_MaxStorageBytes(t *testing.T) {
+	var (
+		size      = atomic.NewInt64(2048)
+		truncates = atomic.NewInt32(0)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		inst := &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+			StorageSizeFunc: func() (int64, error) {
+				if c.Name == "untruncatable" {
+					return 2048, nil
+				}
+				return size.Load(), nil
+			},
+		}
+		if c.Name == "untruncatable" {
+			return inst, nil
+		}
+		return &truncatingInstance{mockInstance: inst, truncate: func(context.Context) error {
+			truncates.Inc()
+			size.Store(512)
+			return nil
+		}}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageCapInterval = 10 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	exceeded := func(name string) float64 {
+		var m dto.Metric
+		require.NoError(t, instanceStorageCapExceeded.WithLabelValues(name).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	before := exceeded("truncatable")
+
+	// Instances over their cap are truncated.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "truncatable", MaxStorageBytes: 1024}))
+	require.Eventually(t, func() bool { return truncates.Load() == 1 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, before+1, exceeded("truncatable"))
+
+	// Instances which can't be truncated keep being reported.
+	before = exceeded("untruncatable")
+	require.NoError(t, cm.ApplyConfig(Config{Name: "untruncatable", MaxStorageBytes: 1024}))
+	require.Eventually(t, func() bool { return exceeded("untruncatable") >= before+2 }, time.Second, 10*time.Millisecond)
+
+	// Removing the cap applies dynamically.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "truncatable"}))
+	size.Store(2048)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(1), truncates.Load())
+}
+
+func TestBasicManager_MetricLabelFunc(t *testing.T) {
+	var runs atomic.Int32
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if runs.Inc() == 1 {
+					return fmt.Errorf("first run fails")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			StorageSizeFunc: func() (int64, error) { return 10, nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.MetricLabelFunc = func(name string) string { return "tenant/" + name }
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "labelfunc"}))
+	require.Eventually(t, func() bool { return runs.Load() >= 2 }, time.Second, 10*time.Millisecond)
+
+	var exits dto.Metric
+	require.NoError(t, instanceAbnormalExits.WithLabelValues("tenant/labelfunc").Write(&exits))
+	require.Equal(t, float64(1), exits.GetCounter().GetValue())
+
+	// The real name is still used for lookups.
+	require.Equal(t, map[string]int64{"labelfunc": 10}, cm.StorageSizes())
+
+	var size dto.Metric
+	require.NoError(t, instanceStorageBytes.WithLabelValues("tenant/labelfunc").Write(&size))
+	require.Equal(t, float64(10), size.GetGauge().GetValue())
+}
+
+func TestBasicManager_DeleteConfig_Metrics(t *testing.T) {
+	runs := map[string]*atomic.Int32{"a": atomic.NewInt32(0), "b": atomic.NewInt32(0), "c": atomic.NewInt32(0)}
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if runs[c.Name].Inc() == 1 {
+					return fmt.Errorf("first run fails")
+				}
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.MetricLabelFunc = func(name string) string {
+		// a and b share their series.
+		if name == "b" {
+			name = "a"
+		}
+		return "deletemetrics/" + name
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for name := range runs {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+	require.Eventually(t, func() bool {
+		for _, n := range runs {
+			if n.Load() < 2 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	hasExits := func(label string) bool {
+		var exits dto.Metric
+		require.NoError(t, instanceAbnormalExits.WithLabelValues(label).Write(&exits))
+		return exits.GetCounter().GetValue() > 0
+	}
+
+	// Series of deleted instances are removed, unless another instance
+	// shares them.
+	require.NoError(t, cm.DeleteConfig("c"))
+	require.False(t, hasExits("deletemetrics/c"))
+	require.Empty(t, cm.DeleteConfigs([]string{"b"}))
+	require.True(t, hasExits("deletemetrics/a"))
+	require.NoError(t, cm.DeleteConfig("a"))
+	require.False(t, hasExits("deletemetrics/a"))
+}
+
+func TestBasicManager_OnTargetDrop(t *testing.T) {
+	counts := []int{10, 9, 4, 4, 8}
+	sampled := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			TargetsActiveFunc: func() map[string][]*scrape.Target {
+				n := int(sampled.Inc()) - 1
+				if n >= len(counts) {
+					n = len(counts) - 1
+				}
+				return map[string][]*scrape.Target{"job": make([]*scrape.Target, counts[n])}
+			},
+		}, nil
+	}
+
+	drops := make(chan [2]int, 10)
+
+	cfg := DefaultBasicManagerConfig
+	cfg.TargetSampleInterval = 10 * time.Millisecond
+	cfg.TargetDropThreshold = 0.5
+	cfg.OnTargetDrop = func(name string, from, to int) {
+		require.Equal(t, "test", name)
+		drops <- [2]int{from, to}
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool { return sampled.Load() > int64(len(counts)) }, time.Second, 10*time.Millisecond)
+
+	// Only the drop from 9 to 4 targets is more than half.
+	require.Len(t, drops, 1)
+	require.Equal(t, [2]int{9, 4}, <-drops)
+}
+
+func TestIsTargetDrop(t *testing.T) {
+	tt := []struct {
+		from, to  int
+		threshold float64
+		expect    bool
+	}{
+		{from: -1, to: 0, threshold: 0.5, expect: false},
+		{from: 0, to: 0, threshold: 0.5, expect: false},
+		{from: 10, to: 12, threshold: 0.5, expect: false},
+		{from: 10, to: 5, threshold: 0.5, expect: false},
+		{from: 10, to: 4, threshold: 0.5, expect: true},
+		{from: 10, to: 9, threshold: 0, expect: true},
+	}
+
+	for _, tc := range tt {
+		require.Equal(t, tc.expect, isTargetDrop(tc.from, tc.to, tc.threshold), "from %d to %d with threshold %v", tc.from, tc.to, tc.threshold)
+	}
+}
+
+func TestBasicManager_LastScrapeTimes(t *testing.T) {
+	scraped := time.Now()
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			LastScrapeTimeFunc: func() time.Time {
+				if c.Name == "never" {
+					return time.Time{}
+				}
+				return scraped
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"scraped", "never"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+
+	require.Equal(t, map[string]time.Time{
+		"scraped": scraped,
+		"never":   {},
+	}, cm.LastScrapeTimes())
+}
+
+// statsInstance is a mockInstance implementing StatsReporter.
+type statsInstance struct {
+	*mockInstance
+	stats InstanceStats
+}
+
+func (i statsInstance) Stats() InstanceStats { return i.stats }
+
+func TestBasicManager_InstanceStats(t *testing.T) {
+	stats := InstanceStats{
+		ActiveTargets:  3,
+		StorageSize:    1024,
+		LastScrapeTime: time.Now(),
+		StartTime:      time.Now().Add(-time.Minute),
+	}
+	spawner := func(c Config) (ManagedInstance, error) {
+		inst := &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}
+		if c.Name == "plain" {
+			return inst, nil
+		}
+		return statsInstance{mockInstance: inst, stats: stats}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"reporting", "plain"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+
+	res := cm.InstanceStats()
+	require.Equal(t, map[string]InstanceStats{
+		"reporting": stats,
+		"plain":     {},
+	}, res)
+	require.GreaterOrEqual(t, int64(res["reporting"].Uptime()), int64(time.Minute))
+	require.Zero(t, res["plain"].Uptime())
+}
+
+func TestBasicManager_Quarantine(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.QuarantineThreshold = 3
+	cfg.QuarantineInterval = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateQuarantined
+	}, time.Second, 10*time.Millisecond)
+
+	// The instance should not have been retried after being quarantined.
+	require.Equal(t, int64(3), runs.Load())
+}
+
+func TestBasicManager_NoRestart(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", NoRestart: true}))
+	requireEvent(t, events, EventStarted, "")
+	requireEvent(t, events, EventStopped, "")
+
+	// The instance should have been removed instead of being restarted.
+	require.Eventually(t, func() bool {
+		return len(cm.ListConfigs()) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(1), runs.Load())
+}
+
+func TestBasicManager_StartupDelay(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				return ErrInvalidUpdate{Inner: fmt.Errorf("can't update")}
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StartupDelay = 200 * time.Millisecond
+	cfg.StartupJitter = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	start := time.Now()
+	require.NoError(t, cm.ApplyConfig(Config{Name: "delayed"}))
+	require.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, 10*time.Millisecond)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(cfg.StartupDelay))
+
+	// Instances restarted for a new config aren't delayed.
+	start = time.Now()
+	require.NoError(t, cm.ApplyConfig(Config{Name: "delayed", HostFilter: true}))
+	require.Eventually(t, func() bool { return runs.Load() == 2 }, time.Second, time.Millisecond)
+	require.Less(t, int64(time.Since(start)), int64(cfg.StartupDelay))
+
+	// Instances can be deleted while they're waiting.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "deleted"}))
+	require.NoError(t, cm.DeleteConfig("deleted"))
+	require.Equal(t, int64(2), runs.Load())
+}
+
+// readyInstance is a mockInstance implementing ReadinessReporter.
+type readyInstance struct {
+	*mockInstance
+	ready *atomic.Bool
+}
+
+func (i readyInstance) Ready() bool { return i.ready.Load() }
+
+func TestBasicManager_StartupTimeout(t *testing.T) {
+	var (
+		runs  = atomic.NewInt64(0)
+		ready = atomic.NewBool(false)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return readyInstance{
+			mockInstance: &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					// The first run hangs while initializing.
+					if runs.Inc() > 1 {
+						ready.Store(true)
+					}
+					<-ctx.Done()
+					ready.Store(false)
+					return nil
+				},
+			},
+			ready: ready,
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = 10 * time.Millisecond
+	cfg.StartupTimeout = 200 * time.Millisecond
+
+	var before float64
+	{
+		var m dto.Metric
+		require.NoError(t, instanceStartupTimeouts.WithLabelValues("test").Write(&m))
+		before = m.GetCounter().GetValue()
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	requireEvent(t, events, EventStarted, "")
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateStarting
+	}, time.Second, 10*time.Millisecond)
+
+	// The instance is restarted once the timeout elapses and reported as
+	// running once ready.
+	requireEvent(t, events, EventRestarted, RestartCauseStartupTimeout)
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateRunning
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(2), runs.Load())
+
+	var m dto.Metric
+	require.NoError(t, instanceStartupTimeouts.WithLabelValues("test").Write(&m))
+	require.Equal(t, before+1, m.GetCounter().GetValue())
+}
+
+func TestBasicManager_Panic(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if runs.Inc() == 1 {
+					panic("something went very wrong")
+				}
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// The panic should be treated as an abnormal exit and cause a restart.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "panics"}))
+	require.Eventually(t, func() bool {
+		return runs.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBasicManager_ProfilerLabels(t *testing.T) {
+	labels := make(chan string, 1)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				name, _ := pprof.Label(ctx, "instance")
+				labels <- name
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "labelled"}))
+	select {
+	case name := <-labels:
+		require.Equal(t, "labelled", name)
+	case <-time.After(time.Second):
+		require.FailNow(t, "instance did not run")
+	}
+}
+
+func TestBasicManager_RestartStreakResetAfter(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				time.Sleep(20 * time.Millisecond)
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Millisecond
+	cfg.QuarantineThreshold = 2
+	cfg.QuarantineInterval = time.Hour
+	cfg.RestartStreakResetAfter = 10 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// Every run lasts longer than RestartStreakResetAfter, so the instance
+	// should never reach the quarantine threshold.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		return runs.Load() >= 4
+	}, time.Second, 10*time.Millisecond)
+
+	state, _ := cm.InstanceState("test")
+	require.NotEqual(t, InstanceStateQuarantined, state)
+}
+
+func TestResetsStreak(t *testing.T) {
+	tt := []struct {
+		ran, window time.Duration
+		expect      bool
+	}{
+		{ran: time.Minute - time.Nanosecond, window: time.Minute, expect: false},
+		{ran: time.Minute, window: time.Minute, expect: true},
+		{ran: time.Minute + time.Nanosecond, window: time.Minute, expect: true},
+		{ran: time.Hour, window: 0, expect: false},
+	}
+
+	for _, tc := range tt {
+		require.Equal(t, tc.expect, resetsStreak(tc.ran, tc.window), "ran %s, window %s", tc.ran, tc.window)
+	}
+}
+
+func TestErrorLogLimiter(t *testing.T) {
+	var (
+		l       errorLogLimiter
+		errA    = fmt.Errorf("error a")
+		errB    = fmt.Errorf("error b")
+		logged  []int
+		observe = func(err error) {
+			if shouldLog, repeated := l.Observe(err, 3); shouldLog {
+				logged = append(logged, repeated)
+			}
+		}
+	)
+
+	for i := 0; i < 7; i++ {
+		observe(errA)
+	}
+	require.Equal(t, []int{0, 3, 6}, logged)
+
+	// A different error should be logged immediately and start a new count.
+	logged = nil
+	observe(errB)
+	observe(errB)
+	observe(errA)
+	require.Equal(t, []int{0, 0}, logged)
+}
+
+func TestBasicManager_BackoffStrategy(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if runs.Inc() > 3 {
+					<-ctx.Done()
+					return nil
+				}
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	strategy := &recordingBackoff{}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+	cfg.NewBackoffStrategy = func() BackoffStrategy { return strategy }
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// The strategy's backoff should be used instead of InstanceRestartBackoff.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		return runs.Load() == 4
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []int{1, 2, 3}, strategy.Attempts())
+
+	cm.ResetBackoffs()
+	require.Equal(t, int64(1), strategy.resets.Load())
+}
+
+func TestBasicManager_CurrentBackoff(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if runs.Inc() == 1 {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	strategy := &recordingBackoff{}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.NewBackoffStrategy = func() BackoffStrategy { return strategy }
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	_, err := cm.CurrentBackoff("test")
+	require.ErrorIs(t, err, ErrConfigNotFound)
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		return runs.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		backoff, err := cm.CurrentBackoff("test")
+		require.NoError(t, err)
+		require.Equal(t, time.Millisecond, backoff)
+	}
+	require.Equal(t, []int{1}, strategy.Attempts(), "CurrentBackoff must not call the strategy")
+
+	cm.ResetBackoffs()
+	backoff, err := cm.CurrentBackoff("test")
+	require.NoError(t, err)
+	require.Zero(t, backoff)
+}
+
+func TestBasicManager_CurrentBackoff_Quarantined(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.QuarantineThreshold = 1
+	cfg.QuarantineInterval = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		backoff, err := cm.CurrentBackoff("test")
+		return err == nil && backoff == time.Hour
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBasicManager_BackoffRemaining(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	_, ok := cm.BackoffRemaining("test")
+	require.False(t, ok)
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		_, ok := cm.BackoffRemaining("test")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	remaining, _ := cm.BackoffRemaining("test")
+	require.True(t, remaining > 59*time.Minute && remaining <= time.Hour, "unexpected remaining backoff %s", remaining)
+
+	require.NoError(t, cm.DeleteConfig("test"))
+	_, ok = cm.BackoffRemaining("test")
+	require.False(t, ok)
+}
+
+func TestBasicManager_WaitAllRunning(t *testing.T) {
+	healthy := atomic.NewBool(false)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if c.Name == "flaky" && !healthy.Load() {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.WaitAllRunning(context.Background()), "no instances should be running")
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "stable"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "flaky"}))
+	require.Eventually(t, func() bool {
+		return cm.InstanceStatuses()["flaky"].State == InstanceStateBackingOff
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := cm.WaitAllRunning(ctx)
+	require.EqualError(t, err, "instances not running: flaky (backing_off): context deadline exceeded")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	healthy.Store(true)
+	cm.ResetBackoffs()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, cm.WaitAllRunning(ctx))
+}
+
+// recordingBackoff is a BackoffStrategy which records the attempts it was
+// called with.
+type recordingBackoff struct {
+	mut      sync.Mutex
+	attempts []int
+	resets   atomic.Int64
+}
+
+func (b *recordingBackoff) Next(attempt int) time.Duration {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.attempts = append(b.attempts, attempt)
+	return time.Millisecond
+}
+
+func (b *recordingBackoff) Reset() { b.resets.Inc() }
+
+func (b *recordingBackoff) Attempts() []int {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return append([]int(nil), b.attempts...)
+}
+
+func TestBasicManager_ResetBackoffs(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateBackingOff
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(1), runs.Load())
+
+	// Resetting should restart the instance without waiting for the hour-long
+	// backoff.
+	cm.ResetBackoffs()
+	require.Eventually(t, func() bool {
+		return runs.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBasicManager_SubscribeTargetChanges(t *testing.T) {
+	changed := make(chan struct{})
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &notifyingInstance{
+			mockInstance: mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					<-ctx.Done()
+					return nil
+				},
+			},
+			changed: changed,
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	sub, unsubscribe := cm.SubscribeTargetChanges()
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+	changed <- struct{}{}
+	select {
+	case name := <-sub:
+		require.Equal(t, "test", name)
+	case <-time.After(time.Second):
+		require.FailNow(t, "did not receive target change notification")
+	}
+
+	unsubscribe()
+	_, open := <-sub
+	require.False(t, open, "channel should be closed after unsubscribing")
+}
+
+// notifyingInstance is a mockInstance which implements TargetsNotifier.
+type notifyingInstance struct {
+	mockInstance
+	changed chan struct{}
+}
+
+func (i *notifyingInstance) TargetsChanged() <-chan struct{} { return i.changed }
+
+func TestBasicManager_DeleteConfigs(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return NoOpInstance{}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+
+	errs := cm.DeleteConfigs([]string{"a", "missing", "b"})
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs["missing"], ErrConfigNotFound)
+
+	// Processes remove themselves from the manager shortly after stopping.
+	require.Eventually(t, func() bool {
+		names := configNames(cm.ListConfigs())
+		return len(names) == 1 && names[0] == "c"
+	}, time.Second, 10*time.Millisecond)
+
+	require.Empty(t, cm.DeleteConfigs([]string{"c"}))
+}
+
+func TestBasicManager_InstanceStatuses(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		mock := mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}
+		if c.Name == "reporting" {
+			return &reportingInstance{mockInstance: mock, message: "remote_write: 401"}, nil
+		}
+		return &mock, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "reporting"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "silent"}))
+
+	require.Equal(t, map[string]InstanceStatus{
+		"reporting": {State: InstanceStateRunning, HealthMessage: "remote_write: 401"},
+		"silent":    {State: InstanceStateRunning},
+	}, cm.InstanceStatuses())
+}
+
+func TestBasicManager_FilterInstances(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &reportingInstance{
+			mockInstance: mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					<-ctx.Done()
+					return nil
+				},
+			},
+			message: c.Name,
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+
+	tt := []struct {
+		name   string
+		pred   func(string, ManagedInstance, InstanceStatus) bool
+		expect []string
+	}{
+		{
+			name:   "none",
+			pred:   func(string, ManagedInstance, InstanceStatus) bool { return false },
+			expect: []string{},
+		},
+		{
+			name: "some",
+			pred: func(name string, _ ManagedInstance, status InstanceStatus) bool {
+				return name != "b" && status.HealthMessage == name
+			},
+			expect: []string{"a", "c"},
+		},
+		{
+			name: "all",
+			pred: func(_ string, _ ManagedInstance, status InstanceStatus) bool {
+				return status.State == InstanceStateRunning
+			},
+			expect: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res := cm.FilterInstances(tc.pred)
+
+			names := make([]string, 0, len(res))
+			for name := range res {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			require.Equal(t, tc.expect, names)
+		})
+	}
+}
+
+// reportingInstance is a mockInstance which implements HealthReporter.
+type reportingInstance struct {
+	mockInstance
+	message string
+}
+
+func (i *reportingInstance) HealthMessage() string { return i.message }
+
+func TestBasicManager_OnBeforeStop(t *testing.T) {
+	stopped := atomic.NewBool(false)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				stopped.Store(true)
+				return nil
+			},
+		}, nil
+	}
+
+	t.Run("runs before stopping", func(t *testing.T) {
+		var (
+			hookCalls     []string
+			stoppedInHook bool
+		)
+
+		cfg := DefaultBasicManagerConfig
+		cfg.OnBeforeStop = func(name string, c Config) {
+			hookCalls = append(hookCalls, name)
+			stoppedInHook = stopped.Load()
+		}
+
+		cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+		defer cm.Stop()
+
+		require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+		require.NoError(t, cm.DeleteConfig("test"))
+		require.Equal(t, []string{"test"}, hookCalls)
+		require.False(t, stoppedInHook, "hook should run before the instance is stopped")
+		require.True(t, stopped.Load())
+	})
+
+	t.Run("hanging hook times out", func(t *testing.T) {
+		hang := make(chan struct{})
+		defer close(hang)
+
+		cfg := DefaultBasicManagerConfig
+		cfg.OnBeforeStop = func(string, Config) { <-hang }
+		cfg.OnBeforeStopTimeout = 10 * time.Millisecond
+
+		cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+		require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+		done := make(chan struct{})
+		go func() {
+			cm.Stop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			require.FailNow(t, "Stop blocked on a hanging pre-stop hook")
+		}
+	})
+
+	t.Run("forced restart doesn't block other calls", func(t *testing.T) {
+		var (
+			inHook  = make(chan struct{}, 1)
+			release = make(chan struct{})
+		)
+
+		cfg := DefaultBasicManagerConfig
+		cfg.OnBeforeStop = func(string, Config) {
+			inHook <- struct{}{}
+			<-release
+		}
+
+		cm := NewBasicManager(cfg, log.NewNopLogger(), func(c Config) (ManagedInstance, error) {
+			return &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					<-ctx.Done()
+					return nil
+				},
+				UpdateFunc: func(c Config) error {
+					return ErrInvalidUpdate{Inner: fmt.Errorf("cannot dynamically update for testing reasons")}
+				},
+			}, nil
+		})
+		defer cm.Stop()
+
+		require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+		applied := make(chan error, 1)
+		go func() { applied <- cm.ApplyConfig(Config{Name: "test"}) }()
+		<-inHook
+
+		listed := make(chan struct{})
+		go func() {
+			cm.ListInstances()
+			close(listed)
+		}()
+		select {
+		case <-listed:
+		case <-time.After(time.Second):
+			require.FailNow(t, "ListInstances blocked on the pre-stop hook of a restarting instance")
+		}
+
+		close(release)
+		require.NoError(t, <-applied)
+		require.Len(t, cm.ListInstances(), 1)
+	})
+}
+
+// TestBasicManager_RapidUpdates forces restarts of the same instances from
+// several goroutines at once to check that stopping the replaced process
+// never deadlocks with it removing itself from the BasicManager.
+func TestBasicManager_RapidUpdates(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				return ErrInvalidUpdate{Inner: fmt.Errorf("always restart")}
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	const (
+		workers = 8
+		updates = 50
+	)
+	names := []string{"a", "b", "c"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for w := 0; w < workers; w++ {
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < updates; i++ {
+					name := names[(w+i)%len(names)]
+					require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+					_ = cm.ListInstances()
+				}
+			}(w)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		require.FailNow(t, "rapid updates did not finish; the BasicManager probably deadlocked")
+	}
+
+	require.Len(t, cm.ListInstances(), len(names))
+	for _, name := range names {
+		require.NoError(t, cm.DeleteConfig(name))
+	}
+	require.Empty(t, cm.ListInstances())
+}
+
+func TestBasicManager_ConfigHooks(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				if c.HostFilter {
+					return ErrInvalidUpdate{
+						Inner:  errImmutableFields{Fields: []string{"host_filter"}},
+						Fields: []string{"host_filter"},
+					}
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	var calls []string
+
+	cfg := DefaultBasicManagerConfig
+	cfg.OnConfigApplied = func(c Config, result ApplyConfigResult) {
+		calls = append(calls, fmt.Sprintf("applied %s: %s", c.Name, result))
+	}
+	cfg.OnConfigRestarted = func(c Config, reason ErrInvalidUpdate) {
+		calls = append(calls, fmt.Sprintf("restarted %s: %v (%s)", c.Name, reason.Fields, reason))
+	}
+	cfg.OnConfigDeleted = func(name string) {
+		calls = append(calls, "deleted "+name)
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a", HostFilter: true}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "b"}))
+	require.NoError(t, cm.DeleteConfig("a"))
+	require.Empty(t, cm.DeleteConfigs([]string{"b", "b"}))
+
+	// Failed changes don't invoke the hooks.
+	require.ErrorIs(t, cm.DeleteConfig("a"), ErrConfigNotFound)
+
+	require.Equal(t, []string{
+		"applied a: created",
+		"applied a: updated",
+		"applied a: restarted",
+		"restarted a: [host_filter] (host_filter cannot be changed dynamically)",
+		"applied b: created",
+		"deleted a",
+		"deleted b",
+	}, calls)
+}
+
+func TestBasicManager_ApplyOutcomes(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				switch {
+				case c.HostFilter:
+					return ErrInvalidUpdate{Inner: fmt.Errorf("can't enable host filtering")}
+				case c.WriteStaleOnShutdown:
+					return fmt.Errorf("update failed")
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	outcomes := func() map[string]float64 {
+		res := make(map[string]float64)
+		for _, outcome := range []string{"created", "dynamic_update", "restart_update", "unchanged", "failed"} {
+			var m dto.Metric
+			require.NoError(t, applyOutcomes.WithLabelValues(outcome).Write(&m))
+			res[outcome] = m.GetCounter().GetValue()
+		}
+		return res
+	}
+	before := outcomes()
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a", Labels: map[string]string{"team": "a"}}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a", HostFilter: true}))
+	require.Error(t, cm.ApplyConfig(Config{Name: "a", WriteStaleOnShutdown: true}))
+
+	after := outcomes()
+	for outcome, delta := range map[string]float64{
+		"created":        1,
+		"dynamic_update": 1,
+		"restart_update": 1,
+		"unchanged":      1,
+		"failed":         1,
+	} {
+		require.Equal(t, before[outcome]+delta, after[outcome], outcome)
+	}
+}
+
+func TestBasicManager_StopConcurrency(t *testing.T) {
+	var (
+		stopping    = atomic.NewInt64(0)
+		maxStopping = atomic.NewInt64(0)
+		stopped     = atomic.NewInt64(0)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+
+				n := stopping.Inc()
+				for {
+					max := maxStopping.Load()
+					if n <= max || maxStopping.CAS(max, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				stopping.Dec()
+				stopped.Inc()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StopConcurrency = 2
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, cm.ApplyConfig(Config{Name: fmt.Sprintf("instance-%d", i)}))
+	}
+
+	cm.Stop()
+	require.Equal(t, int64(10), stopped.Load(), "Stop should wait for all instances")
+	require.LessOrEqual(t, maxStopping.Load(), int64(2))
+}
+
+func TestBasicManager_UpdateConcurrency(t *testing.T) {
+	var (
+		updating    = atomic.NewInt64(0)
+		maxUpdating = atomic.NewInt64(0)
+		blockUpdate = make(chan struct{})
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(newCfg Config) error {
+				if newCfg.Name != "blocked" {
+					return nil
+				}
+
+				n := updating.Inc()
+				for {
+					max := maxUpdating.Load()
+					if n <= max || maxUpdating.CAS(max, n) {
+						break
+					}
+				}
+				<-blockUpdate
+				updating.Dec()
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+	require.NoError(t, cm.ApplyConfig(Config{Name: "blocked"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "other"}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			labels := map[string]string{"update": fmt.Sprint(i)}
+			require.NoError(t, cm.ApplyConfig(Config{Name: "blocked", Labels: labels}))
+		}(i)
+	}
+	require.Eventually(t, func() bool { return updating.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// Other instances can be changed while an update is in progress.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "other", Labels: map[string]string{"a": "b"}}))
+	require.NoError(t, cm.DeleteConfig("other"))
+
+	close(blockUpdate)
+	wg.Wait()
+	require.Equal(t, int64(1), maxUpdating.Load(), "updates to the same instance should be serialized")
+}
+
+func TestBasicManager_CacheListConfigs(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.CacheListConfigs = true
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "a"}))
+	require.Equal(t, []string{"a"}, configNames(cm.ListConfigs()))
+
+	// Changing the returned map doesn't change the snapshot.
+	delete(cm.ListConfigs(), "a")
+	require.Equal(t, []string{"a"}, configNames(cm.ListConfigs()))
+
+	// Every change is visible as soon as it's made, even by concurrent
+	// readers.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				cm.ListConfigs()
+			}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		labels := map[string]string{"i": fmt.Sprint(i)}
+		require.NoError(t, cm.ApplyConfig(Config{Name: "a", Labels: labels}))
+		require.Equal(t, labels, cm.ListConfigs()["a"].Labels)
+
+		require.NoError(t, cm.ApplyConfig(Config{Name: "b"}))
+		require.Equal(t, []string{"a", "b"}, configNames(cm.ListConfigs()))
+
+		require.NoError(t, cm.DeleteConfig("b"))
+		require.Equal(t, []string{"a"}, configNames(cm.ListConfigs()))
+	}
+}
+
+func configNames(configs map[string]Config) []string {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestBasicManager_PurgeOrphanedStorage(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "purge_orphaned_storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"known", "running", "orphaned"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name, "wal"), 0700))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600))
+
+	spawner := func(c Config) (ManagedInstance, error) {
+		return NoOpInstance{}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageDirectory = dir
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+	require.NoError(t, cm.ApplyConfig(Config{Name: "running"}))
+
+	purged, err := cm.PurgeOrphanedStorage([]string{"known"})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "orphaned")}, purged)
+
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var remaining []string
+	for _, info := range infos {
+		remaining = append(remaining, info.Name())
+	}
+	require.Equal(t, []string{"file", "known", "running"}, remaining)
+}
+
+func TestBasicManager_StorageDirFunc(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "storage_dir_func")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"wal-known", "wal-running", "running"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0700))
+	}
+
+	spawner := func(c Config) (ManagedInstance, error) {
+		return NoOpInstance{}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageDirectory = dir
+	cfg.StorageDirFunc = func(c Config) string {
+		return filepath.Join(dir, "wal-"+c.Name)
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+	require.NoError(t, cm.ApplyConfig(Config{Name: "running"}))
+	require.Equal(t, filepath.Join(dir, "wal-running"), cm.StorageDir(Config{Name: "running"}))
+
+	// The directory named after the instance doesn't match the layout of
+	// StorageDirFunc, so it's orphaned.
+	purged, err := cm.PurgeOrphanedStorage([]string{"known"})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "running")}, purged)
+
+	cfg.StorageDirFunc = nil
+	cm.UpdateManagerConfig(cfg)
+	require.Equal(t, filepath.Join(dir, "running"), cm.StorageDir(Config{Name: "running"}))
+}
+
+func TestBasicManager_StorageCheck(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "storage_check")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A directory can't be created under a regular file, making it
+	// unwritable even when running as root.
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0600))
+
+	spawned := 0
+	spawner := func(c Config) (ManagedInstance, error) {
+		spawned++
+		return NoOpInstance{}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageDirectory = filepath.Join(file, "wal")
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	err = cm.ApplyConfig(Config{Name: "test"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to create storage directory")
+	require.Equal(t, 0, spawned, "instance should not have been launched")
+
+	cfg.SkipStorageCheck = true
+	cm.UpdateManagerConfig(cfg)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Equal(t, 1, spawned)
+
+	// The probe file must not be left behind in writable directories.
+	cfg.StorageDirectory = filepath.Join(dir, "wal")
+	cfg.SkipStorageCheck = false
+	cm.UpdateManagerConfig(cfg)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "other"}))
+	infos, err := ioutil.ReadDir(cfg.StorageDirectory)
+	require.NoError(t, err)
+	require.Empty(t, infos)
+}
+
+func TestBasicManager_StorageDirectoryMode(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "storage_mode")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageDirectory = filepath.Join(dir, "parent", "wal")
+	cfg.StorageDirectoryMode = 0
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), func(c Config) (ManagedInstance, error) {
+		return NoOpInstance{}, nil
+	})
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+	// The umask may remove permissions but never adds any.
+	for _, path := range []string{filepath.Join(dir, "parent"), cfg.StorageDirectory} {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+		require.Zero(t, fi.Mode().Perm()&^0750, "%s has more permissions than 0750: %s", path, fi.Mode().Perm())
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "dir_size")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 10), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "nested", "b"), make([]byte, 5), 0600))
+
+	size, err := dirSize(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(15), size)
+
+	size, err = dirSize(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+}
+
 type mockInstance struct {
 	RunFunc              func(ctx context.Context) error
 	UpdateFunc           func(c Config) error
 	TargetsActiveFunc    func() map[string][]*scrape.Target
 	StorageDirectoryFunc func() string
+	StorageSizeFunc      func() (int64, error)
+	LastScrapeTimeFunc   func() time.Time
 }
 
 func (m mockInstance) Run(ctx context.Context) error {
@@ -130,3 +2025,222 @@ func (m mockInstance) StorageDirectory() string {
 	}
 	panic("StorageDirectoryFunc not provided")
 }
+
+func (m mockInstance) StorageSize() (int64, error) {
+	if m.StorageSizeFunc != nil {
+		return m.StorageSizeFunc()
+	}
+	panic("StorageSizeFunc not provided")
+}
+
+func (m mockInstance) LastScrapeTime() time.Time {
+	if m.LastScrapeTimeFunc != nil {
+		return m.LastScrapeTimeFunc()
+	}
+	panic("LastScrapeTimeFunc not provided")
+}
+
+func TestMockManager_Calls(t *testing.T) {
+	m := MockManager{
+		ApplyConfigFunc: func(Config) error { return nil },
+		StopFunc:        func() {},
+		Calls:           &MockManagerCalls{},
+	}
+
+	// Copies count calls too, so the MockManager can be used by value.
+	var manager Manager = m
+	require.NoError(t, manager.ApplyConfig(Config{Name: "a"}))
+
+	require.NoError(t, m.ApplyConfig(Config{Name: "b"}))
+	m.Stop()
+
+	// Calls to unimplemented methods are counted too.
+	require.Panics(t, func() { m.DeleteConfig("a") })
+
+	require.Equal(t, 2, m.ApplyConfigCalls())
+	require.Equal(t, 1, m.StopCalls())
+	require.Equal(t, 1, m.DeleteConfigCalls())
+	require.Equal(t, 0, m.ListConfigsCalls())
+	require.Equal(t, 0, m.ListInstancesCalls())
+	require.Equal(t, 0, m.InstanceStatusesCalls())
+}
+
+func TestBasicManager_BlockOnSaturation(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if c.Name == "crashing" {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+	cfg.BlockOnSaturation = true
+	cfg.MaxRestartsInFlight = 1
+	cfg.SaturationTimeout = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "crashing"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("crashing")
+		return state == InstanceStateBackingOff
+	}, time.Second, 10*time.Millisecond)
+
+	start := time.Now()
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "new"}), ErrSaturated)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(cfg.SaturationTimeout))
+
+	// Existing instances can still be updated.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "crashing"}))
+
+	// Once the restart is no longer in flight, applies go through again.
+	require.NoError(t, cm.DeleteConfig("crashing"))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "new"}))
+}
+
+func TestBasicManager_PendingApplies(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if c.Name == "crashing" {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+	cfg.BlockOnSaturation = true
+	cfg.MaxRestartsInFlight = 1
+	cfg.SaturationTimeout = time.Minute
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "crashing"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("crashing")
+		return state == InstanceStateBackingOff
+	}, time.Second, 10*time.Millisecond)
+
+	var before dto.Metric
+	require.NoError(t, pendingApplies.Write(&before))
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"b", "a", "b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+		}(name)
+	}
+	require.Eventually(t, func() bool {
+		var m dto.Metric
+		require.NoError(t, pendingApplies.Write(&m))
+		return m.GetGauge().GetValue()-before.GetGauge().GetValue() == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"a", "b"}, cm.PendingApplies())
+
+	// Once the restart is no longer in flight, the queue drains.
+	require.NoError(t, cm.DeleteConfig("crashing"))
+	wg.Wait()
+	require.Empty(t, cm.PendingApplies())
+
+	var after dto.Metric
+	require.NoError(t, pendingApplies.Write(&after))
+	require.Equal(t, before.GetGauge().GetValue(), after.GetGauge().GetValue())
+}
+
+func TestBasicManager_MaxRestartsInFlight_Lowered(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if strings.HasPrefix(c.Name, "crashing") {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+	cfg.BlockOnSaturation = true
+	cfg.MaxRestartsInFlight = 3
+	cfg.SaturationTimeout = 100 * time.Millisecond
+
+	logger := &warnRecorder{}
+	cm := NewBasicManager(cfg, logger, spawner)
+	defer cm.Stop()
+
+	for _, name := range []string{"crashing-a", "crashing-b"} {
+		require.NoError(t, cm.ApplyConfig(Config{Name: name}))
+	}
+	require.Eventually(t, func() bool {
+		return cm.instancesInState(InstanceStateBackingOff) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cfg.MaxRestartsInFlight = 1
+	cm.UpdateManagerConfig(cfg)
+	require.Equal(t, 1, logger.Warnings())
+
+	// Backing off instances are left alone, but new instances are blocked.
+	require.Equal(t, 2, cm.instancesInState(InstanceStateBackingOff))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "crashing-a"}))
+	require.ErrorIs(t, cm.ApplyConfig(Config{Name: "new"}), ErrSaturated)
+
+	require.Empty(t, cm.DeleteConfigs([]string{"crashing-a", "crashing-b"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "new"}))
+}
+
+// warnRecorder is a logger which counts the warnings logged through it.
+type warnRecorder struct {
+	mut      sync.Mutex
+	warnings int
+}
+
+func (r *warnRecorder) Log(keyvals ...interface{}) error {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == level.Key() && keyvals[i+1] == level.WarnValue() {
+			r.mut.Lock()
+			r.warnings++
+			r.mut.Unlock()
+		}
+	}
+	return nil
+}
+
+func (r *warnRecorder) Warnings() int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.warnings
+}
+
+// instancesInState returns how many instances managed by m are in the given
+// state.
+func (m *BasicManager) instancesInState(state InstanceState) int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	var n int
+	for _, proc := range m.processes {
+		if proc.State() == state {
+			n++
+		}
+	}
+	return n
+}
@@ -2,7 +2,11 @@ package instance
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 
 	config_util "github.com/prometheus/common/config"
 	"gopkg.in/yaml.v2"
@@ -18,6 +22,90 @@ func UnmarshalConfig(r io.Reader) (*Config, error) {
 	return &cfg, err
 }
 
+// UnmarshalConfigs unmarshals one or more instance configs from a reader.
+// format must be either "yaml" or "json". YAML input may hold multiple
+// configs as separate documents, while JSON input may be a single object or
+// an array of objects. Every config must have a unique, non-empty name.
+func UnmarshalConfigs(r io.Reader, format string) ([]Config, error) {
+	var (
+		cfgs []Config
+		err  error
+	)
+
+	switch format {
+	case "yaml":
+		cfgs, err = unmarshalYAMLConfigs(r)
+	case "json":
+		cfgs, err = unmarshalJSONConfigs(r)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q, must be yaml or json", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfgs) == 0 {
+		return nil, errors.New("no configs found")
+	}
+
+	names := make(map[string]struct{}, len(cfgs))
+	for idx, c := range cfgs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("config %d is missing a name", idx)
+		}
+		if _, exists := names[c.Name]; exists {
+			return nil, fmt.Errorf("found multiple configs named %q", c.Name)
+		}
+		names[c.Name] = struct{}{}
+	}
+	return cfgs, nil
+}
+
+func unmarshalYAMLConfigs(r io.Reader) ([]Config, error) {
+	var cfgs []Config
+
+	dec := yaml.NewDecoder(r)
+	dec.SetStrict(true)
+	for idx := 0; ; idx++ {
+		var cfg Config
+		err := dec.Decode(&cfg)
+		if err == io.EOF {
+			return cfgs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse config in document %d: %w", idx, err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+}
+
+func unmarshalJSONConfigs(r io.Reader) ([]Config, error) {
+	bb, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configs: %w", err)
+	}
+
+	// Configs only have YAML tags, so each individual config is decoded as
+	// YAML, which is a superset of JSON.
+	var raws []json.RawMessage
+	if trimmed := bytes.TrimSpace(bb); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &raws); err != nil {
+			return nil, fmt.Errorf("failed to parse configs: %w", err)
+		}
+	} else if len(trimmed) > 0 {
+		raws = []json.RawMessage{trimmed}
+	}
+
+	cfgs := make([]Config, 0, len(raws))
+	for idx, raw := range raws {
+		var cfg Config
+		if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config at index %d: %w", idx, err)
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
 // MarshalConfig marshals an instance config based on a provided content type.
 func MarshalConfig(c *Config, scrubSecrets bool) ([]byte, error) {
 	var buf bytes.Buffer
@@ -168,3 +168,95 @@ remote_flush_deadline: 1m0s
 		require.YAMLEq(t, cfg, string(out))
 	})
 }
+
+func TestUnmarshalConfigs(t *testing.T) {
+	tt := []struct {
+		name   string
+		format string
+		input  string
+		expect []string
+		err    string
+	}{
+		{
+			name:   "single yaml document",
+			format: "yaml",
+			input:  "name: a",
+			expect: []string{"a"},
+		},
+		{
+			name:   "multiple yaml documents",
+			format: "yaml",
+			input:  "name: a\n---\nname: b\n",
+			expect: []string{"a", "b"},
+		},
+		{
+			name:   "json object",
+			format: "json",
+			input:  `{"name": "a"}`,
+			expect: []string{"a"},
+		},
+		{
+			name:   "json array",
+			format: "json",
+			input:  `[{"name": "a"}, {"name": "b"}]`,
+			expect: []string{"a", "b"},
+		},
+		{
+			name:   "invalid yaml document",
+			format: "yaml",
+			input:  "name: a\n---\nunknown_field: true\n",
+			err:    "failed to parse config in document 1",
+		},
+		{
+			name:   "invalid json object",
+			format: "json",
+			input:  `[{"name": "a"}, {"unknown_field": true}]`,
+			err:    "failed to parse config at index 1",
+		},
+		{
+			name:   "empty input",
+			format: "json",
+			input:  "",
+			err:    "no configs found",
+		},
+		{
+			name:   "missing name",
+			format: "yaml",
+			input:  "name: a\n---\nwal_truncate_frequency: 1m\n",
+			err:    "config 1 is missing a name",
+		},
+		{
+			name:   "duplicate names",
+			format: "json",
+			input:  `[{"name": "a"}, {"name": "b"}, {"name": "a"}]`,
+			err:    `found multiple configs named "a"`,
+		},
+		{
+			name:   "unknown format",
+			format: "toml",
+			input:  "name = 'a'",
+			err:    `unsupported config format "toml"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgs, err := UnmarshalConfigs(strings.NewReader(tc.input), tc.format)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			names := make([]string, 0, len(cfgs))
+			for _, c := range cfgs {
+				names = append(names, c.Name)
+
+				// Defaults should be applied to every config.
+				require.Equal(t, DefaultConfig.WALTruncateFrequency, c.WALTruncateFrequency)
+			}
+			require.Equal(t, tc.expect, names)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// MigrateStorage moves the storage of the managed instance with the given
+// name to newDir and relaunches the instance with StorageDirectory set to
+// newDir in its config.
+//
+// The instance is stopped first, running its OnBeforeStop hook and flushing
+// its remote writes, so nothing is written to the storage while it's moved.
+// newDir must not exist yet and must be on the same filesystem as the current
+// storage. If the instance can't be relaunched, its storage is moved back and
+// it's relaunched with its previous config.
+//
+// MigrateStorage fails without stopping the instance if StorageDirFunc
+// doesn't honor StorageDirectory. Returns ErrConfigNotFound if there is no
+// instance with the given name.
+func (m *BasicManager) MigrateStorage(name, newDir string) error {
+	newDir = filepath.Clean(newDir)
+	if _, err := os.Stat(newDir); err == nil {
+		return wrapError(name, CodeValidation, fmt.Errorf("cannot migrate storage of instance %s: %s already exists", name, newDir))
+	} else if !os.IsNotExist(err) {
+		return wrapError(name, CodeValidation, fmt.Errorf("cannot migrate storage of instance %s: %w", name, err))
+	}
+
+	defer m.lockName(name)()
+
+	m.mut.Lock()
+	if m.state != ManagerStateRunning {
+		m.mut.Unlock()
+		return wrapError(name, CodeStopped, ErrManagerStopped)
+	}
+	proc, ok := m.processes[name]
+	if !ok {
+		m.mut.Unlock()
+		return wrapError(name, CodeNotFound, ErrConfigNotFound)
+	}
+	cfg := proc.cfg
+	m.mut.Unlock()
+
+	newCfg := cfg
+	newCfg.StorageDirectory = newDir
+
+	oldDir := filepath.Clean(m.StorageDir(cfg))
+	if dir := filepath.Clean(m.StorageDir(newCfg)); dir != newDir {
+		return wrapError(name, CodeValidation, fmt.Errorf("cannot migrate storage of instance %s: StorageDirFunc puts it in %s instead of %s", name, dir, newDir))
+	}
+
+	// Like a forced update, the new process emits EventRestarted in place of
+	// the EventStopped of the old one.
+	proc.setReplaced()
+	m.stopProcess(proc, cfg)
+
+	moved, err := moveStorage(oldDir, newDir)
+	if err == nil {
+		err = m.respawn(newCfg)
+		if err == nil {
+			level.Info(m.logger).Log("msg", "migrated instance storage", "instance", name, "from", oldDir, "to", newDir)
+			return nil
+		}
+	}
+
+	if moved {
+		if rollbackErr := os.Rename(newDir, oldDir); rollbackErr != nil {
+			m.emitStopped(name)
+			return wrapError(name, CodeLaunchFailed, fmt.Errorf("failed to migrate storage of instance %s: %w (moving the storage back also failed, instance not relaunched: %s)", name, err, rollbackErr))
+		}
+	}
+	if rollbackErr := m.respawn(cfg); rollbackErr != nil {
+		m.emitStopped(name)
+		return wrapError(name, CodeLaunchFailed, fmt.Errorf("failed to migrate storage of instance %s: %w (relaunching it with its previous config also failed: %s)", name, err, rollbackErr))
+	}
+	return wrapError(name, CodeLaunchFailed, fmt.Errorf("failed to migrate storage of instance %s: %w", name, err))
+}
+
+// moveStorage renames oldDir to newDir, creating the parents of newDir.
+// moved is false if oldDir didn't exist, which happens when the instance
+// never wrote anything.
+func moveStorage(oldDir, newDir string) (moved bool, err error) {
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(newDir), 0750); err != nil {
+		return false, fmt.Errorf("failed to create parent of %s: %w", newDir, err)
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return false, fmt.Errorf("failed to move storage: %w", err)
+	}
+	return true, nil
+}
+
+// respawn launches a process for c after its previous process stopped.
+// The lock for the name of c must be held when calling respawn.
+func (m *BasicManager) respawn(c Config) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.state != ManagerStateRunning {
+		return ErrManagerStopped
+	}
+	if err := m.spawnProcess(context.Background(), c, RestartCauseStorageMigrated); err != nil {
+		return err
+	}
+	currentActiveInstances.Inc()
+	return nil
+}
+
+// emitStopped emits the EventStopped and clears the silence a replaced process
+// left to a successor which failed to launch.
+func (m *BasicManager) emitStopped(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.emit(EventStopped, name, "")
+	m.clearSilenceLocked(name)
+}
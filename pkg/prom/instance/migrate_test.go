@@ -0,0 +1,96 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicManager_MigrateStorage(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "migrate_storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		oldDir = filepath.Join(dir, "test")
+		newDir = filepath.Join(dir, "migrated", "test")
+	)
+	require.NoError(t, os.MkdirAll(oldDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(oldDir, "segment"), []byte("data"), 0600))
+
+	spawner := func(c Config) (ManagedInstance, error) {
+		if c.StorageDirectory == filepath.Join(dir, "unlaunchable") {
+			return nil, fmt.Errorf("can't launch instance")
+		}
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageDirectory = dir
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	require.NoError(t, cm.MigrateStorage("test", newDir))
+	requireEvent(t, events, EventRestarted, RestartCauseStorageMigrated)
+
+	require.Equal(t, newDir, cm.ListConfigs()["test"].StorageDirectory)
+	require.Equal(t, newDir, cm.StorageDir(cm.ListConfigs()["test"]))
+	require.NoFileExists(t, filepath.Join(oldDir, "segment"))
+	require.FileExists(t, filepath.Join(newDir, "segment"))
+
+	// Failing to relaunch the instance moves the storage back.
+	err = cm.MigrateStorage("test", filepath.Join(dir, "unlaunchable"))
+	require.EqualError(t, err, "failed to migrate storage of instance test: can't launch instance")
+	requireEvent(t, events, EventRestarted, RestartCauseStorageMigrated)
+
+	require.Equal(t, newDir, cm.ListConfigs()["test"].StorageDirectory)
+	require.FileExists(t, filepath.Join(newDir, "segment"))
+
+	// Existing directories aren't overwritten.
+	require.Error(t, cm.MigrateStorage("test", dir))
+	require.ErrorIs(t, cm.MigrateStorage("missing", filepath.Join(dir, "missing")), ErrConfigNotFound)
+}
+
+func TestBasicManager_MigrateStorage_StorageDirFunc(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StorageDirFunc = func(c Config) string {
+		return filepath.Join("/wal", c.Name)
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+
+	// The StorageDirFunc ignores StorageDirectory, so the instance is left
+	// running as is.
+	err := cm.MigrateStorage("test", "/does-not-exist/test")
+	require.EqualError(t, err, "cannot migrate storage of instance test: StorageDirFunc puts it in /wal/test instead of /does-not-exist/test")
+	require.Empty(t, cm.ListConfigs()["test"].StorageDirectory)
+}
@@ -119,9 +119,15 @@ func (m *ModalManager) SetMode(newMode Mode) error {
 	}
 	m.mode = newMode
 
-	// Remove all configs from the previous active Manager.
+	// Remove all configs from the previous active Manager. It can't be stopped
+	// since the wrapped Manager is shared between modes and can't be reused
+	// once stopped.
 	if prevActive != nil {
-		prevActive.Stop()
+		for name := range m.configs {
+			if err := prevActive.DeleteConfig(name); err != nil {
+				level.Warn(m.log).Log("msg", "failed to remove config from previous mode", "name", name, "prev_mode", prevMode, "err", err)
+			}
+		}
 	}
 
 	// Re-apply configs to the new active Manager.
@@ -153,6 +159,13 @@ func (m *ModalManager) ListConfigs() map[string]Config {
 	return m.active.ListConfigs()
 }
 
+// InstanceStatuses implements Manager.
+func (m *ModalManager) InstanceStatuses() map[string]InstanceStatus {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.active.InstanceStatuses()
+}
+
 // ApplyConfig implements Manager.
 func (m *ModalManager) ApplyConfig(c Config) error {
 	m.mut.Lock()
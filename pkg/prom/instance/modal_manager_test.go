@@ -0,0 +1,41 @@
+package instance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModalManager_SetMode(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	bm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	mm, err := NewModalManager(prometheus.NewRegistry(), log.NewNopLogger(), bm, ModeDistinct)
+	require.NoError(t, err)
+	defer mm.Stop()
+
+	require.NoError(t, mm.ApplyConfig(Config{Name: "a"}))
+	require.NoError(t, mm.ApplyConfig(Config{Name: "b"}))
+
+	// Changing modes must not stop the wrapped manager, which is reused by
+	// the new mode.
+	for _, mode := range []Mode{ModeShared, ModeDistinct} {
+		require.NoError(t, mm.SetMode(mode))
+		require.Equal(t, ManagerStateRunning, bm.State())
+
+		configs := mm.ListConfigs()
+		require.Contains(t, configs, "a")
+		require.Contains(t, configs, "b")
+	}
+}
@@ -0,0 +1,57 @@
+package instance
+
+import "sync"
+
+// nameLocks holds a mutex for each instance name. Mutexes are created on
+// demand and dropped once no goroutine holds or waits for them. The zero
+// value is ready to use.
+type nameLocks struct {
+	mut   sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	mut  sync.Mutex
+	refs int
+}
+
+// lock acquires the mutex for name, blocking until it's available. The
+// returned function releases it.
+func (l *nameLocks) lock(name string) (unlock func()) {
+	l.mut.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*nameLock)
+	}
+	nl, ok := l.locks[name]
+	if !ok {
+		nl = &nameLock{}
+		l.locks[name] = nl
+	}
+	nl.refs++
+	l.mut.Unlock()
+
+	nl.mut.Lock()
+	return func() {
+		nl.mut.Unlock()
+
+		l.mut.Lock()
+		defer l.mut.Unlock()
+		nl.refs--
+		if nl.refs == 0 {
+			delete(l.locks, name)
+		}
+	}
+}
+
+// lockName serializes changes to the named instance. It holds applyMut for
+// reading, so changes to other instances proceed in parallel while
+// operations spanning all instances are excluded, and the lock for name. The
+// returned function releases both.
+func (m *BasicManager) lockName(name string) (unlock func()) {
+	m.applyMut.RLock()
+	unlockName := m.nameLocks.lock(name)
+	return func() {
+		unlockName()
+		m.applyMut.RUnlock()
+	}
+}
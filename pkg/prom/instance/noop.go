@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"time"
 
 	"github.com/prometheus/prometheus/scrape"
 )
@@ -30,3 +31,13 @@ func (NoOpInstance) TargetsActive() map[string][]*scrape.Target {
 func (NoOpInstance) StorageDirectory() string {
 	return ""
 }
+
+// StorageSize implements Instance.
+func (NoOpInstance) StorageSize() (int64, error) {
+	return 0, nil
+}
+
+// LastScrapeTime implements Instance.
+func (NoOpInstance) LastScrapeTime() time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,205 @@
+package instance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// preparedConfig is an instance constructed by PrepareConfig which is waiting
+// to be committed.
+type preparedConfig struct {
+	cfg    Config
+	inst   ManagedInstance
+	expire func() bool // Stops the expiry timer; false if it already fired
+}
+
+// PrepareConfig constructs an instance for c through the Factory of the
+// BasicManager without running it, and stages it until it's committed by
+// passing the returned token to CommitConfig. This allows a coordinator to
+// make sure a config can be applied on every agent before it's applied on any
+// of them.
+//
+// Prepared configs which aren't committed within PreparedConfigTTL are
+// discarded. Constructed instances implementing io.Closer are closed when
+// discarded. Preparing a config doesn't affect the instances being managed,
+// including one with the same name.
+func (m *BasicManager) PrepareConfig(c Config) (string, error) {
+	if c.Name == "" {
+		return "", wrapError("", CodeValidation, errors.New("missing instance name"))
+	}
+
+	m.mut.Lock()
+	if m.state != ManagerStateRunning {
+		m.mut.Unlock()
+		return "", wrapError(c.Name, CodeStopped, ErrManagerStopped)
+	}
+	launch := m.launch
+	m.mut.Unlock()
+
+	inst, err := m.launchInstance(launch, c)
+	if errors.Is(err, ErrCircuitOpen) {
+		return "", wrapError(c.Name, CodeLaunchFailed, err)
+	} else if err != nil {
+		return "", wrapError(c.Name, CodeLaunchFailed, fmt.Errorf("failed to construct instance %s: %w", c.Name, err))
+	}
+
+	token, err := newPrepareToken()
+	if err != nil {
+		closeInstance(inst)
+		return "", wrapError(c.Name, CodeLaunchFailed, err)
+	}
+
+	ttl := m.ManagerConfig().PreparedConfigTTL
+	if ttl == 0 {
+		ttl = DefaultBasicManagerConfig.PreparedConfigTTL
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.state != ManagerStateRunning {
+		closeInstance(inst)
+		return "", wrapError(c.Name, CodeStopped, ErrManagerStopped)
+	}
+
+	timer := time.AfterFunc(ttl, func() {
+		if p, ok := m.takePrepared(token); ok {
+			level.Info(m.logger).Log("msg", "discarding prepared config which wasn't committed in time", "instance", p.cfg.Name, "ttl", ttl)
+			closeInstance(p.inst)
+		}
+	})
+	m.prepared[token] = &preparedConfig{cfg: c, inst: inst, expire: timer.Stop}
+	return token, nil
+}
+
+// CommitConfig starts running the instance prepared by the PrepareConfig call
+// which returned token. An existing instance with the same name is stopped
+// and replaced by the prepared one. OnConfigApplied is invoked as if the
+// config was applied with ApplyConfig.
+//
+// Returns ErrPreparedConfigNotFound if token is unknown, already committed or
+// expired.
+func (m *BasicManager) CommitConfig(token string) error {
+	p, ok := m.takePrepared(token)
+	if !ok {
+		return wrapError("", CodeNotFound, ErrPreparedConfigNotFound)
+	}
+	defer m.lockName(p.cfg.Name)()
+
+	var prev *Config
+	m.mut.Lock()
+	if proc, ok := m.processes[p.cfg.Name]; ok {
+		cfg := proc.cfg
+		prev = &cfg
+	}
+	m.mut.Unlock()
+
+	result, err := m.commitConfig(p)
+	outcome := applyOutcome(prev, p.cfg, result, err)
+	applyOutcomes.WithLabelValues(outcome).Inc()
+	if err != nil {
+		closeInstance(p.inst)
+		return wrapError(p.cfg.Name, CodeLaunchFailed, err)
+	}
+	if outcome == applyOutcomeRestartUpdate {
+		m.setPrevious(*prev)
+	}
+	m.hooksMut.Lock()
+	defer m.hooksMut.Unlock()
+	if onApplied := m.ManagerConfig().OnConfigApplied; onApplied != nil {
+		onApplied(p.cfg, result)
+	}
+	return nil
+}
+
+// commitConfig implements CommitConfig. The lock for the name of the prepared
+// config must be held when calling commitConfig.
+func (m *BasicManager) commitConfig(p *preparedConfig) (ApplyConfigResult, error) {
+	if err := m.checkStorage(); err != nil {
+		return "", err
+	}
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.state != ManagerStateRunning {
+		return "", ErrManagerStopped
+	}
+
+	var (
+		name   = p.cfg.Name
+		cause  RestartCause
+		result = ApplyConfigCreated
+	)
+
+	proc, exists := m.processes[name]
+	if exists {
+		level.Info(proc.logger).Log("msg", "replacing instance with committed config", "instance", name)
+		cause, result = RestartCauseForcedByUpdate, ApplyConfigRestarted
+
+		// As in applyConfig, mut is released while the old process stops.
+		proc.setReplaced()
+		cfg := proc.cfg
+		m.mut.Unlock()
+		m.stopProcess(proc, cfg)
+		m.mut.Lock()
+	} else if max := m.ManagerConfig().MaxInstances; max > 0 && len(m.processes) >= max {
+		instanceLimitRejections.Inc()
+		return "", ErrInstanceLimitReached
+	}
+
+	m.startProcess(context.Background(), p.cfg, p.inst, cause)
+	currentActiveInstances.Inc()
+	return result, nil
+}
+
+// takePrepared removes the prepared config with the given token, stopping its
+// expiry. Returns false if there is no such prepared config.
+func (m *BasicManager) takePrepared(token string) (*preparedConfig, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	p, ok := m.prepared[token]
+	if !ok {
+		return nil, false
+	}
+	delete(m.prepared, token)
+	p.expire()
+	return p, true
+}
+
+// discardPrepared discards all prepared configs.
+func (m *BasicManager) discardPrepared() {
+	m.mut.Lock()
+	prepared := m.prepared
+	m.prepared = make(map[string]*preparedConfig)
+	m.mut.Unlock()
+
+	for _, p := range prepared {
+		p.expire()
+		closeInstance(p.inst)
+	}
+}
+
+// newPrepareToken returns a random token identifying a prepared config.
+func newPrepareToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// closeInstance closes inst if it implements io.Closer.
+func closeInstance(inst ManagedInstance) {
+	if closer, ok := inst.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_PrepareConfig(t *testing.T) {
+	var (
+		launched = atomic.NewInt64(0)
+		runs     = atomic.NewInt64(0)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		if c.HostFilter {
+			return nil, fmt.Errorf("invalid config")
+		}
+		launched.Inc()
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	var applied []ApplyConfigResult
+
+	cfg := DefaultBasicManagerConfig
+	cfg.OnConfigApplied = func(c Config, result ApplyConfigResult) {
+		applied = append(applied, result)
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	_, err := cm.PrepareConfig(Config{Name: "test", HostFilter: true})
+	require.EqualError(t, err, "failed to construct instance test: invalid config")
+
+	// Prepared instances are constructed but not run until committed.
+	token, err := cm.PrepareConfig(Config{Name: "test"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), launched.Load())
+	require.Empty(t, cm.ListConfigs())
+
+	require.NoError(t, cm.CommitConfig(token))
+	require.Contains(t, cm.ListConfigs(), "test")
+	require.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, cm.CommitConfig(token), ErrPreparedConfigNotFound)
+
+	// Committing replaces the existing instance.
+	token, err = cm.PrepareConfig(Config{Name: "test"})
+	require.NoError(t, err)
+	require.NoError(t, cm.CommitConfig(token))
+	require.Eventually(t, func() bool { return runs.Load() == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(2), launched.Load())
+
+	require.Equal(t, []ApplyConfigResult{ApplyConfigCreated, ApplyConfigRestarted}, applied)
+}
+
+func TestBasicManager_PrepareConfig_TTL(t *testing.T) {
+	closed := atomic.NewInt32(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return closingInstance{mockInstance: &mockInstance{}, closed: closed}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.PreparedConfigTTL = 10 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	token, err := cm.PrepareConfig(Config{Name: "test"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return closed.Load() == 1 }, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, cm.CommitConfig(token), ErrPreparedConfigNotFound)
+	require.Empty(t, cm.ListConfigs())
+}
+
+func TestBasicManager_PrepareConfig_Stop(t *testing.T) {
+	closed := atomic.NewInt32(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return closingInstance{mockInstance: &mockInstance{}, closed: closed}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+
+	token, err := cm.PrepareConfig(Config{Name: "test"})
+	require.NoError(t, err)
+
+	// Stopping the manager discards prepared configs.
+	cm.Stop()
+	require.Equal(t, int32(1), closed.Load())
+	require.ErrorIs(t, cm.CommitConfig(token), ErrPreparedConfigNotFound)
+}
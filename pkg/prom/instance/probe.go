@@ -0,0 +1,115 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// DefaultStartupProbeConfig holds the default settings for a
+// StartupProbeConfig.
+var DefaultStartupProbeConfig = StartupProbeConfig{
+	Network:     "tcp",
+	Interval:    time.Second,
+	MaxInterval: 30 * time.Second,
+	Timeout:     time.Second,
+}
+
+// StartupProbeConfig configures a dependency which must be reachable before
+// an instance starts running. The probe succeeds once a connection to
+// Address can be established.
+type StartupProbeConfig struct {
+	// Network to connect over, either "tcp" or "unix".
+	Network string `yaml:"network,omitempty"`
+
+	// Address to connect to. For the unix network, this is the path to a
+	// socket.
+	Address string `yaml:"address,omitempty"`
+
+	// Interval is the initial time between probes. The interval doubles after
+	// each failed probe, up to MaxInterval.
+	Interval    time.Duration `yaml:"interval,omitempty"`
+	MaxInterval time.Duration `yaml:"max_interval,omitempty"`
+
+	// Timeout for a single probe.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *StartupProbeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultStartupProbeConfig
+
+	type plain StartupProbeConfig
+	return unmarshal((*plain)(c))
+}
+
+// Validate ensures that the StartupProbeConfig is valid.
+func (c *StartupProbeConfig) Validate() error {
+	switch {
+	case c.Network != "tcp" && c.Network != "unix":
+		return fmt.Errorf("unsupported startup probe network %q, expected tcp or unix", c.Network)
+	case c.Address == "":
+		return errors.New("startup probe address must be set")
+	case c.Interval <= 0:
+		return errors.New("startup probe interval must be greater than 0s")
+	case c.MaxInterval < c.Interval:
+		return errors.New("startup probe max_interval must not be less than interval")
+	case c.Timeout <= 0:
+		return errors.New("startup probe timeout must be greater than 0s")
+	}
+	return nil
+}
+
+// probe connects to the probed address once.
+func (c *StartupProbeConfig) probe(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// waitForStartupProbe polls probe until it succeeds, keeping proc in
+// InstanceStateWaitingForDependency in the meantime. Returns false if ctx was
+// canceled before the probe succeeded.
+//
+// waitForStartupProbe runs in the goroutine of the process, so it must not
+// take the lock of the name of the instance (see lockName): ApplyConfig holds
+// it while waiting for a replaced process to exit, though it releases mut
+// while stopping the process.
+func (m *BasicManager) waitForStartupProbe(ctx context.Context, name string, proc *managedProcess, probe *StartupProbeConfig) bool {
+	if probe == nil {
+		return true
+	}
+
+	proc.setState(InstanceStateWaitingForDependency)
+	defer proc.setState(InstanceStateRunning)
+
+	interval := probe.Interval
+	for {
+		err := probe.probe(ctx)
+		if err == nil {
+			level.Info(proc.logger).Log("msg", "startup probe succeeded, starting instance", "instance", name, "address", probe.Address)
+			return true
+		}
+		level.Debug(proc.logger).Log("msg", "startup probe failed, waiting before probing again", "instance", name, "address", probe.Address, "err", err, "interval", interval)
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if interval > probe.MaxInterval {
+			interval = probe.MaxInterval
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package instance
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+)
+
+func TestStartupProbeConfig_UnmarshalYAML(t *testing.T) {
+	var c StartupProbeConfig
+	require.NoError(t, yaml.UnmarshalStrict([]byte(`address: 127.0.0.1:4317`), &c))
+
+	expect := DefaultStartupProbeConfig
+	expect.Address = "127.0.0.1:4317"
+	require.Equal(t, expect, c)
+	require.NoError(t, c.Validate())
+
+	c.Network = "udp"
+	require.EqualError(t, c.Validate(), `unsupported startup probe network "udp", expected tcp or unix`)
+}
+
+func TestBasicManager_StartupProbe(t *testing.T) {
+	// Reserve an address and free it up again so nothing is listening on it.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				<-ctx.Done()
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	probe := DefaultStartupProbeConfig
+	probe.Address = addr
+	probe.Interval = 10 * time.Millisecond
+	probe.MaxInterval = 20 * time.Millisecond
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", StartupProbe: &probe}))
+
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateWaitingForDependency
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int64(0), runs.Load(), "instance should not run before the probe succeeds")
+
+	lis, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer lis.Close()
+
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return runs.Load() == 1 && state == InstanceStateRunning
+	}, time.Second, 10*time.Millisecond)
+}
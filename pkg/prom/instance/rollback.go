@@ -0,0 +1,45 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rollback re-applies the config the named instance had before ApplyConfig or
+// CommitConfig last changed it, as a quick way to undo a config which broke
+// the instance. Only one previous config is kept per instance. It's forgotten
+// once rolled back to, so calling Rollback again fails until the config is
+// changed again, and when the config is deleted.
+//
+// The previous config is applied the same way as with ApplyConfig. Returns
+// ErrNoPreviousConfig if there is no previous config for name.
+func (m *BasicManager) Rollback(name string) error {
+	defer m.lockName(name)()
+
+	m.mut.Lock()
+	prev, ok := m.previous[name]
+	m.mut.Unlock()
+	if !ok {
+		return wrapError(name, CodeNotFound, ErrNoPreviousConfig)
+	}
+
+	if err := m.applyAndRecord(context.Background(), prev); err != nil {
+		return wrapError(name, CodeLaunchFailed, fmt.Errorf("failed to roll back instance %s: %w", name, err))
+	}
+	m.forgetPrevious(name)
+	return nil
+}
+
+// setPrevious keeps c as the previous config of its instance.
+func (m *BasicManager) setPrevious(c Config) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.previous[c.Name] = c
+}
+
+// forgetPrevious forgets the previous config of the named instance.
+func (m *BasicManager) forgetPrevious(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.previous, name)
+}
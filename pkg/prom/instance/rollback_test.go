@@ -0,0 +1,54 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicManager_Rollback(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error {
+				if c.WriteStaleOnShutdown {
+					return ErrInvalidUpdate{Inner: fmt.Errorf("can't update")}
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.ErrorIs(t, cm.Rollback("test"), ErrNoPreviousConfig)
+
+	// Applying an unchanged config keeps no history.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.ErrorIs(t, cm.Rollback("test"), ErrNoPreviousConfig)
+
+	// Dynamic updates can be rolled back.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", HostFilter: true}))
+	require.NoError(t, cm.Rollback("test"))
+	require.False(t, cm.ListConfigs()["test"].HostFilter)
+	require.ErrorIs(t, cm.Rollback("test"), ErrNoPreviousConfig)
+
+	// Only the last change is kept, including restarts.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", HostFilter: true}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", HostFilter: true, WriteStaleOnShutdown: true}))
+	require.NoError(t, cm.Rollback("test"))
+	require.Equal(t, Config{Name: "test", HostFilter: true}, cm.ListConfigs()["test"])
+
+	// Deleting a config forgets its history.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.NoError(t, cm.DeleteConfig("test"))
+	require.ErrorIs(t, cm.Rollback("test"), ErrNoPreviousConfig)
+}
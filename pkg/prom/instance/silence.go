@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// silence mutes the abnormal exits of an instance until a deadline.
+type silence struct {
+	until time.Time
+	label string // instance_name label of agent_prometheus_instance_silenced
+	timer *time.Timer
+}
+
+// Silence mutes the alerting signals about abnormal exits of the named
+// instance until the given time, such as during the maintenance of a
+// dependency it's known to crash without. While silenced, the
+// agent_prometheus_instance_silenced metric of the instance is set to 1 and
+// OnAbnormalExit isn't invoked for it. The instance keeps being restarted as
+// usual.
+//
+// Silencing an instance again replaces its silence, and a time in the past
+// clears it. The silence outlives restarts of the instance but is cleared
+// once the instance is gone, such as when its config is deleted, it's
+// stopped with StopGroup or Handoff, or it exits with NoRestart set.
+// Returns ErrConfigNotFound if there is no instance with the given name.
+func (m *BasicManager) Silence(name string, until time.Time) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	proc, ok := m.processes[name]
+	if !ok {
+		return wrapError(name, CodeNotFound, ErrConfigNotFound)
+	}
+	m.clearSilenceLocked(name)
+
+	d := time.Until(until)
+	if d <= 0 {
+		return nil
+	}
+
+	s := &silence{until: until, label: proc.metricLabel}
+	s.timer = time.AfterFunc(d, func() {
+		m.mut.Lock()
+		defer m.mut.Unlock()
+
+		// The silence may have been replaced while the timer fired.
+		if m.silences[name] == s {
+			m.clearSilenceLocked(name)
+		}
+	})
+	m.silences[name] = s
+	instanceSilenced.WithLabelValues(s.label).Set(1)
+	return nil
+}
+
+// Silenced returns the time until which the named instance is silenced.
+// Returns false if it isn't silenced.
+func (m *BasicManager) Silenced(name string) (time.Time, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	s, ok := m.silences[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return s.until, true
+}
+
+// clearSilence clears the silence of the named instance, if any.
+func (m *BasicManager) clearSilence(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.clearSilenceLocked(name)
+}
+
+// clearSilenceLocked implements clearSilence. mut must be held when calling
+// clearSilenceLocked.
+func (m *BasicManager) clearSilenceLocked(name string) {
+	s, ok := m.silences[name]
+	if !ok {
+		return
+	}
+	s.timer.Stop()
+	delete(m.silences, name)
+	instanceSilenced.DeleteLabelValues(s.label)
+}
+
+// abnormalExit invokes OnAbnormalExit for an abnormal exit of the named
+// instance, unless the instance is silenced.
+func (m *BasicManager) abnormalExit(name string, err error) {
+	hook := m.ManagerConfig().OnAbnormalExit
+	if hook == nil {
+		return
+	}
+
+	if until, silenced := m.Silenced(name); silenced {
+		level.Debug(m.logger).Log("msg", "not reporting abnormal exit of silenced instance", "instance", name, "until", until)
+		return
+	}
+	hook(name, err)
+}
@@ -0,0 +1,101 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_Silence(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	var (
+		reported = atomic.NewInt64(0)
+		cfg      = DefaultBasicManagerConfig
+	)
+	cfg.InstanceRestartBackoff = 10 * time.Millisecond
+	cfg.OnAbnormalExit = func(name string, err error) {
+		require.Equal(t, "silenced", name)
+		require.EqualError(t, err, "failed to run")
+		reported.Inc()
+	}
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.ErrorIs(t, cm.Silence("silenced", time.Now().Add(time.Hour)), ErrConfigNotFound)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "silenced"}))
+	require.Eventually(t, func() bool { return reported.Load() > 0 }, time.Second, 10*time.Millisecond)
+
+	silencedValue := func() float64 {
+		var m dto.Metric
+		require.NoError(t, instanceSilenced.WithLabelValues("silenced").Write(&m))
+		return m.GetGauge().GetValue()
+	}
+
+	// The instance keeps restarting while silenced, but exits aren't reported.
+	require.NoError(t, cm.Silence("silenced", time.Now().Add(300*time.Millisecond)))
+	require.Equal(t, float64(1), silencedValue())
+	before, beforeRuns := reported.Load(), runs.Load()
+	require.Eventually(t, func() bool { return runs.Load() > beforeRuns+2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, before, reported.Load())
+
+	// The silence clears itself once it ends.
+	require.Eventually(t, func() bool {
+		_, silenced := cm.Silenced("silenced")
+		return !silenced && reported.Load() > before
+	}, time.Second, 10*time.Millisecond)
+	require.Zero(t, silencedValue())
+
+	// Silences are cleared by deleting the config.
+	require.NoError(t, cm.Silence("silenced", time.Now().Add(time.Hour)))
+	require.NoError(t, cm.DeleteConfig("silenced"))
+	_, silenced := cm.Silenced("silenced")
+	require.False(t, silenced)
+}
+
+func TestBasicManager_Silence_InstanceGone(t *testing.T) {
+	exit := make(chan struct{})
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-exit:
+					return fmt.Errorf("failed to run")
+				}
+			},
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// Silences are cleared when the instance exits for good, so later
+	// instances with the same name don't inherit them.
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", NoRestart: true}))
+	require.NoError(t, cm.Silence("test", time.Now().Add(time.Hour)))
+	close(exit)
+	require.Eventually(t, func() bool {
+		_, ok := cm.Instance("test")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	_, silenced := cm.Silenced("test")
+	require.False(t, silenced)
+}
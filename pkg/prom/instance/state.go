@@ -0,0 +1,130 @@
+package instance
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// managerStateJSON is the structure serialized by StateJSON.
+type managerStateJSON struct {
+	State     ManagerState        `json:"state"`
+	Instances []instanceStateJSON `json:"instances"`
+}
+
+// instanceStateJSON describes a single instance in StateJSON.
+type instanceStateJSON struct {
+	Name             string        `json:"name"`
+	Config           string        `json:"config"`
+	State            InstanceState `json:"state"`
+	RestartStreak    int           `json:"restart_streak"`
+	LastError        string        `json:"last_error,omitempty"`
+	BackoffRemaining string        `json:"backoff_remaining,omitempty"`
+	StorageDirectory string        `json:"storage_directory"`
+}
+
+// StateJSON serializes the state of the BasicManager and all of its instances
+// as JSON for debugging. Instances are sorted by name. Their configs are
+// included as YAML with secrets scrubbed. The last error is the error of the
+// last abnormal exit, and the backoff remaining is only set while the
+// instance is waiting to be restarted.
+//
+// The states of all instances are read at the same time, so the result is
+// consistent even while instances are being restarted.
+func (m *BasicManager) StateJSON() ([]byte, error) {
+	var (
+		res  managerStateJSON
+		cfgs = map[string]Config{}
+	)
+
+	m.mut.Lock()
+	res.State = m.state
+	res.Instances = make([]instanceStateJSON, 0, len(m.processes))
+	for name, proc := range m.processes {
+		cfgs[name] = proc.cfg
+		res.Instances = append(res.Instances, proc.stateJSON(name))
+	}
+	m.mut.Unlock()
+
+	sort.Slice(res.Instances, func(i, j int) bool {
+		return res.Instances[i].Name < res.Instances[j].Name
+	})
+	for i, inst := range res.Instances {
+		cfg := cfgs[inst.Name]
+		bb, err := MarshalConfig(&cfg, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal config of instance %s: %w", inst.Name, err)
+		}
+		res.Instances[i].Config = string(bb)
+	}
+
+	return json.MarshalIndent(res, "", "  ")
+}
+
+// stateJSON returns the runtime state of the process for StateJSON. The
+// config is left for the caller to fill in.
+func (p *managedProcess) stateJSON(name string) instanceStateJSON {
+	res := instanceStateJSON{
+		Name:             name,
+		StorageDirectory: p.inst.StorageDirectory(),
+	}
+
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+
+	res.State = p.state
+	res.RestartStreak = p.streak
+	if p.lastErr != nil {
+		res.LastError = p.lastErr.Error()
+	}
+	if remaining, ok := p.backoffRemainingLocked(); ok {
+		res.BackoffRemaining = remaining.String()
+	}
+	return res
+}
+
+// InstanceSummary summarizes an instance for InstanceOverview.
+type InstanceSummary struct {
+	Name          string
+	State         InstanceState
+	RestartStreak int
+
+	// ActiveTargets is the number of targets the instance is scraping,
+	// across all of its jobs. It's -1 when target counts weren't requested.
+	ActiveTargets int
+}
+
+// InstanceOverview returns a summary of every instance, sorted by name.
+// Counting the active targets of each instance can be expensive, so it's
+// skipped unless withTargets is set.
+//
+// Like StateJSON, the summaries are taken at the same time so they're
+// consistent with each other.
+func (m *BasicManager) InstanceOverview(withTargets bool) []InstanceSummary {
+	m.mut.Lock()
+	res := make([]InstanceSummary, 0, len(m.processes))
+	for name, proc := range m.processes {
+		res = append(res, proc.summary(name, withTargets))
+	}
+	m.mut.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// summary returns the summary of the process for InstanceOverview.
+func (p *managedProcess) summary(name string, withTargets bool) InstanceSummary {
+	res := InstanceSummary{Name: name, ActiveTargets: -1}
+	if withTargets {
+		res.ActiveTargets = countTargets(p.inst)
+	}
+
+	p.stateMut.Lock()
+	defer p.stateMut.Unlock()
+
+	res.State = p.state
+	res.RestartStreak = p.streak
+	return res
+}
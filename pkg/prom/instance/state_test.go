@@ -0,0 +1,107 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasicManager_StateJSON(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if c.Name == "failing" {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			StorageDirectoryFunc: func() string { return "/wal/" + c.Name },
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "running"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "failing"}))
+	require.Eventually(t, func() bool {
+		_, ok := cm.BackoffRemaining("failing")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	bb, err := cm.StateJSON()
+	require.NoError(t, err)
+
+	var state managerStateJSON
+	require.NoError(t, json.Unmarshal(bb, &state))
+	require.Equal(t, ManagerStateRunning, state.State)
+	require.Len(t, state.Instances, 2)
+
+	failing, running := state.Instances[0], state.Instances[1]
+	require.Equal(t, "failing", failing.Name)
+	require.Equal(t, InstanceStateBackingOff, failing.State)
+	require.Equal(t, 1, failing.RestartStreak)
+	require.Equal(t, "failed to run", failing.LastError)
+	require.NotEmpty(t, failing.BackoffRemaining)
+	require.Equal(t, "/wal/failing", failing.StorageDirectory)
+
+	require.Equal(t, "running", running.Name)
+	require.Equal(t, InstanceStateRunning, running.State)
+	require.Zero(t, running.RestartStreak)
+	require.Empty(t, running.LastError)
+	require.Empty(t, running.BackoffRemaining)
+	require.Contains(t, running.Config, "name: running")
+}
+
+func TestBasicManager_InstanceOverview(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if c.Name == "failing" {
+					return fmt.Errorf("failed to run")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			TargetsActiveFunc: func() map[string][]*scrape.Target {
+				return map[string][]*scrape.Target{
+					"a": {&scrape.Target{}, &scrape.Target{}},
+					"b": {&scrape.Target{}},
+				}
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "running"}))
+	require.NoError(t, cm.ApplyConfig(Config{Name: "failing"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("failing")
+		return state == InstanceStateBackingOff
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, []InstanceSummary{
+		{Name: "failing", State: InstanceStateBackingOff, RestartStreak: 1, ActiveTargets: 3},
+		{Name: "running", State: InstanceStateRunning, ActiveTargets: 3},
+	}, cm.InstanceOverview(true))
+
+	require.Equal(t, []InstanceSummary{
+		{Name: "failing", State: InstanceStateBackingOff, RestartStreak: 1, ActiveTargets: -1},
+		{Name: "running", State: InstanceStateRunning, ActiveTargets: -1},
+	}, cm.InstanceOverview(false))
+}
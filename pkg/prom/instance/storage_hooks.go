@@ -0,0 +1,50 @@
+package instance
+
+// StorageOp is an operation of an instance on its storage, reported through
+// StorageNotifier.
+type StorageOp string
+
+// Supported values for StorageOp.
+const (
+	// StorageOpened is reported once the storage was opened by Run, before
+	// anything is written to it.
+	StorageOpened StorageOp = "opened"
+
+	// StorageFlushed is reported after old data was flushed out of the
+	// storage, such as when the WAL was truncated and checkpointed.
+	StorageFlushed StorageOp = "flushed"
+
+	// StorageClosed is reported once the storage was closed at the end of
+	// Run. Nothing is written to it until it's opened again.
+	StorageClosed StorageOp = "closed"
+)
+
+// StorageNotifier may optionally be implemented by a ManagedInstance to
+// report operations on its storage. The BasicManager calls SetStorageHook
+// before running the instance, and the instance calls hook right after each
+// operation with the directory of its storage. Until hook returns after
+// StorageFlushed, the instance doesn't flush its storage again.
+type StorageNotifier interface {
+	SetStorageHook(hook func(op StorageOp, dir string))
+}
+
+// storageHook returns the hook given to the StorageNotifier of the named
+// instance, invoking the storage hooks of the BasicManagerConfig.
+func (m *BasicManager) storageHook(name string) func(op StorageOp, dir string) {
+	return func(op StorageOp, dir string) {
+		mcfg := m.ManagerConfig()
+
+		var hook func(name, dir string)
+		switch op {
+		case StorageOpened:
+			hook = mcfg.OnStorageOpen
+		case StorageFlushed:
+			hook = mcfg.OnStorageFlush
+		case StorageClosed:
+			hook = mcfg.OnStorageClose
+		}
+		if hook != nil {
+			hook(name, dir)
+		}
+	}
+}
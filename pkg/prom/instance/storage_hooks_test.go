@@ -0,0 +1,77 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// storageInstance reports opening its storage when run and closing it when
+// stopped.
+type storageInstance struct {
+	*mockInstance
+	dir string
+
+	mut  sync.Mutex
+	hook func(op StorageOp, dir string)
+}
+
+func (i *storageInstance) SetStorageHook(hook func(op StorageOp, dir string)) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.hook = hook
+}
+
+func (i *storageInstance) notify(op StorageOp) {
+	i.mut.Lock()
+	hook := i.hook
+	i.mut.Unlock()
+	hook(op, i.dir)
+}
+
+func TestBasicManager_StorageHooks(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		inst := &storageInstance{dir: "/data/" + c.Name}
+		inst.mockInstance = &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				inst.notify(StorageOpened)
+				inst.notify(StorageFlushed)
+				<-ctx.Done()
+				inst.notify(StorageClosed)
+				return nil
+			},
+		}
+		return inst, nil
+	}
+
+	var (
+		mut    sync.Mutex
+		events []string
+	)
+	record := func(op StorageOp) func(name, dir string) {
+		return func(name, dir string) {
+			mut.Lock()
+			defer mut.Unlock()
+			events = append(events, fmt.Sprintf("%s %s %s", op, name, dir))
+		}
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.OnStorageOpen = record(StorageOpened)
+	cfg.OnStorageFlush = record(StorageFlushed)
+	cfg.OnStorageClose = record(StorageClosed)
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	cm.Stop()
+
+	require.Equal(t, []string{
+		"opened test /data/test",
+		"flushed test /data/test",
+		"closed test /data/test",
+	}, events)
+}
@@ -0,0 +1,142 @@
+package instance
+
+import (
+	"context"
+	"time"
+)
+
+// restartSuspension suspends the restarts of all instances until a deadline.
+type restartSuspension struct {
+	until time.Time
+	done  chan struct{} // Closed when the suspension ends or is replaced
+	timer *time.Timer
+}
+
+// SuspendRestarts stops restarting instances which exit abnormally until the
+// given time, such as during a planned maintenance of a backend all instances
+// are known to fail without. Instead of backing off and retrying, instances
+// exiting abnormally while restarts are suspended wait in
+// InstanceStateSuspended and are restarted once the suspension ends. Their
+// exits don't count towards their streak of abnormal exits. Instances can
+// still be restarted with RestartInstance in the meantime.
+//
+// Suspending restarts again replaces the current suspension, and a time in
+// the past ends it right away.
+func (m *BasicManager) SuspendRestarts(until time.Time) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.endSuspensionLocked()
+
+	d := time.Until(until)
+	if d <= 0 {
+		return
+	}
+
+	s := &restartSuspension{until: until, done: make(chan struct{})}
+	s.timer = time.AfterFunc(d, func() {
+		m.mut.Lock()
+		defer m.mut.Unlock()
+
+		// The suspension may have been replaced while the timer fired.
+		if m.suspension == s {
+			m.endSuspensionLocked()
+		}
+	})
+	m.suspension = s
+}
+
+// RestartsSuspended returns the time until which restarts are suspended.
+// Returns false if they aren't suspended.
+func (m *BasicManager) RestartsSuspended() (time.Time, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.suspension == nil {
+		return time.Time{}, false
+	}
+	return m.suspension.until, true
+}
+
+// endSuspensionLocked ends the current suspension, if any, resuming the
+// instances waiting for it. mut must be held when calling
+// endSuspensionLocked.
+func (m *BasicManager) endSuspensionLocked() {
+	s := m.suspension
+	if s == nil {
+		return
+	}
+	s.timer.Stop()
+	close(s.done)
+	m.suspension = nil
+}
+
+// restartResumed waits for restarts to be resumed after proc exited
+// abnormally with err while they were suspended, following any replacement
+// of the suspension. It returns early if the wait is cut short by
+// requestRestart or resetBackoff. Returns false if ctx was canceled while
+// waiting.
+func (m *BasicManager) restartResumed(ctx context.Context, name string, proc *managedProcess, err error) bool {
+	spanCtx, span := m.startSpan(context.Background(), "Restart", name)
+	span.RecordError(spanCtx, err)
+
+	for {
+		m.mut.Lock()
+		s := m.suspension
+		m.mut.Unlock()
+
+		if s == nil {
+			break
+		}
+
+		woken, ok := proc.suspend(ctx, s)
+		if !ok {
+			setSpanOutcome(spanCtx, spanOutcomeStopped)
+			endSpan(spanCtx, span, nil)
+			return false
+		} else if woken {
+			break
+		}
+	}
+
+	cause := RestartCauseRestartsResumed
+	if proc.takeRestartRequest() {
+		cause = RestartCauseManualRestart
+	}
+	span.SetAttributes(causeKey.String(string(cause)))
+	setSpanOutcome(spanCtx, spanOutcomeRestarted)
+	endSpan(spanCtx, span, nil)
+	m.emit(EventRestarted, name, cause)
+
+	proc.setState(InstanceStateRunning)
+	return true
+}
+
+// suspend puts the process into InstanceStateSuspended until s ends. Returns
+// true for woken if the wait was cut short by requestRestart or resetBackoff
+// and false for ok if ctx was canceled while waiting.
+func (p *managedProcess) suspend(ctx context.Context, s *restartSuspension) (woken, ok bool) {
+	wake := make(chan struct{})
+
+	p.stateMut.Lock()
+	p.setStateLocked(InstanceStateSuspended)
+	p.wake = wake
+	p.wakeAt = s.until
+	p.stateMut.Unlock()
+
+	defer func() {
+		p.stateMut.Lock()
+		p.wake = nil
+		p.wakeAt = time.Time{}
+		p.stateMut.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return false, false
+	case <-s.done:
+		return false, true
+	case <-wake:
+		return true, true
+	}
+}
@@ -0,0 +1,80 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_SuspendRestarts(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				runs.Inc()
+				return fmt.Errorf("failed to run")
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = 10 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	events, unsubscribe := cm.Subscribe()
+	defer unsubscribe()
+
+	// Instances exiting while restarts are suspended aren't restarted.
+	cm.SuspendRestarts(time.Now().Add(time.Hour))
+	_, suspended := cm.RestartsSuspended()
+	require.True(t, suspended)
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateSuspended
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int64(1), runs.Load())
+
+	// Extending the suspension keeps the instance waiting.
+	cm.SuspendRestarts(time.Now().Add(200 * time.Millisecond))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int64(1), runs.Load())
+
+	// The instance resumes once the suspension ends.
+	require.Eventually(t, func() bool {
+		_, suspended := cm.RestartsSuspended()
+		return !suspended && runs.Load() > 2
+	}, time.Second, 10*time.Millisecond)
+
+	var causes []RestartCause
+	for len(causes) < 2 {
+		select {
+		case ev := <-events:
+			if ev.Type == EventRestarted {
+				causes = append(causes, ev.Cause)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for restarts")
+		}
+	}
+	require.Equal(t, []RestartCause{RestartCauseRestartsResumed, RestartCauseAbnormalExit}, causes)
+
+	// Ending the suspension early resumes the waiting instances right away.
+	cm.SuspendRestarts(time.Now().Add(time.Hour))
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateSuspended
+	}, time.Second, 10*time.Millisecond)
+	before := runs.Load()
+	cm.SuspendRestarts(time.Time{})
+	require.Eventually(t, func() bool { return runs.Load() > before }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,56 @@
+package instance
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+)
+
+// Attributes of the spans created for BasicManagerConfig.Tracer.
+const (
+	instanceKey  = label.Key("instance")
+	outcomeKey   = label.Key("outcome")
+	errorCodeKey = label.Key("error_code")
+	causeKey     = label.Key("cause")
+	backoffKey   = label.Key("backoff")
+)
+
+// Outcomes of spans of operations other than applies, whose outcomes are
+// the outcome labels of agent_prometheus_manager_apply_outcomes_total.
+const (
+	spanOutcomeDeleted   = "deleted"
+	spanOutcomeRestarted = "restarted"
+	spanOutcomeStopped   = "stopped"
+)
+
+// startSpan starts a span for the operation op on the named instance with
+// the Tracer of the BasicManagerConfig. The span is a no-op if there is no
+// Tracer.
+func (m *BasicManager) startSpan(ctx context.Context, op, name string, opts ...trace.StartOption) (context.Context, trace.Span) {
+	tracer := m.ManagerConfig().Tracer
+	if tracer == nil {
+		tracer = trace.NoopTracer{}
+	}
+	opts = append(opts, trace.WithAttributes(instanceKey.String(name)))
+	return tracer.Start(ctx, "BasicManager."+op, opts...)
+}
+
+// endSpan ends span, recording err as its error if it's not nil. Spans
+// ended with an error have the failed outcome.
+func endSpan(ctx context.Context, span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(
+			outcomeKey.String(applyOutcomeFailed),
+			errorCodeKey.String(ErrorCodeOf(err).String()),
+		)
+		span.RecordError(ctx, err, trace.WithErrorStatus(codes.Unknown))
+	}
+	span.End()
+}
+
+// setSpanOutcome sets the outcome of the span of ctx, if any.
+func setSpanOutcome(ctx context.Context, outcome string) {
+	trace.SpanFromContext(ctx).SetAttributes(outcomeKey.String(outcome))
+}
@@ -0,0 +1,68 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/api/trace/tracetest"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_Tracer(t *testing.T) {
+	runs := atomic.NewInt64(0)
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				// Crash the first run of the instance.
+				if runs.Inc() == 1 {
+					return fmt.Errorf("crashed")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(c Config) error { return nil },
+		}, nil
+	}
+
+	var recorder tracetest.StandardSpanRecorder
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = 10 * time.Millisecond
+	cfg.Tracer = tracetest.NewProvider(tracetest.WithSpanRecorder(&recorder)).Tracer("test")
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test"}))
+	require.Eventually(t, func() bool { return runs.Load() == 2 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, cm.ApplyConfig(Config{Name: "test", Labels: map[string]string{"a": "b"}}))
+	require.NoError(t, cm.DeleteConfig("test"))
+	require.Error(t, cm.DeleteConfig("test"))
+
+	type spanSummary struct {
+		name, outcome, cause string
+		status               codes.Code
+	}
+	var spans []spanSummary
+	for _, span := range recorder.Completed() {
+		attrs := span.Attributes()
+		require.Equal(t, "test", attrs[instanceKey].AsString())
+		spans = append(spans, spanSummary{
+			name:    span.Name(),
+			outcome: attrs[outcomeKey].AsString(),
+			cause:   attrs[causeKey].AsString(),
+			status:  span.StatusCode(),
+		})
+	}
+	require.Equal(t, []spanSummary{
+		{name: "BasicManager.ApplyConfig", outcome: applyOutcomeCreated},
+		{name: "BasicManager.Restart", outcome: spanOutcomeRestarted, cause: string(RestartCauseAbnormalExit)},
+		{name: "BasicManager.ApplyConfig", outcome: applyOutcomeDynamicUpdate},
+		{name: "BasicManager.DeleteConfig", outcome: spanOutcomeDeleted},
+		{name: "BasicManager.DeleteConfig", outcome: applyOutcomeFailed, status: codes.Unknown},
+	}, spans)
+}
@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// ApplyConfigTx applies c like ApplyConfig, then watches the instance for
+// ProbationPeriod. If the instance exits abnormally during that time or isn't
+// running by the end of it, its previous config is applied again, or the
+// instance is removed if it didn't exist before, and the returned error wraps
+// ErrRolledBack. The instance is also rolled back if applying c stopped the
+// previous instance but failed to launch the new one.
+//
+// The instance isn't rolled back if its config was changed by something else
+// during the probation period. ApplyConfigTx blocks for the whole probation
+// period when the instance is healthy.
+func (m *BasicManager) ApplyConfigTx(c Config) error {
+	// Watch for exits before applying so an early crash isn't missed.
+	exits, unwatch := m.watchExits(c.Name)
+	defer unwatch()
+
+	var prev *Config
+	m.mut.Lock()
+	if proc, ok := m.processes[c.Name]; ok {
+		cfg := proc.cfg
+		prev = &cfg
+	}
+	m.mut.Unlock()
+
+	if err := m.ApplyConfig(c); err != nil {
+		// The previous instance is only gone if it was stopped to be
+		// replaced; otherwise there's nothing to roll back.
+		if _, running := m.Instance(c.Name); prev == nil || running {
+			return err
+		}
+		return m.rollbackTx(c, prev, err)
+	}
+
+	period := m.ManagerConfig().ProbationPeriod
+	if period <= 0 {
+		period = DefaultBasicManagerConfig.ProbationPeriod
+	}
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+
+	select {
+	case exitErr := <-exits:
+		return m.rollbackTx(c, prev, fmt.Errorf("instance exited abnormally during its probation period: %w", exitErr))
+	case <-timer.C:
+		if state, ok := m.InstanceState(c.Name); ok && state != InstanceStateRunning {
+			return m.rollbackTx(c, prev, fmt.Errorf("instance was %s at the end of its probation period", state))
+		}
+		return nil
+	}
+}
+
+// rollbackTx restores prev after c failed with cause, removing the instance
+// if prev is nil.
+func (m *BasicManager) rollbackTx(c Config, prev *Config, cause error) error {
+	defer m.lockName(c.Name)()
+
+	m.mut.Lock()
+	proc, running := m.processes[c.Name]
+	changed := running && !sameConfig(proc.cfg, c)
+	m.mut.Unlock()
+
+	if changed {
+		level.Warn(m.logger).Log("msg", "not rolling back instance whose config changed during its probation period", "instance", c.Name, "err", cause)
+		return wrapError(c.Name, CodeLaunchFailed, cause)
+	}
+
+	level.Warn(m.logger).Log("msg", "rolling back config of instance", "instance", c.Name, "err", cause)
+
+	var err error
+	switch {
+	case prev != nil:
+		err = m.applyAndRecord(context.Background(), *prev)
+		if err == nil {
+			m.forgetPrevious(c.Name)
+		}
+	case running:
+		errs := m.deleteConfigs([]string{c.Name})
+		err = errs[c.Name]
+	}
+	if err != nil {
+		return wrapError(c.Name, CodeLaunchFailed, fmt.Errorf("%w: %s (rolling back also failed: %s)", ErrRolledBack, cause, err))
+	}
+	return wrapError(c.Name, CodeLaunchFailed, fmt.Errorf("%w: %s", ErrRolledBack, cause))
+}
+
+// watchExits returns a channel receiving the errors of the abnormal exits of
+// the named instance, until the returned function is called.
+func (m *BasicManager) watchExits(name string) (<-chan error, func()) {
+	ch := make(chan error, 1)
+
+	m.exitWatchersMut.Lock()
+	defer m.exitWatchersMut.Unlock()
+	if m.exitWatchers[name] == nil {
+		m.exitWatchers[name] = make(map[chan error]struct{})
+	}
+	m.exitWatchers[name][ch] = struct{}{}
+
+	return ch, func() {
+		m.exitWatchersMut.Lock()
+		defer m.exitWatchersMut.Unlock()
+		delete(m.exitWatchers[name], ch)
+		if len(m.exitWatchers[name]) == 0 {
+			delete(m.exitWatchers, name)
+		}
+	}
+}
+
+// notifyExitWatchers sends err to the watchers of the named instance. Only
+// the first exit is kept for watchers which haven't received it yet.
+func (m *BasicManager) notifyExitWatchers(name string, err error) {
+	m.exitWatchersMut.Lock()
+	defer m.exitWatchersMut.Unlock()
+	for ch := range m.exitWatchers[name] {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestBasicManager_ApplyConfigTx(t *testing.T) {
+	// Configs with HostFilter set crash right after starting.
+	spawner := func(c Config) (ManagedInstance, error) {
+		return &mockInstance{
+			RunFunc: func(ctx context.Context) error {
+				if c.HostFilter {
+					return fmt.Errorf("crashed")
+				}
+				<-ctx.Done()
+				return nil
+			},
+			UpdateFunc: func(newCfg Config) error {
+				if newCfg.HostFilter != c.HostFilter {
+					return ErrInvalidUpdate{Inner: fmt.Errorf("can't change host_filter")}
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.InstanceRestartBackoff = time.Hour
+	cfg.ProbationPeriod = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// Healthy configs are kept.
+	require.NoError(t, cm.ApplyConfigTx(Config{Name: "test", Labels: map[string]string{"a": "b"}}))
+	require.Equal(t, map[string]string{"a": "b"}, cm.ListConfigs()["test"].Labels)
+
+	// Configs which crash the instance are rolled back.
+	err := cm.ApplyConfigTx(Config{Name: "test", HostFilter: true})
+	require.ErrorIs(t, err, ErrRolledBack)
+	require.Contains(t, err.Error(), "crashed")
+	require.False(t, cm.ListConfigs()["test"].HostFilter)
+	require.Eventually(t, func() bool {
+		state, _ := cm.InstanceState("test")
+		return state == InstanceStateRunning
+	}, time.Second, 10*time.Millisecond)
+
+	// New instances which crash are removed.
+	err = cm.ApplyConfigTx(Config{Name: "new", HostFilter: true})
+	require.ErrorIs(t, err, ErrRolledBack)
+	require.NotContains(t, cm.ListConfigs(), "new")
+}
+
+func TestBasicManager_ApplyConfigTx_NotRunning(t *testing.T) {
+	spawner := func(c Config) (ManagedInstance, error) {
+		return readyInstance{
+			mockInstance: &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					<-ctx.Done()
+					return nil
+				},
+			},
+			ready: atomic.NewBool(false),
+		}, nil
+	}
+
+	cfg := DefaultBasicManagerConfig
+	cfg.StartupTimeout = time.Hour
+	cfg.ProbationPeriod = 100 * time.Millisecond
+
+	cm := NewBasicManager(cfg, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	// Instances which are still starting at the end of the probation period
+	// are rolled back too.
+	err := cm.ApplyConfigTx(Config{Name: "test"})
+	require.ErrorIs(t, err, ErrRolledBack)
+	require.Contains(t, err.Error(), "starting")
+	require.Empty(t, cm.ListConfigs())
+}
@@ -0,0 +1,47 @@
+package instance
+
+import (
+	"fmt"
+	"io"
+)
+
+// ValidateConfigs checks that an instance can be constructed from each of the
+// given configs by calling the Factory of the BasicManager, without running
+// any of the constructed instances or affecting the instances being managed.
+// Constructed instances implementing io.Closer are closed once checked.
+//
+// The returned map holds the error for each config name that failed
+// validation and is empty if all configs are valid. As with ApplyConfigs,
+// configs without a name or whose name appears more than once in cs are
+// invalid.
+func (m *BasicManager) ValidateConfigs(cs []Config) map[string]error {
+	errs := make(map[string]error)
+
+	m.mut.Lock()
+	launch := m.launch
+	m.mut.Unlock()
+
+	counts := make(map[string]int, len(cs))
+	for _, c := range cs {
+		counts[c.Name]++
+	}
+
+	for _, c := range cs {
+		if err := checkConfigName(c, counts); err != nil {
+			errs[c.Name] = wrapError(c.Name, CodeValidation, err)
+			continue
+		}
+
+		inst, err := launch(c)
+		if err != nil {
+			errs[c.Name] = wrapError(c.Name, CodeValidation, fmt.Errorf("failed to construct instance %s: %w", c.Name, err))
+			continue
+		}
+		if closer, ok := inst.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs[c.Name] = wrapError(c.Name, CodeValidation, fmt.Errorf("failed to close instance %s: %w", c.Name, err))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,56 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+type closingInstance struct {
+	*mockInstance
+	closed *atomic.Int32
+}
+
+func (i closingInstance) Close() error {
+	i.closed.Inc()
+	return nil
+}
+
+func TestBasicManager_ValidateConfigs(t *testing.T) {
+	var (
+		ran    = atomic.NewBool(false)
+		closed = atomic.NewInt32(0)
+	)
+	spawner := func(c Config) (ManagedInstance, error) {
+		if c.Name == "broken" {
+			return nil, fmt.Errorf("invalid config")
+		}
+		return closingInstance{
+			mockInstance: &mockInstance{
+				RunFunc: func(ctx context.Context) error {
+					ran.Store(true)
+					<-ctx.Done()
+					return nil
+				},
+			},
+			closed: closed,
+		}, nil
+	}
+
+	cm := NewBasicManager(DefaultBasicManagerConfig, log.NewNopLogger(), spawner)
+	defer cm.Stop()
+
+	errs := cm.ValidateConfigs([]Config{{Name: "a"}, {Name: "broken"}, {Name: ""}, {Name: "b"}, {Name: "b"}})
+	require.Len(t, errs, 3)
+	require.EqualError(t, errs["broken"], "failed to construct instance broken: invalid config")
+	require.EqualError(t, errs[""], "missing instance name")
+	require.EqualError(t, errs["b"], `found multiple configs named "b"`)
+
+	require.Equal(t, int32(1), closed.Load(), "constructed instances should be closed")
+	require.False(t, ran.Load(), "validated instances should never run")
+	require.Empty(t, cm.ListConfigs(), "validated configs should not be managed")
+}
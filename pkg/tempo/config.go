@@ -5,20 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"time"
 
+	"github.com/grafana/agent/pkg/tempo/loadbalancingexporter"
 	"github.com/grafana/agent/pkg/tempo/noopreceiver"
 	"github.com/grafana/agent/pkg/tempo/promsdprocessor"
+	"github.com/grafana/agent/pkg/tempo/spanfilterprocessor"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/spanmetricsprocessor"
 	prom_config "github.com/prometheus/common/config"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/config/configtelemetry"
 	"go.opentelemetry.io/collector/exporter/otlpexporter"
 	"go.opentelemetry.io/collector/exporter/prometheusexporter"
 	"go.opentelemetry.io/collector/processor/attributesprocessor"
 	"go.opentelemetry.io/collector/processor/batchprocessor"
+	"go.opentelemetry.io/collector/processor/memorylimiter"
 	"go.opentelemetry.io/collector/receiver/jaegerreceiver"
 	"go.opentelemetry.io/collector/receiver/kafkareceiver"
 	"go.opentelemetry.io/collector/receiver/opencensusreceiver"
@@ -34,6 +39,24 @@ const (
 // Config controls the configuration of Tempo trace pipelines.
 type Config struct {
 	Configs []InstanceConfig `yaml:"configs,omitempty"`
+
+	// LogFormat is the format of Tempo logs, either logfmt or json. Defaults
+	// to DefaultLogFormat when empty.
+	LogFormat string `yaml:"log_format,omitempty"`
+
+	// LogTimestampFormat is the Go time layout used for timestamps in Tempo
+	// logs. Defaults to RFC3339 when empty.
+	LogTimestampFormat string `yaml:"log_timestamp_format,omitempty"`
+
+	// LogUTC controls whether timestamps in Tempo logs are converted to UTC
+	// before being formatted. Defaults to true when unset.
+	LogUTC *bool `yaml:"log_utc,omitempty"`
+
+	// EnableZPages exposes the tracez zpage, which shows the recent spans of
+	// the collector components about the spans flowing through the
+	// pipelines. Spans are only sampled and recorded while EnableZPages is
+	// set.
+	EnableZPages bool `yaml:"enable_zpages,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -45,8 +68,30 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return c.Validate()
 }
 
+// logTimestampFormat returns the configured timestamp layout for logs and
+// whether timestamps should be converted to UTC, applying defaults.
+func (c *Config) logTimestampFormat() (format string, utc bool) {
+	format, utc = time.RFC3339, true
+	if c.LogTimestampFormat != "" {
+		format = c.LogTimestampFormat
+	}
+	if c.LogUTC != nil {
+		utc = *c.LogUTC
+	}
+	return format, utc
+}
+
+// DefaultLogFormat is the LogFormat used when a Config doesn't set one.
+const DefaultLogFormat = "logfmt"
+
 // Validate ensures that the Config is valid.
 func (c *Config) Validate() error {
+	switch c.LogFormat {
+	case "", "logfmt", "json":
+	default:
+		return fmt.Errorf("unsupported log format %q", c.LogFormat)
+	}
+
 	names := make(map[string]struct{}, len(c.Configs))
 	for idx, c := range c.Configs {
 		if c.Name == "" {
@@ -61,25 +106,171 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// ValidateConfig checks cfg the same way NewInstance checks the config of
+// each instance before building its pipeline, without creating or starting
+// any of the pipeline's components. No ports are opened and no backends are
+// contacted. Disabled instances are validated too.
+func ValidateConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for _, c := range cfg.Configs {
+		if _, err := c.otelConfig(); err != nil {
+			return fmt.Errorf("invalid tempo config %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// DefaultShutdownTimeout is the ShutdownTimeout used when an InstanceConfig
+// doesn't set one.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// shutdownTimeout returns the ShutdownTimeout of c, applying its default.
+func (c *InstanceConfig) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return c.ShutdownTimeout
+}
+
+// metricsLevel returns the MetricsLevel of c, applying its default.
+func (c *InstanceConfig) metricsLevel() (configtelemetry.Level, error) {
+	name := c.MetricsLevel
+	if name == "" {
+		name = DefaultMetricsLevel
+	}
+
+	var level configtelemetry.Level
+	if err := level.Set(name); err != nil {
+		return level, fmt.Errorf("invalid metrics_level: %w", err)
+	}
+	return level, nil
+}
+
+// withoutExporters returns a copy of c without the settings that only affect
+// the remote_write exporters, the shutdown of the pipeline or its telemetry.
+// When the copies of two configs are equal, switching from one to the other
+// doesn't require rebuilding the receivers and processors of the pipeline.
+func (c InstanceConfig) withoutExporters() InstanceConfig {
+	c.ShutdownTimeout = 0
+	c.ShutdownStageTimeout = 0
+	c.MetricsLevel = ""
+	c.RemoteWrite = nil
+	c.LoadBalancing = nil
+	c.PushConfig = PushConfig{Batch: c.PushConfig.Batch}
+	return c
+}
+
+// withMetricsLevel returns a copy of c with its MetricsLevel set to level.
+func (c InstanceConfig) withMetricsLevel(level string) InstanceConfig {
+	c.MetricsLevel = level
+	return c
+}
+
+// withDefaults returns a copy of c with the defaults applied by this package
+// set explicitly. The RemoteWrite configs are copied so c isn't modified.
+func (c InstanceConfig) withDefaults() InstanceConfig {
+	enabled := c.IsEnabled()
+	c.Enabled = &enabled
+	c.ShutdownTimeout = c.shutdownTimeout()
+	if c.MetricsLevel == "" {
+		c.MetricsLevel = DefaultMetricsLevel
+	}
+
+	// An empty compression isn't the default of the YAML config, but means
+	// no compression.
+	if c.PushConfig.Compression == "" {
+		c.PushConfig.Compression = compressionNone
+	}
+	if c.RemoteWrite != nil {
+		rws := make([]RemoteWriteConfig, len(c.RemoteWrite))
+		for i, rw := range c.RemoteWrite {
+			if rw.Compression == "" {
+				rw.Compression = compressionNone
+			}
+			rws[i] = rw
+		}
+		c.RemoteWrite = rws
+	}
+	if c.LoadBalancing != nil {
+		lb := *c.LoadBalancing
+		if lb.Exporter.Compression == "" {
+			lb.Exporter.Compression = compressionNone
+		}
+		c.LoadBalancing = &lb
+	}
+	return c
+}
+
+// IsEnabled returns whether the instance should be run.
+func (c *InstanceConfig) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
 // InstanceConfig configures an individual Tempo trace pipeline.
 type InstanceConfig struct {
 	Name string `yaml:"name"`
 
+	// Enabled controls whether the instance is run. Defaults to true.
+	Enabled *bool `yaml:"enabled,omitempty"`
+
+	// ShutdownTimeout is how long to wait for the pipeline of the instance to
+	// shut down before giving up on it. Defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout,omitempty"`
+
+	// ShutdownStageTimeout additionally limits how long each stage of the
+	// shutdown of the pipeline may take: stopping the receivers, flushing the
+	// processors and flushing the exporters. A stage which hangs is abandoned
+	// without using up the ShutdownTimeout of the next stages. Stages are only
+	// limited by ShutdownTimeout if ShutdownStageTimeout is 0.
+	ShutdownStageTimeout time.Duration `yaml:"shutdown_stage_timeout,omitempty"`
+
+	// MetricsLevel is the level of the telemetry about the pipeline of the
+	// instance: none, basic, normal or detailed. Defaults to
+	// DefaultMetricsLevel. It can be changed without rebuilding the pipeline.
+	MetricsLevel string `yaml:"metrics_level,omitempty"`
+
 	// Deprecated in favor of RemoteWrite and Batch.
 	PushConfig PushConfig `yaml:"push_config,omitempty"`
 
 	// RemoteWrite defines one or multiple backends that can receive the pipeline's traffic.
 	RemoteWrite []RemoteWriteConfig `yaml:"remote_write,omitempty"`
 
+	// LoadBalancing sends spans to a set of backends instead, routing all
+	// spans with the same routing key to the same backend. It can't be used
+	// with RemoteWrite or PushConfig.
+	LoadBalancing *LoadBalancingConfig `yaml:"load_balancing,omitempty"`
+
 	// Receivers: https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/receiver/README.md
 	Receivers map[string]interface{} `yaml:"receivers,omitempty"`
 
+	// ReceiverBindAddresses holds the address receivers listen on for each
+	// protocol, keyed by protocol (e.g., otlp/grpc or zipkin). Endpoints
+	// which would listen on all interfaces listen on the address of their
+	// protocol instead, or on DefaultReceiverBindAddress if it's unset.
+	ReceiverBindAddresses map[string]string `yaml:"receiver_bind_addresses,omitempty"`
+
+	// ReceiverTLS, when set, makes all receivers serve TLS.
+	ReceiverTLS *ReceiverTLSConfig `yaml:"receiver_tls,omitempty"`
+
+	// MaxRecvMsgSizeMiB is the largest message, in MiB, accepted by the gRPC
+	// server of the OTLP receiver. The gRPC default of 4 MiB is used when 0.
+	MaxRecvMsgSizeMiB uint64 `yaml:"max_recv_msg_size_mib,omitempty"`
+
 	// Batch: https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/processor/batchprocessor/config.go#L24
 	Batch map[string]interface{} `yaml:"batch,omitempty"`
 
 	// Attributes: https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34b5d387627875c498d7f43619f37ee3/processor/attributesprocessor/config.go#L30
 	Attributes map[string]interface{} `yaml:"attributes,omitempty"`
 
+	// MemoryLimiter: https://github.com/open-telemetry/opentelemetry-collector/blob/v0.21.0/processor/memorylimiter/config.go#L23
+	MemoryLimiter map[string]interface{} `yaml:"memory_limiter,omitempty"`
+
+	// SpanFilter drops spans by name and attributes before any other
+	// processor handles them. See spanfilterprocessor.Config.
+	SpanFilter map[string]interface{} `yaml:"span_filter,omitempty"`
+
 	// prom service discovery
 	ScrapeConfigs []interface{} `yaml:"scrape_configs,omitempty"`
 
@@ -156,12 +347,97 @@ func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) err
 	return nil
 }
 
+// LoadBalancingConfig controls the configuration of the load balancing
+// exporter.
+type LoadBalancingConfig struct {
+	// Exporter configures the exporter of each backend. Its endpoint must be
+	// empty since it's set to the address of the backend.
+	Exporter RemoteWriteConfig `yaml:"exporter,omitempty"`
+
+	// Resolver finds the backends. Exactly one resolver must be set.
+	Resolver LoadBalancingResolverConfig `yaml:"resolver"`
+
+	// RoutingKey is either traceID or service. Defaults to traceID, which
+	// sends all spans of a trace to the same backend.
+	RoutingKey string `yaml:"routing_key,omitempty"`
+}
+
+// LoadBalancingResolverConfig configures how the load balancing exporter
+// finds its backends.
+type LoadBalancingResolverConfig struct {
+	Static *StaticResolverConfig `yaml:"static,omitempty"`
+	DNS    *DNSResolverConfig    `yaml:"dns,omitempty"`
+}
+
+// StaticResolverConfig configures a fixed list of backends. Hostnames
+// without a port use port 55680.
+type StaticResolverConfig struct {
+	Hostnames []string `yaml:"hostnames"`
+}
+
+// DNSResolverConfig configures backends found by periodically resolving a
+// hostname. Every IP address it resolves to is used as a backend.
+type DNSResolverConfig struct {
+	Hostname string        `yaml:"hostname"`
+	Port     string        `yaml:"port,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *LoadBalancingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = LoadBalancingConfig{
+		Exporter:   DefaultRemoteWriteConfig,
+		RoutingKey: loadbalancingexporter.RoutingKeyTraceID,
+	}
+
+	type plain LoadBalancingConfig
+	return unmarshal((*plain)(c))
+}
+
+// exporter builds the config of the load balancing exporter.
+func (c *LoadBalancingConfig) exporter() (map[string]interface{}, error) {
+	if c.Exporter.Endpoint != "" {
+		return nil, errors.New("load_balancing exporter must not configure an endpoint, it's set to the address of each backend")
+	}
+	otlpExporter, err := otlpExporterConfig(c.Exporter)
+	if err != nil {
+		return nil, err
+	}
+	delete(otlpExporter, "endpoint")
+
+	resolver := map[string]interface{}{}
+	if r := c.Resolver.Static; r != nil {
+		resolver["static"] = map[string]interface{}{
+			"hostnames": r.Hostnames,
+		}
+	}
+	if r := c.Resolver.DNS; r != nil {
+		resolver["dns"] = map[string]interface{}{
+			"hostname": r.Hostname,
+			"port":     r.Port,
+			"interval": r.Interval,
+			"timeout":  r.Timeout,
+		}
+	}
+
+	return map[string]interface{}{
+		"protocol": map[string]interface{}{
+			"otlp": otlpExporter,
+		},
+		"resolver":    resolver,
+		"routing_key": c.RoutingKey,
+	}, nil
+}
+
 // SpanMetricsConfig controls the configuration of spanmetricsprocessor and the related metrics exporter.
 type SpanMetricsConfig struct {
 	LatencyHistogramBuckets []time.Duration                  `yaml:"latency_histogram_buckets,omitempty"`
 	Dimensions              []spanmetricsprocessor.Dimension `yaml:"dimensions,omitempty"`
 
 	// Configuration for Prometheus exporter: https://github.com/open-telemetry/opentelemetry-collector/blob/7d7ae2eb34/exporter/prometheusexporter/README.md.
+	// When not set, the metrics are exposed on the /metrics endpoint of the
+	// agent instead, prefixed with tempo_spanmetrics_.
 	MetricsExporter map[string]interface{} `yaml:"metrics_exporter,omitempty"`
 }
 
@@ -170,7 +446,12 @@ func exporter(remoteWriteConfig RemoteWriteConfig) (map[string]interface{}, erro
 	if len(remoteWriteConfig.Endpoint) == 0 {
 		return nil, errors.New("must have a configured a backend endpoint")
 	}
+	return otlpExporterConfig(remoteWriteConfig)
+}
 
+// otlpExporterConfig builds the config of an OTLP exporter from
+// remoteWriteConfig without checking its endpoint.
+func otlpExporterConfig(remoteWriteConfig RemoteWriteConfig) (map[string]interface{}, error) {
 	headers := map[string]string{}
 	if remoteWriteConfig.Headers != nil {
 		headers = remoteWriteConfig.Headers
@@ -222,8 +503,15 @@ func exporter(remoteWriteConfig RemoteWriteConfig) (map[string]interface{}, erro
 }
 
 // exporters builds one or multiple exporters from a remote_write block.
-// It also supports building an exporter from push_config.
+// It also supports building an exporter from push_config or load_balancing.
 func (c *InstanceConfig) exporters() (map[string]interface{}, error) {
+	if c.LoadBalancing != nil {
+		lbExporter, err := c.LoadBalancing.exporter()
+		return map[string]interface{}{
+			loadbalancingexporter.TypeStr: lbExporter,
+		}, err
+	}
+
 	if len(c.RemoteWrite) == 0 {
 		otlpExporter, err := exporter(RemoteWriteConfig{
 			Endpoint:           c.PushConfig.Endpoint,
@@ -258,10 +546,18 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		return nil, errors.New("must have at least one configured receiver")
 	}
 
+	if _, err := c.metricsLevel(); err != nil {
+		return nil, err
+	}
+
 	if len(c.RemoteWrite) != 0 && len(c.PushConfig.Endpoint) != 0 {
 		return nil, errors.New("must not configure push_config and remote_write. push_config is deprecated in favor of remote_write")
 	}
 
+	if c.LoadBalancing != nil && (len(c.RemoteWrite) != 0 || len(c.PushConfig.Endpoint) != 0) {
+		return nil, errors.New("must not configure load_balancing with remote_write or push_config")
+	}
+
 	if c.Batch != nil && c.PushConfig.Batch != nil {
 		return nil, errors.New("must not configure push_config.batch and batch. push_config.batch is deprecated in favor of batch")
 	}
@@ -278,6 +574,21 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 	// processors
 	processors := map[string]interface{}{}
 	processorNames := []string{}
+
+	// The memory limiter must be the first processor of the pipeline so that
+	// it can refuse spans before any other processor handles them.
+	if c.MemoryLimiter != nil {
+		processors["memory_limiter"] = c.MemoryLimiter
+		processorNames = append(processorNames, "memory_limiter")
+	}
+
+	// Spans are filtered right after so that dropped spans don't cost any
+	// more processing.
+	if c.SpanFilter != nil {
+		processors[spanfilterprocessor.TypeStr] = c.SpanFilter
+		processorNames = append(processorNames, spanfilterprocessor.TypeStr)
+	}
+
 	if c.ScrapeConfigs != nil {
 		processorNames = append(processorNames, promsdprocessor.TypeStr)
 		processors[promsdprocessor.TypeStr] = map[string]interface{}{
@@ -298,23 +609,33 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		processorNames = append(processorNames, "batch")
 	}
 
+	spanMetricsExporter := defaultSpanMetricsExporter
 	if c.SpanMetrics != nil {
 		// Configure the metrics exporter.
-		exporters[defaultSpanMetricsExporter] = c.SpanMetrics.MetricsExporter
+		if c.SpanMetrics.MetricsExporter != nil {
+			exporters[spanMetricsExporter] = c.SpanMetrics.MetricsExporter
+		} else {
+			spanMetricsExporter = registryExporterType
+			exporters[spanMetricsExporter] = nil
+		}
 
 		processorNames = append(processorNames, "spanmetrics")
 		processors["spanmetrics"] = map[string]interface{}{
-			"metrics_exporter":          defaultSpanMetricsExporter,
+			"metrics_exporter":          spanMetricsExporter,
 			"latency_histogram_buckets": c.SpanMetrics.LatencyHistogramBuckets,
 			"dimensions":                c.SpanMetrics.Dimensions,
 		}
 	}
 
-	// receivers
+	// receivers. They're copied so the noop receiver added for spanmetrics
+	// doesn't leak into c.
+	receivers := make(map[string]interface{}, len(c.Receivers)+1)
 	receiverNames := []string{}
-	for name := range c.Receivers {
+	for name, receiver := range c.Receivers {
+		receivers[name] = receiver
 		receiverNames = append(receiverNames, name)
 	}
+	sort.Strings(receiverNames)
 
 	pipelines := map[string]interface{}{
 		"traces": map[string]interface{}{
@@ -327,17 +648,17 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 	if c.SpanMetrics != nil {
 		// Insert a noop receiver in the metrics pipeline.
 		// Added to pass validation requiring at least one receiver in a pipeline.
-		c.Receivers[noopreceiver.TypeStr] = nil
+		receivers[noopreceiver.TypeStr] = nil
 
 		pipelines[spanMetricsPipelineName] = map[string]interface{}{
 			"receivers": []string{noopreceiver.TypeStr},
-			"exporters": []string{defaultSpanMetricsExporter},
+			"exporters": []string{spanMetricsExporter},
 		}
 	}
 
 	otelMapStructure["exporters"] = exporters
 	otelMapStructure["processors"] = processors
-	otelMapStructure["receivers"] = c.Receivers
+	otelMapStructure["receivers"] = receivers
 
 	// pipelines
 	otelMapStructure["service"] = map[string]interface{}{
@@ -361,9 +682,54 @@ func (c *InstanceConfig) otelConfig() (*configmodels.Config, error) {
 		return nil, fmt.Errorf("failed to load OTel config: %w", err)
 	}
 
+	for name, exp := range otelCfg.Exporters {
+		if lbCfg, ok := exp.(*loadbalancingexporter.Config); ok {
+			if err := lbCfg.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid exporter %s: %w", name, err)
+			}
+		}
+	}
+	for name, proc := range otelCfg.Processors {
+		if filterCfg, ok := proc.(*spanfilterprocessor.Config); ok {
+			if err := filterCfg.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid processor %s: %w", name, err)
+			}
+		}
+	}
+
+	if err := validateReceiverBindAddresses(c.ReceiverBindAddresses); err != nil {
+		return nil, err
+	}
+	if err := applyReceiverBindAddresses(otelCfg.Receivers, c.ReceiverBindAddresses); err != nil {
+		return nil, err
+	}
+
+	if c.ReceiverTLS != nil {
+		if err := c.ReceiverTLS.Validate(); err != nil {
+			return nil, err
+		}
+		if err := applyReceiverTLS(otelCfg.Receivers, c.ReceiverTLS); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.MaxRecvMsgSizeMiB > 0 {
+		applyMaxRecvMsgSize(otelCfg.Receivers, c.MaxRecvMsgSizeMiB)
+	}
+
 	return otelCfg, nil
 }
 
+// applyMaxRecvMsgSize sets the maximum message size of the gRPC servers of
+// OTLP receivers which don't set one themselves.
+func applyMaxRecvMsgSize(receivers configmodels.Receivers, sizeMiB uint64) {
+	for _, r := range receivers {
+		if r, ok := r.(*otlpreceiver.Config); ok && r.GRPC != nil && r.GRPC.MaxRecvMsgSizeMiB == 0 {
+			r.GRPC.MaxRecvMsgSizeMiB = sizeMiB
+		}
+	}
+}
+
 // tracingFactories() only creates the needed factories.  if we decide to add support for a new
 // processor, exporter, receiver we need to add it here
 func tracingFactories() (component.Factories, error) {
@@ -386,7 +752,9 @@ func tracingFactories() (component.Factories, error) {
 
 	exporters, err := component.MakeExporterFactoryMap(
 		otlpexporter.NewFactory(),
+		loadbalancingexporter.NewFactory(),
 		prometheusexporter.NewFactory(),
+		newRegistryExporterFactory(nil),
 	)
 	if err != nil {
 		return component.Factories{}, err
@@ -395,7 +763,9 @@ func tracingFactories() (component.Factories, error) {
 	processors, err := component.MakeProcessorFactoryMap(
 		batchprocessor.NewFactory(),
 		attributesprocessor.NewFactory(),
+		memorylimiter.NewFactory(),
 		promsdprocessor.NewFactory(),
+		spanfilterprocessor.NewFactory(),
 		spanmetricsprocessor.NewFactory(),
 	)
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/grafana/agent/pkg/util"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -78,6 +79,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp:
     endpoint: example.com:12345
@@ -111,6 +113,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp:
     endpoint: example.com:12345
@@ -156,6 +159,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp:
     endpoint: example.com:12345
@@ -200,6 +204,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp:
     endpoint: example.com:12345
@@ -232,6 +237,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp:
     endpoint: example.com:12345
@@ -263,6 +269,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp:
     endpoint: example.com:12345
@@ -324,6 +331,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp/0:
     endpoint: example.com:12345
@@ -369,6 +377,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp/0:
     endpoint: example.com:12345
@@ -408,12 +417,14 @@ remote_write:
 batch:
   timeout: 5s
   send_batch_size: 100
+  send_batch_max_size: 1000
 `,
 			expectedConfig: `
 receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp/0:
     endpoint: example.com:12345
@@ -424,6 +435,7 @@ processors:
   batch:
     timeout: 5s
     send_batch_size: 100
+    send_batch_max_size: 1000
 service:
   pipelines:
     traces:
@@ -432,6 +444,124 @@ service:
       receivers: ["jaeger"]
 `,
 		},
+		{
+			name: "memory limiter",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+attributes:
+  actions:
+  - key: montgomery
+    value: forever
+    action: update
+memory_limiter:
+  check_interval: 1s
+  limit_mib: 512
+  spike_limit_mib: 128
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: localhost:14250
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  memory_limiter:
+    check_interval: 1s
+    limit_mib: 512
+    spike_limit_mib: 128
+  attributes:
+    actions:
+    - key: montgomery
+      value: forever
+      action: update
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["memory_limiter", "attributes"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "span filter",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+attributes:
+  actions:
+  - key: montgomery
+    value: forever
+    action: update
+span_filter:
+  exclude:
+    match_type: strict
+    attributes:
+    - key: http.target
+      value: /healthz
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: localhost:14250
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+processors:
+  span_filter:
+    exclude:
+      match_type: strict
+      attributes:
+      - key: http.target
+        value: /healthz
+  attributes:
+    actions:
+    - key: montgomery
+      value: forever
+      action: update
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["span_filter", "attributes"]
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "invalid span filter",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+span_filter:
+  exclude:
+    match_type: regexp
+    span_names: ["("]
+`,
+			expectedError: true,
+		},
 		{
 			name: "span metrics prometheus exporter",
 			cfg: `
@@ -457,6 +587,7 @@ receivers:
   jaeger:
     protocols:
       grpc:
+        endpoint: localhost:14250
 exporters:
   otlp/0:
     endpoint: example.com:12345
@@ -485,6 +616,360 @@ service:
       receivers: ["noop"]
 `,
 		},
+		{
+			name: "span metrics agent registry",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+spanmetrics:
+  dimensions:
+    - name: http.method
+`,
+			expectedConfig: `
+receivers:
+  noop:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: localhost:14250
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+  registry:
+processors:
+  spanmetrics:
+    metrics_exporter: registry
+    dimensions:
+      - name: http.method
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: ["spanmetrics"]
+      receivers: ["jaeger"]
+    metrics/spanmetrics:
+      exporters: ["registry"]
+      receivers: ["noop"]
+`,
+		},
+		{
+			name: "receiver tls",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+      http:
+        tls_settings:
+          cert_file: http.crt
+          key_file: http.key
+remote_write:
+  - endpoint: example.com:12345
+receiver_tls:
+  cert_file: server.crt
+  key_file: server.key
+  client_ca_file: ca.crt
+  client_auth: require_and_verify
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: localhost:4317
+        tls_settings:
+          cert_file: server.crt
+          key_file: server.key
+          client_ca_file: ca.crt
+      http:
+        endpoint: localhost:55681
+        tls_settings:
+          cert_file: http.crt
+          key_file: http.key
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: []
+      receivers: ["otlp"]
+`,
+		},
+		{
+			name: "receiver tls with plaintext-only protocol",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      thrift_http:
+remote_write:
+  - endpoint: example.com:12345
+receiver_tls:
+  cert_file: server.crt
+  key_file: server.key
+`,
+			expectedError: true,
+		},
+		{
+			name: "receiver bind addresses",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+      http:
+  jaeger:
+    protocols:
+      thrift_compact:
+        endpoint: 0.0.0.0:6831
+  zipkin:
+    endpoint: 10.0.0.1:9411
+remote_write:
+  - endpoint: example.com:12345
+receiver_bind_addresses:
+  otlp/grpc: 0.0.0.0
+  jaeger/thrift_compact: 10.0.0.2
+  zipkin: 10.0.0.2
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+      http:
+        endpoint: localhost:55681
+  jaeger:
+    protocols:
+      thrift_compact:
+        endpoint: 10.0.0.2:6831
+  zipkin:
+    endpoint: 10.0.0.1:9411
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: []
+      receivers: ["jaeger", "otlp", "zipkin"]
+`,
+		},
+		{
+			name: "receiver bind address for unknown protocol",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+receiver_bind_addresses:
+  otlp/thrift: 0.0.0.0
+`,
+			expectedError: true,
+		},
+		{
+			name: "max recv msg size",
+			cfg: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+      http:
+  otlp/custom:
+    protocols:
+      grpc:
+        max_recv_msg_size_mib: 8
+remote_write:
+  - endpoint: example.com:12345
+max_recv_msg_size_mib: 16
+`,
+			expectedConfig: `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: localhost:4317
+        max_recv_msg_size_mib: 16
+      http:
+        endpoint: localhost:55681
+  otlp/custom:
+    protocols:
+      grpc:
+        endpoint: localhost:4317
+        max_recv_msg_size_mib: 8
+exporters:
+  otlp/0:
+    endpoint: example.com:12345
+    compression: gzip
+    retry_on_failure:
+      max_elapsed_time: 60s
+service:
+  pipelines:
+    traces:
+      exporters: ["otlp/0"]
+      processors: []
+      receivers: ["otlp", "otlp/custom"]
+`,
+		},
+		{
+			name: "load balancing",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+load_balancing:
+  exporter:
+    insecure: true
+  resolver:
+    dns:
+      hostname: tempo
+      port: 4317
+      interval: 10s
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: localhost:14250
+exporters:
+  loadbalancing:
+    routing_key: traceID
+    protocol:
+      otlp:
+        compression: gzip
+        insecure: true
+        retry_on_failure:
+          max_elapsed_time: 60s
+    resolver:
+      dns:
+        hostname: tempo
+        port: 4317
+        interval: 10s
+service:
+  pipelines:
+    traces:
+      exporters: ["loadbalancing"]
+      processors: []
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "load balancing by service",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+load_balancing:
+  routing_key: service
+  resolver:
+    static:
+      hostnames: [tempo-1:4317, tempo-2]
+`,
+			expectedConfig: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+        endpoint: localhost:14250
+exporters:
+  loadbalancing:
+    routing_key: service
+    protocol:
+      otlp:
+        compression: gzip
+        retry_on_failure:
+          max_elapsed_time: 60s
+    resolver:
+      static:
+        hostnames: [tempo-1:4317, tempo-2]
+service:
+  pipelines:
+    traces:
+      exporters: ["loadbalancing"]
+      processors: []
+      receivers: ["jaeger"]
+`,
+		},
+		{
+			name: "load balancing and remote_write",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+remote_write:
+  - endpoint: example.com:12345
+load_balancing:
+  resolver:
+    static:
+      hostnames: [tempo-1:4317]
+`,
+			expectedError: true,
+		},
+		{
+			name: "load balancing exporter endpoint",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+load_balancing:
+  exporter:
+    endpoint: example.com:12345
+  resolver:
+    static:
+      hostnames: [tempo-1:4317]
+`,
+			expectedError: true,
+		},
+		{
+			name: "load balancing without resolver",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+load_balancing:
+  resolver: {}
+`,
+			expectedError: true,
+		},
+		{
+			name: "load balancing unknown routing key",
+			cfg: `
+receivers:
+  jaeger:
+    protocols:
+      grpc:
+load_balancing:
+  routing_key: span
+  resolver:
+    static:
+      hostnames: [tempo-1:4317]
+`,
+			expectedError: true,
+		},
 	}
 
 	for _, tc := range tt {
@@ -531,3 +1016,95 @@ func sortPipelinesExporters(cfg *configmodels.Config) {
 		sort.Strings(p.Exporters)
 	}
 }
+
+func TestValidateConfig(t *testing.T) {
+	loadConfig := func(text string) Config {
+		var cfg Config
+		require.NoError(t, yaml.UnmarshalStrict([]byte(util.Untab(text)), &cfg))
+		return cfg
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		cfg := loadConfig(`
+configs:
+- name: default
+  receivers:
+		jaeger:
+			protocols:
+				grpc:
+	remote_write:
+		- endpoint: example.com:12345
+	spanmetrics:
+		metrics_exporter:
+			endpoint: "0.0.0.0:8889"
+		`)
+		require.NoError(t, ValidateConfig(cfg))
+		require.NotContains(t, cfg.Configs[0].Receivers, "noop", "validation should not modify the config")
+	})
+
+	t.Run("invalid instance", func(t *testing.T) {
+		cfg := loadConfig(`
+configs:
+- name: default
+  receivers:
+		jaeger:
+			protocols:
+				grpc:
+		`)
+		require.EqualError(t, ValidateConfig(cfg), "invalid tempo config default: must have a configured a backend endpoint")
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		cfg := Config{Configs: []InstanceConfig{{Name: "a"}, {Name: "a"}}}
+		require.EqualError(t, ValidateConfig(cfg), "found multiple tempo configs with name a")
+	})
+}
+
+func TestReceiverTLSConfig(t *testing.T) {
+	tt := []struct {
+		name        string
+		cfg         string
+		expectedErr string
+	}{
+		{
+			name: "server only",
+			cfg:  "cert_file: a.crt\nkey_file: a.key",
+		},
+		{
+			name: "mutual tls",
+			cfg:  "cert_file: a.crt\nkey_file: a.key\nclient_ca_file: ca.crt\nclient_auth: require_and_verify",
+		},
+		{
+			name:        "missing key",
+			cfg:         "cert_file: a.crt",
+			expectedErr: "receiver_tls requires both cert_file and key_file",
+		},
+		{
+			name:        "client ca without client auth",
+			cfg:         "cert_file: a.crt\nkey_file: a.key\nclient_ca_file: ca.crt",
+			expectedErr: "receiver_tls client_ca_file requires client_auth to be require_and_verify",
+		},
+		{
+			name:        "client auth without client ca",
+			cfg:         "cert_file: a.crt\nkey_file: a.key\nclient_auth: require_and_verify",
+			expectedErr: "receiver_tls client_auth require_and_verify requires client_ca_file",
+		},
+		{
+			name:        "unknown client auth",
+			cfg:         "cert_file: a.crt\nkey_file: a.key\nclient_auth: request",
+			expectedErr: "unsupported receiver_tls client_auth 'request', expected 'none' or 'require_and_verify'",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg ReceiverTLSConfig
+			err := yaml.Unmarshal([]byte(tc.cfg), &cfg)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
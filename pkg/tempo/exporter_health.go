@@ -0,0 +1,71 @@
+package tempo
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+)
+
+// exporterHealth exposes whether the remote_write exporters of an instance
+// accept spans as the agent_tempo_exporter_up metric.
+//
+// The metric is set from the outcome of the last call to ConsumeTraces of
+// each exporter. Exporters with a sending queue only fail once their queue is
+// full, which happens after the backend has been unreachable for a while.
+type exporterHealth struct {
+	up *prometheus.GaugeVec
+}
+
+func newExporterHealth() *exporterHealth {
+	return &exporterHealth{
+		up: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agent_tempo_exporter_up",
+			Help: "Set to 0 when the last spans sent to a remote_write exporter were rejected, 1 otherwise.",
+		}, []string{"exporter", "endpoint"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (h *exporterHealth) Describe(ch chan<- *prometheus.Desc) { h.up.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (h *exporterHealth) Collect(ch chan<- prometheus.Metric) { h.up.Collect(ch) }
+
+// Wrap returns a consumer sending spans to next, the exporter configured by
+// cfg, and setting its series to the outcome. The series starts at 1 until
+// spans are sent.
+func (h *exporterHealth) Wrap(cfg configmodels.Exporter, next consumer.TracesConsumer) consumer.TracesConsumer {
+	var endpoint string
+	if otlpCfg, ok := cfg.(*otlpexporter.Config); ok {
+		endpoint = otlpCfg.Endpoint
+	}
+
+	up := h.up.WithLabelValues(cfg.Name(), endpoint)
+	up.Set(1)
+	return &healthConsumer{next: next, up: up}
+}
+
+// Reset removes the series of the exporters previously passed to Wrap.
+// Consumers returned by Wrap don't update the metric anymore.
+func (h *exporterHealth) Reset() { h.up.Reset() }
+
+// healthConsumer sets up to the outcome of sending spans to next.
+type healthConsumer struct {
+	next consumer.TracesConsumer
+	up   prometheus.Gauge
+}
+
+// ConsumeTraces implements consumer.TracesConsumer.
+func (c *healthConsumer) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	err := c.next.ConsumeTraces(ctx, td)
+	if err != nil {
+		c.up.Set(0)
+	} else {
+		c.up.Set(1)
+	}
+	return err
+}
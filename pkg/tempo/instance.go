@@ -10,6 +10,7 @@ import (
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.opencensus.io/stats/view"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 
 	"go.opentelemetry.io/collector/component"
@@ -19,28 +20,59 @@ import (
 
 // Instance wraps the OpenTelemetry collector to enable tracing pipelines
 type Instance struct {
-	mut         sync.Mutex
-	cfg         InstanceConfig
-	logger      *zap.Logger
-	metricViews []*view.View
+	mut            sync.Mutex
+	cfg            InstanceConfig
+	logger         *zap.Logger
+	metricExporter view.Exporter
+	spanMetrics    *spanMetricsCollector
+
+	// views are the views registered for the metrics level of the instance.
+	views []*view.View
 
 	exporter  builder.Exporters
 	pipelines builder.BuiltPipelines
 	receivers builder.Receivers
+
+	// remoteWrite holds the exporters of the traces pipeline, which receive
+	// spans through swap. They can be replaced while the rest of the pipeline
+	// keeps running.
+	remoteWrite builder.Exporters
+	swap        *swapConsumer
+
+	// exporterHealth exposes whether the remote_write exporters accept
+	// spans.
+	exporterHealth *exporterHealth
+
+	// accepting is set while the receivers are running. It's not guarded by
+	// mut so it can be checked while the instance is being drained.
+	accepting atomic.Bool
 }
 
 // NewInstance creates and starts an instance of tracing pipelines.
 func NewInstance(reg prometheus.Registerer, cfg InstanceConfig, logger *zap.Logger) (*Instance, error) {
 	var err error
 
-	instance := &Instance{}
+	instance := &Instance{swap: &swapConsumer{}}
 	instance.logger = logger
-	instance.metricViews, err = newMetricViews(reg)
+	instance.metricExporter, err = newMetricExporter(reg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create metric views: %w", err)
+		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+
+	instance.spanMetrics = newSpanMetricsCollector()
+	if err := reg.Register(instance.spanMetrics); err != nil {
+		view.UnregisterExporter(instance.metricExporter)
+		return nil, fmt.Errorf("failed to register span metrics: %w", err)
+	}
+
+	instance.exporterHealth = newExporterHealth()
+	if err := reg.Register(instance.exporterHealth); err != nil {
+		view.UnregisterExporter(instance.metricExporter)
+		return nil, fmt.Errorf("failed to register exporter metrics: %w", err)
 	}
 
 	if err := instance.ApplyConfig(cfg); err != nil {
+		instance.Stop()
 		return nil, err
 	}
 	return instance, nil
@@ -55,76 +87,244 @@ func (i *Instance) ApplyConfig(cfg InstanceConfig) error {
 		// No config change
 		return nil
 	}
-	i.cfg = cfg
 
-	// Shut down any existing pipeline
-	i.stop()
+	if err := i.applyMetricsLevel(cfg); err != nil {
+		return err
+	}
+
+	// A change of metrics level alone doesn't affect the pipeline.
+	if i.receivers != nil && util.CompareYAML(cfg.withMetricsLevel(i.cfg.MetricsLevel), i.cfg) {
+		i.cfg = cfg
+		return nil
+	}
 
 	createCtx := context.Background()
+
+	// When only the exporters changed, they're replaced without touching the
+	// receivers, which keep accepting spans in the meantime.
+	if i.receivers != nil && util.CompareYAML(cfg.withoutExporters(), i.cfg.withoutExporters()) {
+		if err := i.replaceRemoteWrite(createCtx, cfg); err != nil {
+			return fmt.Errorf("failed to replace exporters: %w", err)
+		}
+		i.cfg = cfg
+		return nil
+	}
+
+	// Shut down any existing pipeline, using the shutdown timeout of the
+	// config it was built from.
+	i.stop()
+	i.cfg = cfg
+
 	err := i.buildAndStartPipeline(createCtx, cfg)
 	if err != nil {
+		// Shut down what was started so the next config is built from scratch.
+		i.stop()
 		return fmt.Errorf("failed to create pipeline: %w", err)
 	}
 
 	return nil
 }
 
+// EffectiveConfig returns the config the instance is running with the
+// defaults applied by this package set explicitly, including those the config
+// left unset.
+func (i *Instance) EffectiveConfig() InstanceConfig {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.cfg.withDefaults()
+}
+
+// applyMetricsLevel registers the views enabled by the metrics level of cfg
+// and releases the views of the previous level. The views of both levels are
+// registered in between, so views enabled by both keep their data.
+func (i *Instance) applyMetricsLevel(cfg InstanceConfig) error {
+	level, err := cfg.metricsLevel()
+	if err != nil {
+		return err
+	}
+
+	views := levelViews(level)
+	if err := sharedViews.acquire(views); err != nil {
+		return fmt.Errorf("failed to register metric views: %w", err)
+	}
+	sharedViews.release(i.views)
+	i.views = views
+	return nil
+}
+
+// replaceRemoteWrite starts the remote_write exporters of cfg, switches the
+// traces pipeline over to them and then shuts down the old exporters. The old
+// exporters are kept if the new ones fail to start.
+func (i *Instance) replaceRemoteWrite(ctx context.Context, cfg InstanceConfig) error {
+	otelConfig, err := cfg.otelConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load otelConfig from agent tempo config: %w", err)
+	}
+	_, remoteWriteConfig := splitRemoteWrite(otelConfig)
+
+	factories, err := tracingFactories()
+	if err != nil {
+		return fmt.Errorf("failed to load tracing factories: %w", err)
+	}
+
+	exps, err := builder.NewExportersBuilder(i.logger, appInfo(), remoteWriteConfig, factories.Exporters).Build()
+	if err != nil {
+		return fmt.Errorf("failed to create exporters builder: %w", err)
+	}
+	if err := exps.StartAll(ctx, i); err != nil {
+		i.shutdownExporters(exps)
+		return fmt.Errorf("failed to start exporters: %w", err)
+	}
+
+	old := i.remoteWrite
+	i.remoteWrite = exps
+	i.swap.Swap(fanoutRemoteWrite(exps.ToMapByDataType()[configmodels.TracesDataType], i.exporterHealth))
+
+	i.logger.Info("replaced exporters of the traces pipeline")
+	i.shutdownExporters(old)
+	return nil
+}
+
+// shutdownExporters shuts down exps, abandoning them if they don't shut down
+// within the shutdown timeout of the instance.
+func (i *Instance) shutdownExporters(exps builder.Exporters) {
+	timeout := i.cfg.shutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := waitShutdown(ctx, func() error { return exps.ShutdownAll(ctx) })
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		i.logger.Warn("timed out shutting down old exporters, abandoning them", zap.Duration("timeout", timeout), zap.Error(err))
+	default:
+		i.logger.Error("failed to shutdown old exporters", zap.Error(err))
+	}
+}
+
+// Accepting returns whether the receivers of the instance are running and
+// accepting spans. It's false while the instance is being drained or its
+// pipeline is being rebuilt for a new config, and never blocks on either.
+func (i *Instance) Accepting() bool {
+	return i.accepting.Load()
+}
+
 // Stop stops the OpenTelemetry collector subsystem
 func (i *Instance) Stop() {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
 	i.stop()
-	view.Unregister(i.metricViews...)
+	view.UnregisterExporter(i.metricExporter)
+	sharedViews.release(i.views)
+	i.views = nil
 }
 
+// stop drains and shuts down the pipeline in stages: the receivers stop
+// accepting spans first, then the processors flush the spans they hold to the
+// exporters, which are shut down last so they can export them.
 func (i *Instance) stop() {
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	i.accepting.Store(false)
 
-	dependencies := []struct {
-		name     string
-		shutdown func() error
-	}{
+	stages := []shutdownStage{
 		{
 			name: "receiver",
-			shutdown: func() error {
+			shutdown: func(ctx context.Context) error {
 				if i.receivers == nil {
 					return nil
 				}
-				return i.receivers.ShutdownAll(shutdownCtx)
+				return i.receivers.ShutdownAll(ctx)
 			},
 		},
 		{
 			name: "processors",
-			shutdown: func() error {
+			shutdown: func(ctx context.Context) error {
 				if i.pipelines == nil {
 					return nil
 				}
-				return i.pipelines.ShutdownProcessors(shutdownCtx)
+				return i.pipelines.ShutdownProcessors(ctx)
 			},
 		},
 		{
 			name: "exporters",
-			shutdown: func() error {
+			shutdown: func(ctx context.Context) error {
 				if i.exporter == nil {
 					return nil
 				}
-				return i.exporter.ShutdownAll(shutdownCtx)
+				return i.exporter.ShutdownAll(ctx)
+			},
+		},
+		{
+			name: "remote_write exporters",
+			shutdown: func(ctx context.Context) error {
+				if i.remoteWrite == nil {
+					return nil
+				}
+				return i.remoteWrite.ShutdownAll(ctx)
 			},
 		},
 	}
-
-	for _, dep := range dependencies {
-		i.logger.Info(fmt.Sprintf("shutting down %s", dep.name))
-		if err := dep.shutdown(); err != nil {
-			i.logger.Error(fmt.Sprintf("failed to shutdown %s", dep.name), zap.Error(err))
-		}
-	}
+	runShutdownStages(i.logger, stages, i.cfg.shutdownTimeout(), i.cfg.ShutdownStageTimeout)
 
 	i.receivers = nil
 	i.pipelines = nil
 	i.exporter = nil
+	i.remoteWrite = nil
+	i.swap.Swap(nil)
+	i.exporterHealth.Reset()
+}
+
+// shutdownStage is a step of the shutdown of a pipeline.
+type shutdownStage struct {
+	name     string
+	shutdown func(ctx context.Context) error
+}
+
+// runShutdownStages runs stages in order, waiting for each one to finish
+// before starting the next. All stages must finish within timeout, and each
+// stage is also limited to stageTimeout if it's set. A stage which doesn't
+// finish in time is abandoned, and the following stages get the time left.
+func runShutdownStages(logger *zap.Logger, stages []shutdownStage, timeout, stageTimeout time.Duration) {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, stage := range stages {
+		logger.Info(fmt.Sprintf("shutting down %s", stage.name))
+
+		stageCtx, cancelStage := shutdownCtx, func() {}
+		if stageTimeout > 0 {
+			stageCtx, cancelStage = context.WithTimeout(shutdownCtx, stageTimeout)
+		}
+
+		shutdown := stage.shutdown
+		err := waitShutdown(stageCtx, func() error { return shutdown(stageCtx) })
+		switch {
+		case err == nil:
+		case shutdownCtx.Err() != nil:
+			logger.Warn(fmt.Sprintf("timed out shutting down %s, abandoning it", stage.name), zap.Duration("timeout", timeout), zap.Error(err))
+		case stageCtx.Err() != nil:
+			logger.Warn(fmt.Sprintf("timed out shutting down %s, abandoning it", stage.name), zap.Duration("stage_timeout", stageTimeout), zap.Error(err))
+		default:
+			logger.Error(fmt.Sprintf("failed to shutdown %s", stage.name), zap.Error(err))
+		}
+		cancelStage()
+	}
+}
+
+// waitShutdown calls shutdown and waits for it to return or for ctx to be
+// done, whichever happens first. Components aren't guaranteed to respect the
+// deadline of the context passed to their Shutdown, so shutdown is abandoned
+// once ctx is done, returning ctx.Err().
+func waitShutdown(ctx context.Context, shutdown func() error) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- shutdown() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig) error {
@@ -142,15 +342,26 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 		return fmt.Errorf("failed to load tracing factories: %w", err)
 	}
 
-	appinfo := component.ApplicationStartInfo{
-		ExeName:  "agent",
-		GitHash:  build.Revision,
-		LongName: "agent",
-		Version:  build.Version,
+	pipelinesConfig, remoteWriteConfig := splitRemoteWrite(otelConfig)
+	appinfo := appInfo()
+
+	// start the remote_write exporters, which the traces pipeline sends spans
+	// to through the swap exporter
+	i.remoteWrite, err = builder.NewExportersBuilder(i.logger, appinfo, remoteWriteConfig, factories.Exporters).Build()
+	if err != nil {
+		return fmt.Errorf("failed to create exporters builder: %w", err)
+	}
+
+	err = i.remoteWrite.StartAll(ctx, i)
+	if err != nil {
+		return fmt.Errorf("failed to start exporters: %w", err)
 	}
+	i.swap.Swap(fanoutRemoteWrite(i.remoteWrite.ToMapByDataType()[configmodels.TracesDataType], i.exporterHealth))
 
-	// start exporter
-	i.exporter, err = builder.NewExportersBuilder(i.logger, appinfo, otelConfig, factories.Exporters).Build()
+	// start the exporters of the pipelines
+	factories.Exporters[swapExporterType] = newSwapExporterFactory(i.swap)
+	factories.Exporters[registryExporterType] = newRegistryExporterFactory(i.spanMetrics)
+	i.exporter, err = builder.NewExportersBuilder(i.logger, appinfo, pipelinesConfig, factories.Exporters).Build()
 	if err != nil {
 		return fmt.Errorf("failed to create exporters builder: %w", err)
 	}
@@ -161,7 +372,7 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 	}
 
 	// start pipelines
-	i.pipelines, err = builder.NewPipelinesBuilder(i.logger, appinfo, otelConfig, i.exporter, factories.Processors).Build()
+	i.pipelines, err = builder.NewPipelinesBuilder(i.logger, appinfo, pipelinesConfig, i.exporter, factories.Processors).Build()
 	if err != nil {
 		return fmt.Errorf("failed to create pipelines builder: %w", err)
 	}
@@ -172,7 +383,7 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 	}
 
 	// start receivers
-	i.receivers, err = builder.NewReceiversBuilder(i.logger, appinfo, otelConfig, i.pipelines, factories.Receivers).Build()
+	i.receivers, err = builder.NewReceiversBuilder(i.logger, appinfo, pipelinesConfig, i.pipelines, factories.Receivers).Build()
 	if err != nil {
 		return fmt.Errorf("failed to create receivers builder: %w", err)
 	}
@@ -182,9 +393,19 @@ func (i *Instance) buildAndStartPipeline(ctx context.Context, cfg InstanceConfig
 		return fmt.Errorf("failed to start receivers: %w", err)
 	}
 
+	i.accepting.Store(true)
 	return nil
 }
 
+func appInfo() component.ApplicationStartInfo {
+	return component.ApplicationStartInfo{
+		ExeName:  "agent",
+		GitHash:  build.Revision,
+		LongName: "agent",
+		Version:  build.Version,
+	}
+}
+
 // ReportFatalError implements component.Host
 func (i *Instance) ReportFatalError(err error) {
 	i.logger.Error("fatal error reported", zap.Error(err))
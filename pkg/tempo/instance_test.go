@@ -0,0 +1,391 @@
+package tempo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/pkg/tempo/internal/tempoutils"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/obsreport"
+	"go.opentelemetry.io/collector/processor"
+	"go.opentelemetry.io/collector/processor/batchprocessor"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+func TestWaitShutdown(t *testing.T) {
+	t.Run("returns error of shutdown", func(t *testing.T) {
+		errShutdown := errors.New("shutdown failed")
+		err := waitShutdown(context.Background(), func() error { return errShutdown })
+		require.Equal(t, errShutdown, err)
+	})
+
+	t.Run("abandons hanging shutdown", func(t *testing.T) {
+		hang := make(chan struct{})
+		defer close(hang)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		done := make(chan error)
+		go func() {
+			done <- waitShutdown(ctx, func() error {
+				<-hang
+				return nil
+			})
+		}()
+
+		select {
+		case err := <-done:
+			require.Equal(t, context.DeadlineExceeded, err)
+		case <-time.After(time.Second):
+			require.FailNow(t, "waitShutdown did not return after its context expired")
+		}
+	})
+}
+
+func TestRunShutdownStages(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	// Each stage reports the error of its context once it's called.
+	stage := func(name string, wait bool, called chan<- error) shutdownStage {
+		return shutdownStage{
+			name: name,
+			shutdown: func(ctx context.Context) error {
+				called <- ctx.Err()
+				if wait {
+					<-hang
+				}
+				return nil
+			},
+		}
+	}
+
+	t.Run("runs stages in order", func(t *testing.T) {
+		receivers, exporters := make(chan error, 1), make(chan error, 1)
+		runShutdownStages(zap.NewNop(), []shutdownStage{
+			stage("receivers", false, receivers),
+			stage("exporters", false, exporters),
+		}, time.Second, 0)
+		require.NoError(t, <-receivers)
+		require.NoError(t, <-exporters)
+	})
+
+	t.Run("abandons hanging stage", func(t *testing.T) {
+		receivers, exporters := make(chan error, 1), make(chan error, 1)
+
+		start := time.Now()
+		runShutdownStages(zap.NewNop(), []shutdownStage{
+			stage("receivers", true, receivers),
+			stage("exporters", false, exporters),
+		}, time.Minute, 10*time.Millisecond)
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+		// The next stage still gets to run with a live context.
+		require.NoError(t, <-receivers)
+		require.NoError(t, <-exporters)
+	})
+}
+
+func TestInstanceConfig_ShutdownTimeout(t *testing.T) {
+	var c InstanceConfig
+	require.Equal(t, DefaultShutdownTimeout, c.shutdownTimeout())
+
+	c.ShutdownTimeout = time.Second
+	require.Equal(t, time.Second, c.shutdownTimeout())
+}
+
+func TestInstance_ApplyConfig_ReplaceExporters(t *testing.T) {
+	newServer := func() (string, chan pdata.Traces) {
+		ch := make(chan pdata.Traces, 10)
+		addr := tempoutils.NewTestServer(t, func(t pdata.Traces) { ch <- t })
+		return addr, ch
+	}
+	addrA, tracesA := newServer()
+	addrB, tracesB := newServer()
+
+	loadConfig := func(batchTimeout string, endpoints ...string) InstanceConfig {
+		var remoteWrite strings.Builder
+		for _, e := range endpoints {
+			fmt.Fprintf(&remoteWrite, "\t\t- endpoint: %s\n\t\t\tinsecure: true\n", e)
+		}
+
+		var cfg InstanceConfig
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+name: test
+receivers:
+	jaeger:
+		protocols:
+			thrift_compact:
+remote_write:
+%s
+batch:
+	timeout: %s
+	send_batch_size: 1
+		`, remoteWrite.String(), batchTimeout))))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&cfg))
+		return cfg
+	}
+
+	expectSpan := func(chs ...chan pdata.Traces) {
+		t.Helper()
+		span := testJaegerTracer(t).StartSpan("test-span")
+		span.Finish()
+		for _, ch := range chs {
+			select {
+			case <-time.After(30 * time.Second):
+				require.FailNow(t, "failed to receive a span after 30 seconds")
+			case <-ch:
+			}
+		}
+	}
+
+	inst, err := NewInstance(prometheus.NewRegistry(), loadConfig("100ms", addrA), zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(inst.Stop)
+
+	receivers := reflect.ValueOf(inst.receivers).Pointer()
+	expectSpan(tracesA)
+
+	// Adding an exporter keeps the receivers.
+	require.NoError(t, inst.ApplyConfig(loadConfig("100ms", addrA, addrB)))
+	require.Equal(t, receivers, reflect.ValueOf(inst.receivers).Pointer(), "receivers should not be rebuilt")
+	require.Len(t, inst.remoteWrite, 2)
+	expectSpan(tracesA, tracesB)
+
+	// Removing an exporter keeps the receivers.
+	require.NoError(t, inst.ApplyConfig(loadConfig("100ms", addrB)))
+	require.Equal(t, receivers, reflect.ValueOf(inst.receivers).Pointer(), "receivers should not be rebuilt")
+	require.Len(t, inst.remoteWrite, 1)
+	expectSpan(tracesB)
+
+	// Changing a processor rebuilds the whole pipeline.
+	require.NoError(t, inst.ApplyConfig(loadConfig("200ms", addrB)))
+	require.NotEqual(t, receivers, reflect.ValueOf(inst.receivers).Pointer(), "receivers should be rebuilt")
+	expectSpan(tracesB)
+}
+
+func TestInstance_SpanMetricsRegistry(t *testing.T) {
+	tracesAddr := tempoutils.NewTestServer(t, func(pdata.Traces) {})
+
+	var cfg InstanceConfig
+	dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+name: test
+receivers:
+	jaeger:
+		protocols:
+			thrift_compact:
+remote_write:
+	- endpoint: %s
+		insecure: true
+batch:
+	timeout: 100ms
+	send_batch_size: 1
+spanmetrics:
+	latency_histogram_buckets: [1ms, 10ms]
+	`, tracesAddr))))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	reg := prometheus.NewRegistry()
+	inst, err := NewInstance(reg, cfg, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(inst.Stop)
+
+	span := testJaegerTracer(t).StartSpan("test-span")
+	span.Finish()
+
+	require.Eventually(t, func() bool {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+
+		var calls, latency bool
+		for _, mf := range mfs {
+			switch mf.GetName() {
+			case "tempo_spanmetrics_calls_total":
+				calls = true
+			case "tempo_spanmetrics_latency":
+				// The configured buckets come first, followed by a catch-all
+				// bucket added by spanmetrics.
+				latency = len(mf.GetMetric()) > 0 && mf.GetMetric()[0].GetHistogram().GetBucket()[0].GetUpperBound() == 1
+			}
+		}
+		return calls && latency
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// Stopping the pipeline removes the series.
+	inst.Stop()
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	for _, mf := range mfs {
+		require.False(t, strings.HasPrefix(mf.GetName(), "tempo_spanmetrics_"), "unexpected metric %s", mf.GetName())
+	}
+}
+
+func TestInstance_ExporterUp(t *testing.T) {
+	srv, addr, err := tempoutils.NewServerWithRandomPort(func(pdata.Traces) {})
+	require.NoError(t, err)
+
+	var cfg InstanceConfig
+	dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+name: test
+receivers:
+	jaeger:
+		protocols:
+			thrift_compact:
+remote_write:
+	- endpoint: %s
+		insecure: true
+		sending_queue:
+			enabled: false
+		retry_on_failure:
+			enabled: false
+	`, addr))))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	reg := prometheus.NewRegistry()
+	inst, err := NewInstance(reg, cfg, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(inst.Stop)
+
+	send := func() error {
+		td := pdata.NewTraces()
+		td.ResourceSpans().Resize(1)
+		td.ResourceSpans().At(0).InstrumentationLibrarySpans().Resize(1)
+		td.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans().Resize(1)
+		return inst.swap.ConsumeTraces(context.Background(), td)
+	}
+
+	exporterUp := func() (float64, bool) {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range mfs {
+			if mf.GetName() != "agent_tempo_exporter_up" {
+				continue
+			}
+			require.Len(t, mf.GetMetric(), 1)
+			m := mf.GetMetric()[0]
+			require.Equal(t, map[string]string{"exporter": "otlp/0", "endpoint": addr}, labelMap(m.GetLabel()))
+			return m.GetGauge().GetValue(), true
+		}
+		return 0, false
+	}
+
+	require.NoError(t, send())
+	up, ok := exporterUp()
+	require.True(t, ok)
+	require.Equal(t, 1.0, up)
+
+	// The exporter is down once it fails to send spans.
+	require.NoError(t, srv.Stop())
+	require.Error(t, send())
+	up, ok = exporterUp()
+	require.True(t, ok)
+	require.Equal(t, 0.0, up)
+
+	// Stopping the pipeline removes the series.
+	inst.Stop()
+	_, ok = exporterUp()
+	require.False(t, ok)
+}
+
+func labelMap(pairs []*dto.LabelPair) map[string]string {
+	res := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		res[p.GetName()] = p.GetValue()
+	}
+	return res
+}
+
+func TestInstance_ApplyConfig_MetricsLevel(t *testing.T) {
+	tracesAddr := tempoutils.NewTestServer(t, func(pdata.Traces) {})
+
+	loadConfig := func(level string) InstanceConfig {
+		var cfg InstanceConfig
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+name: test
+metrics_level: %s
+receivers:
+	jaeger:
+		protocols:
+			thrift_compact:
+remote_write:
+	- endpoint: %s
+		insecure: true
+		`, level, tracesAddr))))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&cfg))
+		return cfg
+	}
+
+	var (
+		basicView    = obsreport.AllViews()[0].Name
+		normalView   = batchprocessor.MetricViews()[0].Name
+		detailedView = processor.MetricViews()[0].Name
+	)
+	requireViews := func(expect ...string) {
+		t.Helper()
+		for _, name := range []string{basicView, normalView, detailedView} {
+			var expected bool
+			for _, e := range expect {
+				expected = expected || e == name
+			}
+			require.Equal(t, expected, view.Find(name) != nil, "unexpected registration of view %s", name)
+		}
+	}
+
+	inst, err := NewInstance(prometheus.NewRegistry(), loadConfig("none"), zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(inst.Stop)
+	requireViews()
+
+	// Changing the metrics level updates the views without rebuilding the
+	// pipeline.
+	receivers := reflect.ValueOf(inst.receivers).Pointer()
+	require.NoError(t, inst.ApplyConfig(loadConfig("detailed")))
+	requireViews(basicView, normalView, detailedView)
+	require.NoError(t, inst.ApplyConfig(loadConfig("basic")))
+	requireViews(basicView)
+	require.Equal(t, receivers, reflect.ValueOf(inst.receivers).Pointer(), "receivers should not be rebuilt")
+
+	require.EqualError(t, inst.ApplyConfig(loadConfig("verbose")), `invalid metrics_level: unknown metrics level "verbose"`)
+	requireViews(basicView)
+
+	inst.Stop()
+	requireViews()
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	require.Equal(t, "service_name", sanitizeLabelName("service.name"))
+	require.Equal(t, "http_status_code", sanitizeLabelName("http.status-code"))
+}
+
+func TestSwapConsumer(t *testing.T) {
+	var c swapConsumer
+	require.Equal(t, errNoRemoteWrite, c.ConsumeTraces(context.Background(), pdata.NewTraces()))
+
+	var consumed int
+	c.Swap(consumerFunc(func(context.Context, pdata.Traces) error {
+		consumed++
+		return nil
+	}))
+	require.NoError(t, c.ConsumeTraces(context.Background(), pdata.NewTraces()))
+	require.Equal(t, 1, consumed)
+}
+
+type consumerFunc func(context.Context, pdata.Traces) error
+
+func (f consumerFunc) ConsumeTraces(ctx context.Context, td pdata.Traces) error { return f(ctx, td) }
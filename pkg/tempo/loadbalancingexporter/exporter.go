@@ -0,0 +1,271 @@
+package loadbalancingexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+	"go.opentelemetry.io/collector/translator/conventions"
+	"go.uber.org/zap"
+)
+
+var errNoBackends = errors.New("no backends are available to send spans to")
+
+// backendFactory creates the exporter sending spans to endpoint.
+type backendFactory func(ctx context.Context, endpoint string) (component.TracesExporter, error)
+
+// backend is an exporter sending spans to one endpoint.
+type backend struct {
+	component.TracesExporter
+
+	// inflight counts the calls to ConsumeTraces which may still send spans
+	// to the backend. It must only be incremented while holding the read
+	// lock of the exporter which routes to the backend.
+	inflight sync.WaitGroup
+}
+
+// shutdown waits for the in-flight sends to the backend to finish and shuts
+// it down. The backend must not be routed to anymore.
+func (b *backend) shutdown(ctx context.Context) error {
+	b.inflight.Wait()
+	return b.Shutdown(ctx)
+}
+
+// exporter routes spans to backends. Backends are created when the resolver
+// finds them and shut down once they disappear.
+type exporter struct {
+	cfg        *Config
+	logger     *zap.Logger
+	resolver   resolver
+	newBackend backendFactory
+
+	host component.Host
+
+	mut       sync.RWMutex
+	endpoints []string
+	backends  map[string]*backend
+}
+
+func newExporter(params component.ExporterCreateParams, cfg *Config) (*exporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	otlpFactory := otlpexporter.NewFactory()
+	newBackend := func(ctx context.Context, endpoint string) (component.TracesExporter, error) {
+		otlpCfg := cfg.Protocol.OTLP
+		otlpCfg.TypeVal = otlpFactory.Type()
+		otlpCfg.NameVal = string(otlpFactory.Type()) + "/" + endpoint
+		otlpCfg.Endpoint = endpoint
+		return otlpFactory.CreateTracesExporter(ctx, params, &otlpCfg)
+	}
+
+	return &exporter{
+		cfg:        cfg,
+		logger:     params.Logger,
+		resolver:   newResolver(params.Logger, cfg.Resolver),
+		newBackend: newBackend,
+		backends:   make(map[string]*backend),
+	}, nil
+}
+
+// Start implements component.Component.
+func (e *exporter) Start(ctx context.Context, host component.Host) error {
+	e.mut.Lock()
+	e.host = host
+	e.mut.Unlock()
+
+	return e.resolver.Start(ctx, e.onEndpoints)
+}
+
+// onEndpoints is called by the resolver whenever the set of endpoints
+// changes. Backends are created for new endpoints before the endpoints are
+// used to route spans, and removed backends are shut down afterwards, once
+// the spans already routed to them are sent.
+func (e *exporter) onEndpoints(ctx context.Context, endpoints []string) {
+	e.mut.RLock()
+	var (
+		host    = e.host
+		current = make(map[string]*backend, len(e.backends))
+	)
+	for ep, b := range e.backends {
+		current[ep] = b
+	}
+	e.mut.RUnlock()
+
+	var (
+		backends = make(map[string]*backend, len(endpoints))
+		routed   = make([]string, 0, len(endpoints))
+	)
+	for _, ep := range endpoints {
+		if b, ok := current[ep]; ok {
+			backends[ep] = b
+			routed = append(routed, ep)
+			delete(current, ep)
+			continue
+		}
+
+		exp, err := e.newBackend(ctx, ep)
+		if err == nil {
+			err = exp.Start(ctx, host)
+		}
+		if err != nil {
+			e.logger.Error("failed to create backend", zap.String("endpoint", ep), zap.Error(err))
+			continue
+		}
+		e.logger.Debug("added backend", zap.String("endpoint", ep))
+		backends[ep] = &backend{TracesExporter: exp}
+		routed = append(routed, ep)
+	}
+	sort.Strings(routed)
+
+	e.mut.Lock()
+	e.endpoints = routed
+	e.backends = backends
+	e.mut.Unlock()
+
+	for ep, b := range current {
+		e.logger.Debug("removing backend", zap.String("endpoint", ep))
+		if err := b.shutdown(ctx); err != nil {
+			e.logger.Error("failed to shut down backend", zap.String("endpoint", ep), zap.Error(err))
+		}
+	}
+}
+
+// Shutdown implements component.Component.
+func (e *exporter) Shutdown(ctx context.Context) error {
+	e.resolver.Shutdown()
+
+	e.mut.Lock()
+	backends := e.backends
+	e.endpoints = nil
+	e.backends = make(map[string]*backend)
+	e.mut.Unlock()
+
+	var errs []error
+	for _, b := range backends {
+		if err := b.shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return consumererror.CombineErrors(errs)
+}
+
+// ConsumeTraces implements consumer.TracesConsumer. Spans are split into one
+// batch per backend, keeping their resource and instrumentation library.
+func (e *exporter) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	// The backends are shut down once removed, so they're held until the
+	// spans are sent.
+	e.mut.RLock()
+	var (
+		endpoints = e.endpoints
+		backends  = e.backends
+	)
+	for _, b := range backends {
+		b.inflight.Add(1)
+	}
+	e.mut.RUnlock()
+	defer func() {
+		for _, b := range backends {
+			b.inflight.Done()
+		}
+	}()
+
+	if len(endpoints) == 0 {
+		return errNoBackends
+	}
+
+	batches := make(map[string]pdata.Traces)
+
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+
+		var service string
+		if e.cfg.RoutingKey == RoutingKeyService {
+			if v, ok := rs.Resource().Attributes().Get(conventions.AttributeServiceName); ok {
+				service = v.StringVal()
+			}
+		}
+
+		rsBatches := make(map[string]pdata.ResourceSpans)
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			ils := ilss.At(j)
+			ilsBatches := make(map[string]pdata.InstrumentationLibrarySpans)
+
+			spans := ils.Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+
+				var key []byte
+				if e.cfg.RoutingKey == RoutingKeyService {
+					key = []byte(service)
+				} else {
+					id := span.TraceID().Bytes()
+					key = id[:]
+				}
+				ep := route(key, endpoints)
+
+				dst, ok := ilsBatches[ep]
+				if !ok {
+					rsDst, ok := rsBatches[ep]
+					if !ok {
+						batch, ok := batches[ep]
+						if !ok {
+							batch = pdata.NewTraces()
+							batches[ep] = batch
+						}
+						rsDst = pdata.NewResourceSpans()
+						rs.Resource().CopyTo(rsDst.Resource())
+						batch.ResourceSpans().Append(rsDst)
+						rsBatches[ep] = rsDst
+					}
+
+					dst = pdata.NewInstrumentationLibrarySpans()
+					ils.InstrumentationLibrary().CopyTo(dst.InstrumentationLibrary())
+					rsDst.InstrumentationLibrarySpans().Append(dst)
+					ilsBatches[ep] = dst
+				}
+
+				copied := pdata.NewSpan()
+				span.CopyTo(copied)
+				dst.Spans().Append(copied)
+			}
+		}
+	}
+
+	var errs []error
+	for ep, batch := range batches {
+		if err := backends[ep].ConsumeTraces(ctx, batch); err != nil {
+			errs = append(errs, fmt.Errorf("failed to send spans to %s: %w", ep, err))
+		}
+	}
+	return consumererror.CombineErrors(errs)
+}
+
+// route picks the endpoint for key using rendezvous hashing, so that only
+// the keys of an endpoint are moved when it's added or removed.
+func route(key []byte, endpoints []string) string {
+	var (
+		best      string
+		bestScore uint64
+	)
+	for _, ep := range endpoints {
+		h := fnv.New64a()
+		_, _ = h.Write(key)
+		_, _ = h.Write([]byte(ep))
+		if score := h.Sum64(); best == "" || score > bestScore {
+			best, bestScore = ep, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,345 @@
+package loadbalancingexporter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/translator/conventions"
+	"go.uber.org/zap"
+)
+
+func TestExporter_RoutesByTraceID(t *testing.T) {
+	e, backends := newTestExporter(t, &Config{
+		Resolver:   ResolverSettings{Static: &StaticResolver{Hostnames: []string{"a:1", "b:1", "c"}}},
+		RoutingKey: RoutingKeyTraceID,
+	})
+	require.Equal(t, []string{"a:1", "b:1", "c:" + DefaultPort}, e.currentEndpoints())
+
+	// Send every trace twice, in different batches, to check that all spans
+	// of a trace end up on the same backend.
+	for i := 0; i < 2; i++ {
+		td := pdata.NewTraces()
+		for id := byte(0); id < 50; id++ {
+			appendSpan(td, "svc", id)
+		}
+		require.NoError(t, e.ConsumeTraces(context.Background(), td))
+	}
+
+	owners := make(map[[16]byte]string)
+	total := 0
+	for ep, b := range backends.all() {
+		for _, id := range b.traceIDs() {
+			if owner, ok := owners[id]; ok {
+				require.Equal(t, owner, ep, "trace was sent to multiple backends")
+			}
+			owners[id] = ep
+			total++
+		}
+	}
+	require.Equal(t, 100, total)
+	require.Len(t, owners, 50)
+}
+
+func TestExporter_RoutesByService(t *testing.T) {
+	e, backends := newTestExporter(t, &Config{
+		Resolver:   ResolverSettings{Static: &StaticResolver{Hostnames: []string{"a:1", "b:1", "c:1"}}},
+		RoutingKey: RoutingKeyService,
+	})
+
+	td := pdata.NewTraces()
+	for id := byte(0); id < 20; id++ {
+		appendSpan(td, "svc", id)
+	}
+	require.NoError(t, e.ConsumeTraces(context.Background(), td))
+
+	var received []string
+	for ep, b := range backends.all() {
+		if len(b.traceIDs()) > 0 {
+			received = append(received, ep)
+		}
+	}
+	require.Len(t, received, 1)
+	require.Len(t, backends.get(received[0]).traceIDs(), 20)
+}
+
+func TestExporter_DNSResolver(t *testing.T) {
+	var (
+		mut   sync.Mutex
+		addrs = []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("10.0.0.2")}}
+	)
+	setAddrs := func(ips ...string) {
+		mut.Lock()
+		defer mut.Unlock()
+		addrs = nil
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+	}
+
+	cfg := &Config{
+		Resolver:   ResolverSettings{DNS: &DNSResolver{Hostname: "tempo", Port: "4317", Interval: 10 * time.Millisecond}},
+		RoutingKey: RoutingKeyTraceID,
+	}
+	e, backends := newTestExporterWith(t, cfg, func(r resolver) {
+		r.(*dnsResolver).lookupIPAddr = func(context.Context, string) ([]net.IPAddr, error) {
+			mut.Lock()
+			defer mut.Unlock()
+			return append([]net.IPAddr(nil), addrs...), nil
+		}
+	})
+
+	require.Equal(t, []string{"10.0.0.1:4317", "10.0.0.2:4317"}, e.currentEndpoints())
+
+	setAddrs("10.0.0.2", "10.0.0.3")
+	require.Eventually(t, func() bool {
+		eps := e.currentEndpoints()
+		return len(eps) == 2 && eps[0] == "10.0.0.2:4317" && eps[1] == "10.0.0.3:4317"
+	}, time.Second, 10*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return backends.get("10.0.0.1:4317").isStopped()
+	}, time.Second, 10*time.Millisecond)
+	require.False(t, backends.get("10.0.0.2:4317").isStopped())
+}
+
+func TestExporter_RemovedBackendsDrained(t *testing.T) {
+	e, _ := newTestExporter(t, &Config{
+		Resolver:   ResolverSettings{Static: &StaticResolver{Hostnames: []string{"a:1"}}},
+		RoutingKey: RoutingKeyTraceID,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				e.onEndpoints(context.Background(), []string{"b:1"})
+			} else {
+				e.onEndpoints(context.Background(), []string{"a:1"})
+			}
+		}
+	}()
+
+	// Spans must never be sent to a backend after it was shut down.
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		td := pdata.NewTraces()
+		appendSpan(td, "svc", 1)
+		require.NoError(t, e.ConsumeTraces(context.Background(), td))
+	}
+}
+
+func TestExporter_NoBackends(t *testing.T) {
+	e, _ := newTestExporter(t, &Config{
+		Resolver:   ResolverSettings{Static: &StaticResolver{Hostnames: []string{"a:1"}}},
+		RoutingKey: RoutingKeyTraceID,
+	})
+	require.NoError(t, e.Shutdown(context.Background()))
+
+	td := pdata.NewTraces()
+	appendSpan(td, "svc", 1)
+	require.Equal(t, errNoBackends, e.ConsumeTraces(context.Background(), td))
+}
+
+func TestFactory_OTLPBackends(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Resolver.Static = &StaticResolver{Hostnames: []string{"127.0.0.1:1", "127.0.0.1:2"}}
+	cfg.Protocol.OTLP.TLSSetting.Insecure = true
+
+	exp, err := NewFactory().CreateTracesExporter(context.Background(), component.ExporterCreateParams{Logger: zap.NewNop()}, cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(context.Background(), nil))
+	defer func() { require.NoError(t, exp.Shutdown(context.Background())) }()
+
+	e := exp.(*exporter)
+	require.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, e.currentEndpoints())
+	require.Len(t, e.backends, 2)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	static := &StaticResolver{Hostnames: []string{"a:1"}}
+
+	tt := []struct {
+		name        string
+		cfg         Config
+		expectedErr string
+	}{
+		{
+			name: "static",
+			cfg:  Config{Resolver: ResolverSettings{Static: static}, RoutingKey: RoutingKeyTraceID},
+		},
+		{
+			name:        "no resolver",
+			cfg:         Config{RoutingKey: RoutingKeyTraceID},
+			expectedErr: "no resolver configured",
+		},
+		{
+			name:        "both resolvers",
+			cfg:         Config{Resolver: ResolverSettings{Static: static, DNS: &DNSResolver{Hostname: "a"}}, RoutingKey: RoutingKeyTraceID},
+			expectedErr: "only one of the static and dns resolvers may be configured",
+		},
+		{
+			name:        "no hostnames",
+			cfg:         Config{Resolver: ResolverSettings{Static: &StaticResolver{}}, RoutingKey: RoutingKeyTraceID},
+			expectedErr: "the static resolver requires at least one hostname",
+		},
+		{
+			name:        "no dns hostname",
+			cfg:         Config{Resolver: ResolverSettings{DNS: &DNSResolver{}}, RoutingKey: RoutingKeyTraceID},
+			expectedErr: "the dns resolver requires a hostname",
+		},
+		{
+			name:        "unknown routing key",
+			cfg:         Config{Resolver: ResolverSettings{Static: static}, RoutingKey: "span"},
+			expectedErr: "unsupported routing_key 'span', expected 'traceID' or 'service'",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func newTestExporter(t *testing.T, cfg *Config) (*exporter, *testBackends) {
+	return newTestExporterWith(t, cfg, func(resolver) {})
+}
+
+// newTestExporterWith creates and starts an exporter sending spans to test
+// backends. configure is called with the resolver before the exporter is
+// started.
+func newTestExporterWith(t *testing.T, cfg *Config, configure func(resolver)) (*exporter, *testBackends) {
+	t.Helper()
+
+	e, err := newExporter(component.ExporterCreateParams{Logger: zap.NewNop()}, cfg)
+	require.NoError(t, err)
+
+	backends := &testBackends{backends: make(map[string]*testBackend)}
+	e.newBackend = backends.create
+	configure(e.resolver)
+
+	require.NoError(t, e.Start(context.Background(), nil))
+	t.Cleanup(func() { _ = e.Shutdown(context.Background()) })
+	return e, backends
+}
+
+func (e *exporter) currentEndpoints() []string {
+	e.mut.RLock()
+	defer e.mut.RUnlock()
+	return e.endpoints
+}
+
+// appendSpan adds a span with the given trace ID to td, in a new resource
+// for service.
+func appendSpan(td pdata.Traces, service string, id byte) {
+	rs := pdata.NewResourceSpans()
+	rs.Resource().Attributes().InsertString(conventions.AttributeServiceName, service)
+
+	ils := pdata.NewInstrumentationLibrarySpans()
+	span := pdata.NewSpan()
+	span.SetTraceID(pdata.NewTraceID([16]byte{id}))
+	ils.Spans().Append(span)
+	rs.InstrumentationLibrarySpans().Append(ils)
+
+	td.ResourceSpans().Append(rs)
+}
+
+type testBackends struct {
+	mut      sync.Mutex
+	backends map[string]*testBackend
+}
+
+func (b *testBackends) create(_ context.Context, endpoint string) (component.TracesExporter, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	backend := &testBackend{}
+	b.backends[endpoint] = backend
+	return backend, nil
+}
+
+func (b *testBackends) get(endpoint string) *testBackend {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.backends[endpoint]
+}
+
+func (b *testBackends) all() map[string]*testBackend {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	all := make(map[string]*testBackend, len(b.backends))
+	for ep, backend := range b.backends {
+		all[ep] = backend
+	}
+	return all
+}
+
+type testBackend struct {
+	mut     sync.Mutex
+	stopped bool
+	traces  []pdata.Traces
+}
+
+func (b *testBackend) Start(context.Context, component.Host) error { return nil }
+
+func (b *testBackend) Shutdown(context.Context) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.stopped = true
+	return nil
+}
+
+func (b *testBackend) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.stopped {
+		return errors.New("backend is shut down")
+	}
+	b.traces = append(b.traces, td)
+	return nil
+}
+
+func (b *testBackend) isStopped() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.stopped
+}
+
+// traceIDs returns the trace ID of every span received by b.
+func (b *testBackend) traceIDs() [][16]byte {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	var ids [][16]byte
+	for _, td := range b.traces {
+		rss := td.ResourceSpans()
+		for i := 0; i < rss.Len(); i++ {
+			ilss := rss.At(i).InstrumentationLibrarySpans()
+			for j := 0; j < ilss.Len(); j++ {
+				spans := ilss.At(j).Spans()
+				for k := 0; k < spans.Len(); k++ {
+					ids = append(ids, spans.At(k).TraceID().Bytes())
+				}
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,131 @@
+// Package loadbalancingexporter implements an exporter which sends spans to
+// a set of OTLP backends, routing spans with the same routing key to the
+// same backend. When the routing key is the trace ID, every backend receives
+// whole traces, as required by tail-based sampling.
+package loadbalancingexporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+)
+
+// TypeStr is the unique identifier for the load balancing exporter.
+const TypeStr = "loadbalancing"
+
+// Supported routing keys.
+const (
+	RoutingKeyTraceID = "traceID"
+	RoutingKeyService = "service"
+)
+
+// Defaults of the resolvers. DefaultPort is used for hostnames without a
+// port.
+const (
+	DefaultPort        = "55680"
+	DefaultDNSInterval = 5 * time.Second
+	DefaultDNSTimeout  = time.Second
+)
+
+// Config holds the configuration for the load balancing exporter.
+type Config struct {
+	configmodels.ExporterSettings `mapstructure:",squash"`
+
+	// Protocol configures the exporters created for each backend. Their
+	// endpoint is set to the address of the backend.
+	Protocol Protocol `mapstructure:"protocol"`
+
+	// Resolver finds the backends to send spans to.
+	Resolver ResolverSettings `mapstructure:"resolver"`
+
+	// RoutingKey is either traceID or service.
+	RoutingKey string `mapstructure:"routing_key"`
+}
+
+// Validate checks that exactly one resolver is configured and that the
+// routing key is supported.
+func (c *Config) Validate() error {
+	switch c.RoutingKey {
+	case RoutingKeyTraceID, RoutingKeyService:
+	default:
+		return fmt.Errorf("unsupported routing_key '%s', expected '%s' or '%s'", c.RoutingKey, RoutingKeyTraceID, RoutingKeyService)
+	}
+
+	switch r := c.Resolver; {
+	case r.Static != nil && r.DNS != nil:
+		return errors.New("only one of the static and dns resolvers may be configured")
+	case r.Static != nil:
+		if len(r.Static.Hostnames) == 0 {
+			return errors.New("the static resolver requires at least one hostname")
+		}
+	case r.DNS != nil:
+		if r.DNS.Hostname == "" {
+			return errors.New("the dns resolver requires a hostname")
+		}
+	default:
+		return errors.New("no resolver configured")
+	}
+	return nil
+}
+
+// Protocol configures the exporters of the backends.
+type Protocol struct {
+	OTLP otlpexporter.Config `mapstructure:"otlp"`
+}
+
+// ResolverSettings configures how backends are found. Exactly one resolver
+// must be set.
+type ResolverSettings struct {
+	Static *StaticResolver `mapstructure:"static"`
+	DNS    *DNSResolver    `mapstructure:"dns"`
+}
+
+// StaticResolver uses a fixed list of backends.
+type StaticResolver struct {
+	Hostnames []string `mapstructure:"hostnames"`
+}
+
+// DNSResolver periodically resolves a hostname, using every IP address it
+// resolves to as a backend.
+type DNSResolver struct {
+	Hostname string        `mapstructure:"hostname"`
+	Port     string        `mapstructure:"port"`
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+// NewFactory returns a new factory for the load balancing exporter.
+func NewFactory() component.ExporterFactory {
+	return exporterhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		exporterhelper.WithTraces(createTraceExporter),
+	)
+}
+
+func createDefaultConfig() configmodels.Exporter {
+	otlpDefault := otlpexporter.NewFactory().CreateDefaultConfig().(*otlpexporter.Config)
+
+	return &Config{
+		ExporterSettings: configmodels.ExporterSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+		Protocol:   Protocol{OTLP: *otlpDefault},
+		RoutingKey: RoutingKeyTraceID,
+	}
+}
+
+func createTraceExporter(
+	_ context.Context,
+	params component.ExporterCreateParams,
+	cfg configmodels.Exporter,
+) (component.TracesExporter, error) {
+	return newExporter(params, cfg.(*Config))
+}
@@ -0,0 +1,157 @@
+package loadbalancingexporter
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// resolver finds the endpoints of the backends.
+type resolver interface {
+	// Start calls onChange with the initial endpoints, and again every time
+	// they change until Shutdown is called.
+	Start(ctx context.Context, onChange func(ctx context.Context, endpoints []string)) error
+	Shutdown()
+}
+
+// newResolver creates the resolver configured by s, which must have been
+// validated.
+func newResolver(l *zap.Logger, s ResolverSettings) resolver {
+	if s.Static != nil {
+		return &staticResolver{hostnames: s.Static.Hostnames}
+	}
+	return newDNSResolver(l, *s.DNS)
+}
+
+// withDefaultPort appends the default OTLP port to hostnames which don't
+// have one.
+func withDefaultPort(hostname string) string {
+	if _, _, err := net.SplitHostPort(hostname); err == nil {
+		return hostname
+	}
+	return net.JoinHostPort(hostname, DefaultPort)
+}
+
+type staticResolver struct {
+	hostnames []string
+}
+
+// Start implements resolver.
+func (r *staticResolver) Start(ctx context.Context, onChange func(context.Context, []string)) error {
+	endpoints := make([]string, 0, len(r.hostnames))
+	for _, h := range r.hostnames {
+		endpoints = append(endpoints, withDefaultPort(h))
+	}
+	onChange(ctx, endpoints)
+	return nil
+}
+
+// Shutdown implements resolver.
+func (r *staticResolver) Shutdown() {}
+
+type dnsResolver struct {
+	logger *zap.Logger
+	cfg    DNSResolver
+
+	// lookupIPAddr can be replaced by tests.
+	lookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// endpoints are the endpoints last passed to onChange.
+	endpoints []string
+}
+
+func newDNSResolver(l *zap.Logger, cfg DNSResolver) *dnsResolver {
+	if cfg.Port == "" {
+		cfg.Port = DefaultPort
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultDNSInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultDNSTimeout
+	}
+
+	return &dnsResolver{
+		logger:       l,
+		cfg:          cfg,
+		lookupIPAddr: net.DefaultResolver.LookupIPAddr,
+	}
+}
+
+// Start implements resolver. Failing to resolve the hostname is logged
+// rather than returned so that the exporter can start while the backends
+// are coming up.
+func (r *dnsResolver) Start(ctx context.Context, onChange func(context.Context, []string)) error {
+	r.resolve(ctx, onChange)
+
+	ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		t := time.NewTicker(r.cfg.Interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				r.resolve(ctx, onChange)
+			}
+		}
+	}()
+	return nil
+}
+
+// resolve looks up the hostname and calls onChange if the endpoints differ
+// from the last ones.
+func (r *dnsResolver) resolve(ctx context.Context, onChange func(context.Context, []string)) {
+	lookupCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	addrs, err := r.lookupIPAddr(lookupCtx, r.cfg.Hostname)
+	if err != nil {
+		r.logger.Warn("failed to resolve backends", zap.String("hostname", r.cfg.Hostname), zap.Error(err))
+		return
+	}
+
+	endpoints := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, net.JoinHostPort(addr.IP.String(), r.cfg.Port))
+	}
+	sort.Strings(endpoints)
+
+	if r.endpoints != nil && equalStrings(r.endpoints, endpoints) {
+		return
+	}
+	r.endpoints = endpoints
+	onChange(ctx, endpoints)
+}
+
+// Shutdown implements resolver.
+func (r *dnsResolver) Shutdown() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package tempo
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instanceMetrics is a prometheus.Collector which collects the metrics of
+// every Instance, labeled with the name of the instance's config. Removing an
+// Instance from instanceMetrics removes all of its series.
+//
+// instanceMetrics is needed because the collectors created for an Instance
+// have no fixed descriptors and so can't be unregistered from a
+// prometheus.Registry.
+type instanceMetrics struct {
+	mut        sync.RWMutex
+	collectors map[string][]prometheus.Collector
+}
+
+func newInstanceMetrics() *instanceMetrics {
+	return &instanceMetrics{collectors: make(map[string][]prometheus.Collector)}
+}
+
+// Registerer returns a prometheus.Registerer for the instance with the given
+// name. Collectors registered to it will be labeled with tempo_config.
+func (m *instanceMetrics) Registerer(name string) prometheus.Registerer {
+	return prometheus.WrapRegistererWith(
+		prometheus.Labels{"tempo_config": name},
+		&instanceRegisterer{m: m, name: name},
+	)
+}
+
+// Remove removes all collectors for the instance with the given name.
+func (m *instanceMetrics) Remove(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.collectors, name)
+}
+
+// Describe implements prometheus.Collector. No descriptors are sent, making
+// instanceMetrics an unchecked collector.
+func (m *instanceMetrics) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (m *instanceMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	for _, cs := range m.collectors {
+		for _, c := range cs {
+			c.Collect(ch)
+		}
+	}
+}
+
+// instanceRegisterer registers collectors for a single instance into
+// instanceMetrics.
+type instanceRegisterer struct {
+	m    *instanceMetrics
+	name string
+}
+
+// Register implements prometheus.Registerer.
+func (r *instanceRegisterer) Register(c prometheus.Collector) error {
+	r.m.mut.Lock()
+	defer r.m.mut.Unlock()
+	r.m.collectors[r.name] = append(r.m.collectors[r.name], c)
+	return nil
+}
+
+// MustRegister implements prometheus.Registerer.
+func (r *instanceRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		_ = r.Register(c)
+	}
+}
+
+// Unregister implements prometheus.Registerer. Individual collectors can't be
+// unregistered; use instanceMetrics.Remove to remove all collectors for an
+// instance instead.
+func (r *instanceRegisterer) Unregister(c prometheus.Collector) bool {
+	return false
+}
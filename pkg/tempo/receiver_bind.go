@@ -0,0 +1,136 @@
+package tempo
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/config/confighttp"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/receiver/jaegerreceiver"
+	"go.opentelemetry.io/collector/receiver/opencensusreceiver"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.opentelemetry.io/collector/receiver/zipkinreceiver"
+)
+
+// DefaultReceiverBindAddress is the address receivers listen on when
+// receiver_bind_addresses doesn't set one for their protocol.
+const DefaultReceiverBindAddress = "localhost"
+
+// Protocols which can be given a bind address in receiver_bind_addresses.
+const (
+	bindOTLPGRPC            = "otlp/grpc"
+	bindOTLPHTTP            = "otlp/http"
+	bindJaegerGRPC          = "jaeger/grpc"
+	bindJaegerThriftHTTP    = "jaeger/thrift_http"
+	bindJaegerThriftBinary  = "jaeger/thrift_binary"
+	bindJaegerThriftCompact = "jaeger/thrift_compact"
+	bindZipkin              = "zipkin"
+	bindOpenCensus          = "opencensus"
+)
+
+var bindProtocols = []string{
+	bindOTLPGRPC, bindOTLPHTTP,
+	bindJaegerGRPC, bindJaegerThriftHTTP, bindJaegerThriftBinary, bindJaegerThriftCompact,
+	bindZipkin, bindOpenCensus,
+}
+
+// validateReceiverBindAddresses checks that addrs only holds addresses for
+// known protocols.
+func validateReceiverBindAddresses(addrs map[string]string) error {
+	for proto, addr := range addrs {
+		if !isBindProtocol(proto) {
+			return fmt.Errorf("unsupported protocol '%s' in receiver_bind_addresses, expected one of %s", proto, strings.Join(bindProtocols, ", "))
+		}
+		if addr == "" {
+			return fmt.Errorf("receiver_bind_addresses: empty address for %s", proto)
+		}
+	}
+	return nil
+}
+
+func isBindProtocol(proto string) bool {
+	for _, p := range bindProtocols {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// applyReceiverBindAddresses makes the servers of receivers which would
+// listen on all interfaces listen on the address of their protocol in addrs
+// instead, or on DefaultReceiverBindAddress if addrs doesn't set one.
+// Endpoints which name a specific host are left untouched.
+func applyReceiverBindAddresses(receivers configmodels.Receivers, addrs map[string]string) error {
+	// Sort the receivers so errors are reported consistently.
+	names := make([]string, 0, len(receivers))
+	for name := range receivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var err error
+		bind := func(proto string, endpoint *string) {
+			if err != nil {
+				return
+			}
+			addr, ok := addrs[proto]
+			if !ok {
+				addr = DefaultReceiverBindAddress
+			}
+			if *endpoint, err = bindEndpoint(*endpoint, addr); err != nil {
+				err = fmt.Errorf("receiver %s: invalid %s endpoint: %w", name, proto, err)
+			}
+		}
+		bindGRPC := func(proto string, s *configgrpc.GRPCServerSettings) {
+			if s != nil {
+				bind(proto, &s.NetAddr.Endpoint)
+			}
+		}
+		bindHTTP := func(proto string, s *confighttp.HTTPServerSettings) {
+			if s != nil {
+				bind(proto, &s.Endpoint)
+			}
+		}
+
+		switch r := receivers[name].(type) {
+		case *otlpreceiver.Config:
+			bindGRPC(bindOTLPGRPC, r.GRPC)
+			bindHTTP(bindOTLPHTTP, r.HTTP)
+		case *jaegerreceiver.Config:
+			bindGRPC(bindJaegerGRPC, r.GRPC)
+			bindHTTP(bindJaegerThriftHTTP, r.ThriftHTTP)
+			if r.ThriftBinary != nil {
+				bind(bindJaegerThriftBinary, &r.ThriftBinary.Endpoint)
+			}
+			if r.ThriftCompact != nil {
+				bind(bindJaegerThriftCompact, &r.ThriftCompact.Endpoint)
+			}
+		case *zipkinreceiver.Config:
+			bindHTTP(bindZipkin, &r.HTTPServerSettings)
+		case *opencensusreceiver.Config:
+			bindGRPC(bindOpenCensus, &r.GRPCServerSettings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindEndpoint replaces the host of endpoint with addr when it doesn't name
+// a specific host.
+func bindEndpoint(endpoint, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return endpoint, nil
+	}
+	return net.JoinHostPort(addr, port), nil
+}
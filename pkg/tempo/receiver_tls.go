@@ -0,0 +1,118 @@
+package tempo
+
+import (
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/config/confighttp"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.opentelemetry.io/collector/receiver/jaegerreceiver"
+	"go.opentelemetry.io/collector/receiver/opencensusreceiver"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.opentelemetry.io/collector/receiver/zipkinreceiver"
+)
+
+const (
+	// ClientAuthNone doesn't request certificates from clients.
+	ClientAuthNone = "none"
+
+	// ClientAuthRequireAndVerify requires clients to present a certificate
+	// signed by the client CA.
+	ClientAuthRequireAndVerify = "require_and_verify"
+)
+
+// ReceiverTLSConfig configures TLS for all receivers of an instance. It
+// applies to every receiver protocol which doesn't set tls_settings itself.
+type ReceiverTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ClientCAFile is the CA used to verify client certificates. It must be
+	// set when ClientAuth is require_and_verify.
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+
+	// ClientAuth is either none or require_and_verify. Defaults to none.
+	ClientAuth string `yaml:"client_auth,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *ReceiverTLSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = ReceiverTLSConfig{ClientAuth: ClientAuthNone}
+
+	type plain ReceiverTLSConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that c can be used to serve TLS.
+func (c *ReceiverTLSConfig) Validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("receiver_tls requires both cert_file and key_file")
+	}
+
+	switch c.ClientAuth {
+	case "", ClientAuthNone:
+		// The collector verifies client certificates whenever it's given a
+		// client CA, so there's no way to use one without requiring them.
+		if c.ClientCAFile != "" {
+			return fmt.Errorf("receiver_tls client_ca_file requires client_auth to be %s", ClientAuthRequireAndVerify)
+		}
+	case ClientAuthRequireAndVerify:
+		if c.ClientCAFile == "" {
+			return fmt.Errorf("receiver_tls client_auth %s requires client_ca_file", ClientAuthRequireAndVerify)
+		}
+	default:
+		return fmt.Errorf("unsupported receiver_tls client_auth '%s', expected '%s' or '%s'", c.ClientAuth, ClientAuthNone, ClientAuthRequireAndVerify)
+	}
+	return nil
+}
+
+func (c *ReceiverTLSConfig) serverSetting() *configtls.TLSServerSetting {
+	return &configtls.TLSServerSetting{
+		TLSSetting: configtls.TLSSetting{
+			CertFile: c.CertFile,
+			KeyFile:  c.KeyFile,
+		},
+		ClientCAFile: c.ClientCAFile,
+	}
+}
+
+// applyReceiverTLS configures the servers of receivers to use tlsCfg.
+// Receivers with protocols which can't be served over TLS are rejected so
+// that they don't silently accept plaintext connections. Receivers which
+// don't run a server, such as kafka, are left untouched.
+func applyReceiverTLS(receivers configmodels.Receivers, tlsCfg *ReceiverTLSConfig) error {
+	setting := tlsCfg.serverSetting()
+	setGRPC := func(s *configgrpc.GRPCServerSettings) {
+		if s != nil && s.TLSSetting == nil {
+			s.TLSSetting = setting
+		}
+	}
+	setHTTP := func(s *confighttp.HTTPServerSettings) {
+		if s != nil && s.TLSSetting == nil {
+			s.TLSSetting = setting
+		}
+	}
+
+	for name, r := range receivers {
+		switch r := r.(type) {
+		case *otlpreceiver.Config:
+			setGRPC(r.GRPC)
+			setHTTP(r.HTTP)
+		case *opencensusreceiver.Config:
+			setGRPC(&r.GRPCServerSettings)
+		case *zipkinreceiver.Config:
+			setHTTP(&r.HTTPServerSettings)
+		case *jaegerreceiver.Config:
+			if r.ThriftHTTP != nil || r.ThriftBinary != nil || r.ThriftCompact != nil {
+				return fmt.Errorf("receiver %s: receiver_tls is only supported by the grpc protocol of jaeger", name)
+			}
+			setGRPC(r.GRPC)
+		}
+	}
+	return nil
+}
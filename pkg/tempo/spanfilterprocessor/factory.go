@@ -0,0 +1,87 @@
+package spanfilterprocessor
+
+import (
+	"context"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+)
+
+// TypeStr is the unique identifier for the span filter processor.
+const TypeStr = "span_filter"
+
+// Match types supported by MatchProperties.
+const (
+	MatchTypeStrict = "strict"
+	MatchTypeRegexp = "regexp"
+)
+
+// Config holds the configuration for the span filter processor. Spans are
+// kept if they match Include, when set, and don't match Exclude, when set.
+type Config struct {
+	configmodels.ProcessorSettings `mapstructure:",squash"`
+
+	Include *MatchProperties `mapstructure:"include"`
+	Exclude *MatchProperties `mapstructure:"exclude"`
+}
+
+// MatchProperties selects spans by name and attributes. A span matches if
+// its name matches one of SpanNames and it has all of Attributes. Either
+// list may be empty, but not both.
+type MatchProperties struct {
+	// MatchType is either strict or regexp. With regexp, span names and
+	// attribute values are Go regular expressions.
+	MatchType string `mapstructure:"match_type"`
+
+	SpanNames  []string    `mapstructure:"span_names"`
+	Attributes []Attribute `mapstructure:"attributes"`
+}
+
+// Attribute matches a span or resource attribute. Spans are looked up
+// before their resource. An empty Value only checks that the attribute is
+// set.
+type Attribute struct {
+	Key   string `mapstructure:"key"`
+	Value string `mapstructure:"value"`
+}
+
+// Validate checks that c can be used to filter spans.
+func (c *Config) Validate() error {
+	_, err := newSpanFilter(c)
+	return err
+}
+
+// NewFactory returns a new factory for the span filter processor.
+func NewFactory() component.ProcessorFactory {
+	return processorhelper.NewFactory(
+		TypeStr,
+		createDefaultConfig,
+		processorhelper.WithTraces(createTraceProcessor),
+	)
+}
+
+func createDefaultConfig() configmodels.Processor {
+	return &Config{
+		ProcessorSettings: configmodels.ProcessorSettings{
+			TypeVal: TypeStr,
+			NameVal: TypeStr,
+		},
+	}
+}
+
+func createTraceProcessor(
+	_ context.Context,
+	_ component.ProcessorCreateParams,
+	cfg configmodels.Processor,
+	nextConsumer consumer.TracesConsumer,
+) (component.TracesProcessor, error) {
+	oCfg := cfg.(*Config)
+
+	p, err := newSpanFilter(oCfg)
+	if err != nil {
+		return nil, err
+	}
+	return processorhelper.NewTraceProcessor(cfg, nextConsumer, p)
+}
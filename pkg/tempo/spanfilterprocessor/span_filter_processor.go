@@ -0,0 +1,161 @@
+package spanfilterprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go.opentelemetry.io/collector/consumer/pdata"
+	tracetranslator "go.opentelemetry.io/collector/translator/trace"
+)
+
+// spanFilter drops the spans rejected by its matchers.
+type spanFilter struct {
+	include, exclude *matcher
+}
+
+func newSpanFilter(cfg *Config) (*spanFilter, error) {
+	if cfg.Include == nil && cfg.Exclude == nil {
+		return nil, errors.New("span_filter requires include or exclude")
+	}
+
+	var (
+		f   spanFilter
+		err error
+	)
+	if cfg.Include != nil {
+		if f.include, err = newMatcher(cfg.Include); err != nil {
+			return nil, fmt.Errorf("invalid span_filter include: %w", err)
+		}
+	}
+	if cfg.Exclude != nil {
+		if f.exclude, err = newMatcher(cfg.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid span_filter exclude: %w", err)
+		}
+	}
+	return &f, nil
+}
+
+// ProcessTraces implements processorhelper.TProcessor.
+func (f *spanFilter) ProcessTraces(_ context.Context, td pdata.Traces) (pdata.Traces, error) {
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		rs := rss.At(i)
+		resource := rs.Resource().Attributes()
+
+		ilss := rs.InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+
+			kept := pdata.NewSpanSlice()
+			for k := 0; k < spans.Len(); k++ {
+				if span := spans.At(k); f.keep(span, resource) {
+					kept.Append(span)
+				}
+			}
+			if kept.Len() == spans.Len() {
+				continue
+			}
+			spans.Resize(0)
+			kept.MoveAndAppendTo(spans)
+		}
+	}
+	return td, nil
+}
+
+func (f *spanFilter) keep(span pdata.Span, resource pdata.AttributeMap) bool {
+	if f.include != nil && !f.include.matches(span, resource) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.matches(span, resource)
+}
+
+// matcher implements MatchProperties.
+type matcher struct {
+	names      []stringMatcher
+	attributes []attributeMatcher
+}
+
+type attributeMatcher struct {
+	key   string
+	value stringMatcher // nil to only check that key is set
+}
+
+type stringMatcher func(s string) bool
+
+func newMatcher(mp *MatchProperties) (*matcher, error) {
+	if len(mp.SpanNames) == 0 && len(mp.Attributes) == 0 {
+		return nil, errors.New("at least one of span_names or attributes must be set")
+	}
+
+	var newStringMatcher func(s string) (stringMatcher, error)
+	switch mp.MatchType {
+	case MatchTypeStrict:
+		newStringMatcher = func(s string) (stringMatcher, error) {
+			return func(v string) bool { return v == s }, nil
+		}
+	case MatchTypeRegexp:
+		newStringMatcher = func(s string) (stringMatcher, error) {
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported match_type '%s', expected '%s' or '%s'", mp.MatchType, MatchTypeStrict, MatchTypeRegexp)
+	}
+
+	var m matcher
+	for _, name := range mp.SpanNames {
+		sm, err := newStringMatcher(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid span name %q: %w", name, err)
+		}
+		m.names = append(m.names, sm)
+	}
+	for _, attr := range mp.Attributes {
+		if attr.Key == "" {
+			return nil, errors.New("attributes must have a key")
+		}
+		am := attributeMatcher{key: attr.Key}
+		if attr.Value != "" {
+			sm, err := newStringMatcher(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of attribute %s: %w", attr.Key, err)
+			}
+			am.value = sm
+		}
+		m.attributes = append(m.attributes, am)
+	}
+	return &m, nil
+}
+
+func (m *matcher) matches(span pdata.Span, resource pdata.AttributeMap) bool {
+	if len(m.names) > 0 && !matchesAny(m.names, span.Name()) {
+		return false
+	}
+	for _, am := range m.attributes {
+		v, ok := span.Attributes().Get(am.key)
+		if !ok {
+			v, ok = resource.Get(am.key)
+		}
+		if !ok {
+			return false
+		}
+		if am.value != nil && !am.value(tracetranslator.AttributeValueToString(v, false)) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(matchers []stringMatcher, s string) bool {
+	for _, sm := range matchers {
+		if sm(s) {
+			return true
+		}
+	}
+	return false
+}
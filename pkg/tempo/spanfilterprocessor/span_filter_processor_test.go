@@ -0,0 +1,174 @@
+package spanfilterprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/pdata"
+)
+
+// testSpan describes a span of the traces built by makeTraces.
+type testSpan struct {
+	name       string
+	attributes map[string]string
+}
+
+func makeTraces(service string, spans ...testSpan) pdata.Traces {
+	td := pdata.NewTraces()
+	td.ResourceSpans().Resize(1)
+	rs := td.ResourceSpans().At(0)
+	rs.Resource().Attributes().InsertString("service.name", service)
+	rs.InstrumentationLibrarySpans().Resize(1)
+
+	ss := rs.InstrumentationLibrarySpans().At(0).Spans()
+	ss.Resize(len(spans))
+	for i, s := range spans {
+		ss.At(i).SetName(s.name)
+		for k, v := range s.attributes {
+			ss.At(i).Attributes().InsertString(k, v)
+		}
+	}
+	return td
+}
+
+func spanNames(td pdata.Traces) []string {
+	var names []string
+	rss := td.ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		ilss := rss.At(i).InstrumentationLibrarySpans()
+		for j := 0; j < ilss.Len(); j++ {
+			spans := ilss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				names = append(names, spans.At(k).Name())
+			}
+		}
+	}
+	return names
+}
+
+func TestSpanFilter(t *testing.T) {
+	spans := []testSpan{
+		{name: "GET /healthz", attributes: map[string]string{"http.target": "/healthz"}},
+		{name: "GET /api/users", attributes: map[string]string{"http.target": "/api/users"}},
+		{name: "SELECT users"},
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		service  string
+		expected []string
+	}{
+		{
+			name: "exclude strict attribute",
+			cfg: Config{Exclude: &MatchProperties{
+				MatchType:  MatchTypeStrict,
+				Attributes: []Attribute{{Key: "http.target", Value: "/healthz"}},
+			}},
+			expected: []string{"GET /api/users", "SELECT users"},
+		},
+		{
+			name: "exclude regexp span name",
+			cfg: Config{Exclude: &MatchProperties{
+				MatchType: MatchTypeRegexp,
+				SpanNames: []string{"^GET "},
+			}},
+			expected: []string{"SELECT users"},
+		},
+		{
+			name: "include attribute without value",
+			cfg: Config{Include: &MatchProperties{
+				MatchType:  MatchTypeStrict,
+				Attributes: []Attribute{{Key: "http.target"}},
+			}},
+			expected: []string{"GET /healthz", "GET /api/users"},
+		},
+		{
+			name: "include and exclude",
+			cfg: Config{
+				Include: &MatchProperties{
+					MatchType:  MatchTypeRegexp,
+					Attributes: []Attribute{{Key: "http.target", Value: "^/"}},
+				},
+				Exclude: &MatchProperties{
+					MatchType: MatchTypeStrict,
+					SpanNames: []string{"GET /healthz"},
+				},
+			},
+			expected: []string{"GET /api/users"},
+		},
+		{
+			name: "name and attributes must all match",
+			cfg: Config{Exclude: &MatchProperties{
+				MatchType:  MatchTypeStrict,
+				SpanNames:  []string{"GET /healthz", "SELECT users"},
+				Attributes: []Attribute{{Key: "http.target", Value: "/healthz"}},
+			}},
+			expected: []string{"GET /api/users", "SELECT users"},
+		},
+		{
+			name: "resource attributes",
+			cfg: Config{Exclude: &MatchProperties{
+				MatchType:  MatchTypeStrict,
+				Attributes: []Attribute{{Key: "service.name", Value: "noisy"}},
+			}},
+			service:  "noisy",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newSpanFilter(&tc.cfg)
+			require.NoError(t, err)
+
+			service := tc.service
+			if service == "" {
+				service = "app"
+			}
+			td, err := f.ProcessTraces(context.Background(), makeTraces(service, spans...))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, spanNames(td))
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		expectedErr string
+	}{
+		{
+			name:        "no rules",
+			cfg:         Config{},
+			expectedErr: "span_filter requires include or exclude",
+		},
+		{
+			name:        "empty rule",
+			cfg:         Config{Include: &MatchProperties{MatchType: MatchTypeStrict}},
+			expectedErr: "invalid span_filter include: at least one of span_names or attributes must be set",
+		},
+		{
+			name:        "unknown match type",
+			cfg:         Config{Exclude: &MatchProperties{MatchType: "glob", SpanNames: []string{"*"}}},
+			expectedErr: "invalid span_filter exclude: unsupported match_type 'glob', expected 'strict' or 'regexp'",
+		},
+		{
+			name: "invalid regexp",
+			cfg: Config{Exclude: &MatchProperties{
+				MatchType:  MatchTypeRegexp,
+				Attributes: []Attribute{{Key: "http.target", Value: "("}},
+			}},
+			expectedErr: "invalid span_filter exclude: invalid value of attribute http.target: error parsing regexp: missing closing ): `(`",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.EqualError(t, tc.cfg.Validate(), tc.expectedErr)
+		})
+	}
+}
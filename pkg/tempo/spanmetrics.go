@@ -0,0 +1,190 @@
+package tempo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+)
+
+const (
+	// registryExporterType is the type of the exporter used by spanmetrics
+	// when no metrics_exporter is configured. It exposes the metrics through
+	// the registry of the agent.
+	registryExporterType = "registry"
+
+	// spanMetricsNamespace prefixes the names of span metrics exposed through
+	// the registry of the agent.
+	spanMetricsNamespace = "tempo_spanmetrics"
+)
+
+var errNoSpanMetricsCollector = errors.New("span metrics can only be exposed by a running instance")
+
+// spanMetricsCollector is a prometheus.Collector exposing the latest value of
+// every series sent by the spanmetrics processor. The series are kept until
+// Reset is called.
+type spanMetricsCollector struct {
+	mut    sync.Mutex
+	series map[string]prometheus.Metric
+}
+
+func newSpanMetricsCollector() *spanMetricsCollector {
+	return &spanMetricsCollector{series: make(map[string]prometheus.Metric)}
+}
+
+// ConsumeMetrics implements consumer.MetricsConsumer. Only the cumulative
+// sums and histograms generated by the spanmetrics processor are supported;
+// other metrics are ignored.
+func (c *spanMetricsCollector) ConsumeMetrics(_ context.Context, md pdata.Metrics) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		ilms := rms.At(i).InstrumentationLibraryMetrics()
+		for j := 0; j < ilms.Len(); j++ {
+			metrics := ilms.At(j).Metrics()
+			for k := 0; k < metrics.Len(); k++ {
+				c.consumeMetric(metrics.At(k))
+			}
+		}
+	}
+	return nil
+}
+
+func (c *spanMetricsCollector) consumeMetric(m pdata.Metric) {
+	name := spanMetricsNamespace + "_" + sanitizeLabelName(m.Name())
+
+	switch m.DataType() {
+	case pdata.MetricDataTypeIntSum:
+		dps := m.IntSum().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			dp := dps.At(i)
+			key, desc, values := c.desc(name+"_total", m.Description(), dp.LabelsMap())
+			c.series[key] = prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(dp.Value()), values...)
+		}
+
+	case pdata.MetricDataTypeIntHistogram:
+		dps := m.IntHistogram().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			dp := dps.At(i)
+
+			// Prometheus buckets are cumulative, while the last count of an
+			// OTLP histogram is for values over the last bound.
+			var (
+				bounds  = dp.ExplicitBounds()
+				counts  = dp.BucketCounts()
+				buckets = make(map[float64]uint64, len(bounds))
+				total   uint64
+			)
+			for b, bound := range bounds {
+				if b < len(counts) {
+					total += counts[b]
+				}
+				buckets[bound] = total
+			}
+
+			key, desc, values := c.desc(name, m.Description(), dp.LabelsMap())
+			c.series[key] = prometheus.MustNewConstHistogram(desc, dp.Count(), float64(dp.Sum()), buckets, values...)
+		}
+	}
+}
+
+// desc returns the key identifying the series with the given name and
+// labels, along with its descriptor and label values.
+func (c *spanMetricsCollector) desc(name, help string, labels pdata.StringMap) (string, *prometheus.Desc, []string) {
+	pairs := make(map[string]string, labels.Len())
+	labels.ForEach(func(k, v string) {
+		pairs[sanitizeLabelName(k)] = v
+	})
+
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	key.WriteString(name)
+
+	values := make([]string, 0, len(names))
+	for _, n := range names {
+		values = append(values, pairs[n])
+		key.WriteString("\xff" + n + "\xff" + pairs[n])
+	}
+
+	return key.String(), prometheus.NewDesc(name, help, names, nil), values
+}
+
+// Reset removes all series.
+func (c *spanMetricsCollector) Reset() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.series = make(map[string]prometheus.Metric)
+}
+
+// Describe implements prometheus.Collector. No descriptors are sent since
+// the series change at runtime.
+func (c *spanMetricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *spanMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	for _, m := range c.series {
+		ch <- m
+	}
+}
+
+// sanitizeLabelName replaces characters that aren't allowed in Prometheus
+// metric and label names with underscores.
+func sanitizeLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// registryExporter is the metrics exporter sending metrics to a
+// spanMetricsCollector. Shutting it down removes the series it exported.
+type registryExporter struct {
+	*spanMetricsCollector
+}
+
+// Start implements component.Component.
+func (registryExporter) Start(_ context.Context, _ component.Host) error { return nil }
+
+// Shutdown implements component.Component.
+func (e registryExporter) Shutdown(context.Context) error {
+	e.Reset()
+	return nil
+}
+
+// newRegistryExporterFactory creates a factory for exporters sending metrics
+// to c. c may be nil for factories which are only used to load configs.
+func newRegistryExporterFactory(c *spanMetricsCollector) component.ExporterFactory {
+	return exporterhelper.NewFactory(
+		registryExporterType,
+		func() configmodels.Exporter {
+			return &configmodels.ExporterSettings{TypeVal: registryExporterType, NameVal: registryExporterType}
+		},
+		exporterhelper.WithMetrics(func(context.Context, component.ExporterCreateParams, configmodels.Exporter) (component.MetricsExporter, error) {
+			if c == nil {
+				return nil, errNoSpanMetricsCollector
+			}
+			return registryExporter{c}, nil
+		}),
+	)
+}
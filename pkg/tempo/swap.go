@@ -0,0 +1,149 @@
+package tempo
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configmodels"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/processor"
+)
+
+const (
+	// tracesPipelineName is the name of the pipeline which receives spans and
+	// sends them to the remote_write exporters.
+	tracesPipelineName = "traces"
+
+	// swapExporterType is the type of the exporter put at the end of the
+	// traces pipeline in place of the remote_write exporters. It forwards
+	// spans to whichever remote_write exporters are currently running, which
+	// allows replacing them without rebuilding the receivers and processors.
+	swapExporterType = "swap"
+)
+
+var errNoRemoteWrite = errors.New("no remote_write exporters are running")
+
+// swapConsumer forwards spans to a consumer which can be replaced at any time.
+type swapConsumer struct {
+	mut  sync.RWMutex
+	next consumer.TracesConsumer
+}
+
+// ConsumeTraces implements consumer.TracesConsumer.
+func (c *swapConsumer) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	c.mut.RLock()
+	next := c.next
+	c.mut.RUnlock()
+
+	if next == nil {
+		return errNoRemoteWrite
+	}
+	return next.ConsumeTraces(ctx, td)
+}
+
+// Swap replaces the consumer that spans are forwarded to.
+func (c *swapConsumer) Swap(next consumer.TracesConsumer) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.next = next
+}
+
+// swapExporter is the exporter which forwards spans to a swapConsumer.
+type swapExporter struct {
+	*swapConsumer
+}
+
+// Start implements component.Component.
+func (swapExporter) Start(_ context.Context, _ component.Host) error { return nil }
+
+// Shutdown implements component.Component.
+func (swapExporter) Shutdown(context.Context) error { return nil }
+
+// newSwapExporterFactory creates a factory for exporters forwarding spans to
+// c.
+func newSwapExporterFactory(c *swapConsumer) component.ExporterFactory {
+	return exporterhelper.NewFactory(
+		swapExporterType,
+		func() configmodels.Exporter {
+			return &configmodels.ExporterSettings{TypeVal: swapExporterType, NameVal: swapExporterType}
+		},
+		exporterhelper.WithTraces(func(context.Context, component.ExporterCreateParams, configmodels.Exporter) (component.TracesExporter, error) {
+			return swapExporter{c}, nil
+		}),
+	)
+}
+
+// splitRemoteWrite splits cfg in two: the config of the pipelines, where the
+// remote_write exporters of the traces pipeline are replaced by a single swap
+// exporter, and the config of the remote_write exporters alone.
+func splitRemoteWrite(cfg *configmodels.Config) (pipelines, remoteWrite *configmodels.Config) {
+	traces := cfg.Service.Pipelines[tracesPipelineName]
+
+	isRemoteWrite := make(map[string]bool, len(traces.Exporters))
+	for _, name := range traces.Exporters {
+		isRemoteWrite[name] = true
+	}
+
+	pipelines = &configmodels.Config{
+		Receivers:  cfg.Receivers,
+		Exporters:  configmodels.Exporters{},
+		Processors: cfg.Processors,
+		Extensions: cfg.Extensions,
+		Service: configmodels.Service{
+			Extensions: cfg.Service.Extensions,
+			Pipelines:  configmodels.Pipelines{},
+		},
+	}
+	remoteWrite = &configmodels.Config{
+		Exporters: configmodels.Exporters{},
+		Service: configmodels.Service{
+			Pipelines: configmodels.Pipelines{},
+		},
+	}
+
+	for name, exp := range cfg.Exporters {
+		if isRemoteWrite[name] {
+			remoteWrite.Exporters[name] = exp
+		} else {
+			pipelines.Exporters[name] = exp
+		}
+	}
+	pipelines.Exporters[swapExporterType] = &configmodels.ExporterSettings{TypeVal: swapExporterType, NameVal: swapExporterType}
+
+	for name, p := range cfg.Service.Pipelines {
+		pipelines.Service.Pipelines[name] = p
+	}
+	swapped := *traces
+	swapped.Exporters = []string{swapExporterType}
+	pipelines.Service.Pipelines[tracesPipelineName] = &swapped
+
+	// The exporters builder only creates exporters used by a pipeline, so
+	// the remote_write exporters get a pipeline of their own.
+	remoteWrite.Service.Pipelines[tracesPipelineName] = &configmodels.Pipeline{
+		Name:      traces.Name,
+		InputType: traces.InputType,
+		Exporters: traces.Exporters,
+	}
+
+	return pipelines, remoteWrite
+}
+
+// fanoutRemoteWrite returns a consumer sending spans to all traces exporters
+// of exps. The health of each exporter is reported to health, which forgets
+// the exporters it was previously reporting.
+func fanoutRemoteWrite(exps map[configmodels.Exporter]component.Exporter, health *exporterHealth) consumer.TracesConsumer {
+	health.Reset()
+
+	consumers := make([]consumer.TracesConsumer, 0, len(exps))
+	for cfg, exp := range exps {
+		consumers = append(consumers, health.Wrap(cfg, exp.(consumer.TracesConsumer)))
+	}
+	if len(consumers) == 1 {
+		return consumers[0]
+	}
+	return processor.NewTracesFanOutConnector(consumers)
+}
@@ -1,122 +1,534 @@
 package tempo
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/util"
 	zaplogfmt "github.com/jsternberg/zap-logfmt"
 	prom_client "github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/stats/view"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
 
 	"go.opentelemetry.io/collector/config/configtelemetry"
 	"go.opentelemetry.io/collector/obsreport"
 )
 
+// ErrInstanceNotFound is returned by RemoveInstance when there is no config
+// with the given name.
+var ErrInstanceNotFound = errors.New("tempo instance not found")
+
+// ErrExternalLogger is returned by SetLogFormat when Tempo was created
+// WithLogger, whose encoding Tempo doesn't control.
+var ErrExternalLogger = errors.New("log format can't be changed when using an external logger")
+
 // Tempo wraps the OpenTelemetry collector to enable tracing pipelines
 type Tempo struct {
 	mut       sync.Mutex
+	configs   map[string]InstanceConfig
 	instances map[string]*Instance
 
-	leveller *logLeveller
-	logger   *zap.Logger
-	reg      prom_client.Registerer
+	// accepting holds the instances checked by Accepting. It has its own
+	// mutex so health checks don't wait on mut while instances are drained,
+	// and instances are only removed from it once they're stopped.
+	acceptingMut sync.Mutex
+	accepting    map[string]*Instance
+
+	leveller    *logLeveller
+	timeEncoder *logTimeEncoder
+	logEncoder  *logEncoder // nil when using WithLogger
+	logger      *zap.Logger
+	metrics     *instanceMetrics
+
+	zpages *zpages
+}
+
+// Option customizes Tempo when passed to New.
+type Option func(*options)
+
+type options struct {
+	logger *zap.Logger
+}
+
+// WithLogger makes Tempo log to an existing logger instead of creating its
+// own. Entries are still filtered by the log level passed to New and
+// ApplyConfig, but are otherwise encoded by logger. This means that the
+// log_format, log_timestamp_format and log_utc settings have no effect.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
 }
 
 // New creates and starts Loki log collection.
-func New(reg prom_client.Registerer, cfg Config, level logrus.Level) (*Tempo, error) {
-	var leveller logLeveller
+func New(reg prom_client.Registerer, cfg Config, level logrus.Level, opts ...Option) (*Tempo, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var (
+		leveller    logLeveller
+		timeEncoder logTimeEncoder
+	)
+	timeEncoder.SetFormat(cfg.logTimestampFormat())
+
+	var (
+		encoder *logEncoder
+		logger  *zap.Logger
+	)
+	if o.logger != nil {
+		logger = wrapLogger(o.logger, &leveller)
+	} else {
+		encoder = newLogEncoder(&leveller, timeEncoder.Encode, os.Stdout)
+		if err := encoder.SetFormat(cfg.LogFormat); err != nil {
+			return nil, err
+		}
+		logger = newLogger(encoder)
+	}
+
+	// Make the collector components record their telemetry. The views
+	// exporting it are registered by each instance for its metrics level.
+	obsreport.Configure(configtelemetry.LevelBasic)
+
+	metrics := newInstanceMetrics()
+	if err := reg.Register(metrics); err != nil {
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
+	}
 
 	tempo := &Tempo{
-		instances: make(map[string]*Instance),
-		leveller:  &leveller,
-		logger:    newLogger(&leveller),
-		reg:       reg,
+		configs:     make(map[string]InstanceConfig),
+		instances:   make(map[string]*Instance),
+		leveller:    &leveller,
+		timeEncoder: &timeEncoder,
+		logEncoder:  encoder,
+		logger:      logger,
+		metrics:     metrics,
+		zpages:      newZPages(),
 	}
-	if err := tempo.ApplyConfig(cfg, level); err != nil {
+	if _, err := tempo.ApplyConfig(cfg, level); err != nil {
+		tempo.Stop()
 		return nil, err
 	}
 	return tempo, nil
 }
 
-// ApplyConfig updates Tempo with a new Config.
-func (t *Tempo) ApplyConfig(cfg Config, level logrus.Level) error {
+// ReloadSummary describes how the instances of Tempo were affected by a call
+// to ApplyConfig. Each list holds instance names in sorted order. Disabled
+// configs don't have a running instance and so are only reported when
+// disabling them removes an instance.
+type ReloadSummary struct {
+	Created   []string
+	Updated   []string
+	Removed   []string
+	Unchanged []string
+}
+
+// ApplyConfig updates Tempo with a new Config and returns which instances
+// were affected.
+func (t *Tempo) ApplyConfig(cfg Config, level logrus.Level) (ReloadSummary, error) {
 	t.mut.Lock()
 	defer t.mut.Unlock()
 
-	// Update the log level, if it has changed.
+	// Update the log level, timestamp format and log format, if they have
+	// changed.
 	t.leveller.SetLevel(level)
+	t.timeEncoder.SetFormat(cfg.logTimestampFormat())
+	if t.logEncoder != nil {
+		if err := t.logEncoder.SetFormat(cfg.LogFormat); err != nil {
+			return ReloadSummary{}, err
+		}
+	}
+	t.zpages.SetEnabled(cfg.EnableZPages)
 
-	newInstances := make(map[string]*Instance, len(cfg.Configs))
+	var (
+		newConfigs   = make(map[string]InstanceConfig, len(cfg.Configs))
+		newInstances = make(map[string]*Instance, len(cfg.Configs))
+		summary      ReloadSummary
+	)
 
 	for _, c := range cfg.Configs {
+		newConfigs[c.Name] = c
+
+		// Disabled instances aren't added to newInstances, which stops any
+		// that already exist below.
+		if !c.IsEnabled() {
+			continue
+		}
+
 		// If an old instance exists, update it and move it to the new map.
 		if old, ok := t.instances[c.Name]; ok {
 			err := old.ApplyConfig(c)
 			if err != nil {
-				return err
+				return ReloadSummary{}, err
 			}
 
+			if util.CompareYAML(c, t.configs[c.Name]) {
+				summary.Unchanged = append(summary.Unchanged, c.Name)
+			} else {
+				summary.Updated = append(summary.Updated, c.Name)
+			}
 			newInstances[c.Name] = old
 			continue
 		}
 
 		var (
 			instLogger = t.logger.With(zap.String("tempo_config", c.Name))
-			instReg    = prom_client.WrapRegistererWith(prom_client.Labels{"tempo_config": c.Name}, t.reg)
+			instReg    = t.metrics.Registerer(c.Name)
 		)
 
 		inst, err := NewInstance(instReg, c, instLogger)
 		if err != nil {
-			return fmt.Errorf("failed to create tempo instance %s: %w", c.Name, err)
+			// NewInstance may have registered collectors before failing.
+			t.metrics.Remove(c.Name)
+			return ReloadSummary{}, fmt.Errorf("failed to create tempo instance %s: %w", c.Name, err)
 		}
+		summary.Created = append(summary.Created, c.Name)
 		newInstances[c.Name] = inst
 	}
 
 	// Any instance in l.instances that isn't in newInstances has been removed
-	// from the config. Stop them before replacing the map.
+	// from the config or disabled. Stop them before replacing the map.
 	for key, i := range t.instances {
 		if _, exist := newInstances[key]; exist {
 			continue
 		}
 		i.Stop()
+		t.metrics.Remove(key)
+		summary.Removed = append(summary.Removed, key)
+	}
+	t.setInstances(newInstances)
+	t.configs = newConfigs
+
+	for _, names := range [][]string{summary.Created, summary.Updated, summary.Removed, summary.Unchanged} {
+		sort.Strings(names)
 	}
-	t.instances = newInstances
+	return summary, nil
+}
 
+// RemoveInstance stops the instance with the given name and removes its
+// config. The instance is drained the same way as when it's removed by
+// ApplyConfig: receivers stop first so spans already accepted can still be
+// exported within its shutdown timeout. Disabled configs have no instance and
+// are only removed. Returns ErrInstanceNotFound if there is no config with the
+// given name.
+func (t *Tempo) RemoveInstance(name string) error {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if _, ok := t.configs[name]; !ok {
+		return ErrInstanceNotFound
+	}
+
+	if inst, ok := t.instances[name]; ok {
+		inst.Stop()
+		t.metrics.Remove(name)
+		delete(t.instances, name)
+		t.setInstances(t.instances)
+	}
+	delete(t.configs, name)
 	return nil
 }
 
+// SetLogFormat changes the format of the Tempo logs without recreating the
+// logger, so the running instances keep logging through it. The log level is
+// kept. The format is reset to log_format on the next call to ApplyConfig.
+// Returns ErrExternalLogger if Tempo was created WithLogger.
+func (t *Tempo) SetLogFormat(format string) error {
+	if t.logEncoder == nil {
+		return ErrExternalLogger
+	}
+	return t.logEncoder.SetFormat(format)
+}
+
+// setInstances replaces the running instances. mut must be held when calling
+// setInstances.
+func (t *Tempo) setInstances(instances map[string]*Instance) {
+	t.instances = instances
+
+	accepting := make(map[string]*Instance, len(instances))
+	for name, inst := range instances {
+		accepting[name] = inst
+	}
+
+	t.acceptingMut.Lock()
+	defer t.acceptingMut.Unlock()
+	t.accepting = accepting
+}
+
+// Accepting returns whether there is at least one running instance and all
+// of them are accepting spans. It returns false as soon as an instance starts
+// draining, whether it's being removed or reloaded, so it can back a health
+// check routing spans away from the agent. Disabled instances aren't
+// considered.
+func (t *Tempo) Accepting() bool {
+	t.acceptingMut.Lock()
+	defer t.acceptingMut.Unlock()
+
+	if len(t.accepting) == 0 {
+		return false
+	}
+	for _, inst := range t.accepting {
+		if !inst.Accepting() {
+			return false
+		}
+	}
+	return true
+}
+
+// ListConfigs returns all configured instances, including disabled ones,
+// keyed by name.
+func (t *Tempo) ListConfigs() map[string]InstanceConfig {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	res := make(map[string]InstanceConfig, len(t.configs))
+	for name, c := range t.configs {
+		res[name] = c
+	}
+	return res
+}
+
+// EffectiveConfigs returns the effective config of every running instance,
+// keyed by name. Disabled configs have no instance and are omitted. See
+// Instance.EffectiveConfig.
+func (t *Tempo) EffectiveConfigs() map[string]InstanceConfig {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	res := make(map[string]InstanceConfig, len(t.instances))
+	for name, inst := range t.instances {
+		res[name] = inst.EffectiveConfig()
+	}
+	return res
+}
+
+// WireAPI adds API routes to the provided mux router.
+func (t *Tempo) WireAPI(r *mux.Router) {
+	r.PathPrefix(zpagesPrefix + "/").Handler(t.ZPagesHandler())
+	r.HandleFunc("/debug/tempo/configs", t.EffectiveConfigsHandler)
+}
+
+// EffectiveConfigsHandler renders the result of EffectiveConfigs as YAML.
+func (t *Tempo) EffectiveConfigsHandler(rw http.ResponseWriter, _ *http.Request) {
+	bb, err := yaml.Marshal(t.EffectiveConfigs())
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed to marshal configs: %s", err), http.StatusInternalServerError)
+		return
+	}
+	_, _ = rw.Write(bb)
+}
+
+// ZPagesHandler returns an http.Handler serving the zpages under
+// /debug/tempo/zpages/. The handler responds with 404 Not Found unless
+// EnableZPages is set in the current Config.
+func (t *Tempo) ZPagesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.zpages.Enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		t.zpages.ServeHTTP(w, r)
+	})
+}
+
 // Stop stops the OpenTelemetry collector subsystem
 func (t *Tempo) Stop() {
 	t.mut.Lock()
 	defer t.mut.Unlock()
 
+	// Instances are stopped in parallel so each one is only bounded by its
+	// own shutdown timeout.
+	var wg sync.WaitGroup
 	for _, i := range t.instances {
-		i.Stop()
+		wg.Add(1)
+		go func(i *Instance) {
+			defer wg.Done()
+			i.Stop()
+		}(i)
+	}
+	wg.Wait()
+
+	for key := range t.instances {
+		t.metrics.Remove(key)
 	}
+	t.zpages.SetEnabled(false)
 }
 
-func newLogger(zapLevel zapcore.LevelEnabler) *zap.Logger {
-	config := zap.NewProductionEncoderConfig()
-	config.EncodeTime = func(ts time.Time, encoder zapcore.PrimitiveArrayEncoder) {
-		encoder.AppendString(ts.UTC().Format(time.RFC3339))
-	}
-	logger := zap.New(zapcore.NewCore(
-		zaplogfmt.NewEncoder(config),
-		os.Stdout,
-		zapLevel,
-	))
+func newLogger(encoder *logEncoder) *zap.Logger {
+	logger := zap.New(encoder.Core())
 	logger = logger.With(zap.String("component", "tempo"))
 	logger.Info("Tempo Logger Initialized")
 
 	return logger
 }
 
+// wrapLogger wraps an existing logger to additionally filter entries by the
+// log level controlled by zapLevel.
+func wrapLogger(logger *zap.Logger, zapLevel zapcore.LevelEnabler) *zap.Logger {
+	logger = logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &levelCore{Core: c, level: zapLevel}
+	}))
+	return logger.With(zap.String("component", "tempo"))
+}
+
+// levelCore is a zapcore.Core which filters entries from the wrapped Core by
+// an extra zapcore.LevelEnabler.
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+// Enabled implements zapcore.Core.
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l) && c.Core.Enabled(l)
+}
+
+// With implements zapcore.Core.
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+// Check implements zapcore.Core.
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+// logEncoder builds the zapcore.Core of the Tempo logger and allows for
+// switching out the log format at runtime.
+type logEncoder struct {
+	level      zapcore.LevelEnabler
+	encodeTime zapcore.TimeEncoder
+	out        zapcore.WriteSyncer
+
+	mut    sync.RWMutex
+	format string
+	base   zapcore.Core
+	gen    uint64
+}
+
+func newLogEncoder(level zapcore.LevelEnabler, encodeTime zapcore.TimeEncoder, out zapcore.WriteSyncer) *logEncoder {
+	e := &logEncoder{level: level, encodeTime: encodeTime, out: out}
+	_ = e.SetFormat(DefaultLogFormat)
+	return e
+}
+
+// SetFormat changes the format of entries logged by every Core returned by
+// the logEncoder, including those derived through With.
+func (e *logEncoder) SetFormat(format string) error {
+	if format == "" {
+		format = DefaultLogFormat
+	}
+
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = e.encodeTime
+
+	var enc zapcore.Encoder
+	switch format {
+	case "logfmt":
+		enc = zaplogfmt.NewEncoder(config)
+	case "json":
+		enc = zapcore.NewJSONEncoder(config)
+	default:
+		return fmt.Errorf("unsupported log format %q", format)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if format == e.format {
+		return nil
+	}
+	e.format = format
+	e.base = zapcore.NewCore(enc, e.out, e.level)
+	e.gen++
+	return nil
+}
+
+// Core returns a zapcore.Core which encodes entries with the current format.
+func (e *logEncoder) Core() zapcore.Core {
+	return &encoderCore{encoder: e}
+}
+
+func (e *logEncoder) current() (zapcore.Core, uint64) {
+	e.mut.RLock()
+	defer e.mut.RUnlock()
+	return e.base, e.gen
+}
+
+// encoderCore is a zapcore.Core which writes entries to the current Core of
+// a logEncoder. Fields added through With are kept unencoded so they can be
+// encoded again after the format changes.
+type encoderCore struct {
+	encoder *logEncoder
+	fields  []zapcore.Field
+
+	// cached holds an encoderCoreCache with fields already added to the
+	// Core of the logEncoder generation it was built for.
+	cached atomic.Value
+}
+
+type encoderCoreCache struct {
+	gen  uint64
+	core zapcore.Core
+}
+
+// Enabled implements zapcore.Core.
+func (c *encoderCore) Enabled(l zapcore.Level) bool {
+	return c.encoder.level.Enabled(l)
+}
+
+// With implements zapcore.Core.
+func (c *encoderCore) With(fields []zapcore.Field) zapcore.Core {
+	newFields := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	newFields = append(newFields, c.fields...)
+	newFields = append(newFields, fields...)
+	return &encoderCore{encoder: c.encoder, fields: newFields}
+}
+
+// Check implements zapcore.Core.
+func (c *encoderCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+// Write implements zapcore.Core.
+func (c *encoderCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	return c.core().Write(e, fields)
+}
+
+// Sync implements zapcore.Core.
+func (c *encoderCore) Sync() error {
+	return c.core().Sync()
+}
+
+// core returns the current Core of the logEncoder with the fields of c.
+func (c *encoderCore) core() zapcore.Core {
+	base, gen := c.encoder.current()
+	if cache, ok := c.cached.Load().(encoderCoreCache); ok && cache.gen == gen {
+		return cache.core
+	}
+
+	core := base.With(c.fields)
+	c.cached.Store(encoderCoreCache{gen: gen, core: core})
+	return core
+}
+
 // logLeveller implements the zapcore.LevelEnabler interface and allows for
 // switching out log levels at runtime.
 type logLeveller struct {
@@ -141,8 +553,7 @@ func (l *logLeveller) SetLevel(level logrus.Level) {
 		zapLevel = zapcore.WarnLevel
 	case logrus.InfoLevel:
 		zapLevel = zapcore.InfoLevel
-	case logrus.DebugLevel:
-	case logrus.TraceLevel:
+	case logrus.DebugLevel, logrus.TraceLevel:
 		zapLevel = zapcore.DebugLevel
 	}
 
@@ -155,13 +566,34 @@ func (l *logLeveller) Enabled(target zapcore.Level) bool {
 	return l.inner.Enabled(target)
 }
 
-func newMetricViews(reg prom_client.Registerer) ([]*view.View, error) {
-	views := obsreport.Configure(configtelemetry.LevelBasic)
-	err := view.Register(views...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to register views: %w", err)
+// logTimeEncoder encodes log timestamps and allows for switching out the
+// timestamp format at runtime.
+type logTimeEncoder struct {
+	mut    sync.RWMutex
+	format string
+	utc    bool
+}
+
+func (e *logTimeEncoder) SetFormat(format string, utc bool) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.format, e.utc = format, utc
+}
+
+// Encode implements zapcore.TimeEncoder.
+func (e *logTimeEncoder) Encode(ts time.Time, encoder zapcore.PrimitiveArrayEncoder) {
+	e.mut.RLock()
+	defer e.mut.RUnlock()
+
+	if e.utc {
+		ts = ts.UTC()
 	}
+	encoder.AppendString(ts.Format(e.format))
+}
 
+// newMetricExporter creates a view exporter which exposes the metric views
+// to reg.
+func newMetricExporter(reg prom_client.Registerer) (view.Exporter, error) {
 	pe, err := prometheus.NewExporter(prometheus.Options{
 		Namespace:  "tempo",
 		Registerer: reg,
@@ -171,6 +603,5 @@ func newMetricViews(reg prom_client.Registerer) ([]*view.View, error) {
 	}
 
 	view.RegisterExporter(pe)
-
-	return views, nil
+	return pe, nil
 }
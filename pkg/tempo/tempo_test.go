@@ -1,11 +1,19 @@
 package tempo
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/tempo/internal/tempoutils"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/opentracing/opentracing-go"
@@ -14,7 +22,10 @@ import (
 	"github.com/stretchr/testify/require"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
 	"github.com/weaveworks/common/logging"
+	"go.opencensus.io/trace"
 	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v2"
 )
 
@@ -118,7 +129,7 @@ configs:
 	err = dec.Decode(&fixedConfig)
 	require.NoError(t, err)
 
-	err = tempo.ApplyConfig(fixedConfig, logrus.DebugLevel)
+	_, err = tempo.ApplyConfig(fixedConfig, logrus.DebugLevel)
 	require.NoError(t, err)
 
 	tr := testJaegerTracer(t)
@@ -134,6 +145,561 @@ configs:
 	}
 }
 
+func TestTempo_ApplyConfig_RemovedInstanceSeries(t *testing.T) {
+	tracesCh := make(chan pdata.Traces)
+	tracesAddr := tempoutils.NewTestServer(t, func(t pdata.Traces) {
+		tracesCh <- t
+	})
+
+	loadConfig := func(text string) Config {
+		var cfg Config
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(text)))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&cfg))
+		return cfg
+	}
+
+	reg := prometheus.NewRegistry()
+	tempo, err := New(reg, loadConfig(fmt.Sprintf(`
+configs:
+- name: removed
+  receivers:
+		jaeger:
+			protocols:
+				thrift_compact:
+	push_config:
+		endpoint: %s
+		insecure: true
+		batch:
+			timeout: 100ms
+			send_batch_size: 1
+	`, tracesAddr)), logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	// Send a span so there's some data to expose as metrics.
+	span := testJaegerTracer(t).StartSpan("test-span")
+	span.Finish()
+	select {
+	case <-time.After(30 * time.Second):
+		require.Fail(t, "failed to receive a span after 30 seconds")
+	case <-tracesCh:
+	}
+
+	configSeries := func() map[string]int {
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+
+		res := make(map[string]int)
+		for _, mf := range mfs {
+			for _, m := range mf.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "tempo_config" {
+						res[l.GetValue()]++
+					}
+				}
+			}
+		}
+		return res
+	}
+	require.NotZero(t, configSeries()["removed"])
+
+	_, err = tempo.ApplyConfig(loadConfig(fmt.Sprintf(`
+configs:
+- name: kept
+  receivers:
+		otlp:
+			protocols:
+				grpc:
+					endpoint: 127.0.0.1:0
+	push_config:
+		endpoint: %s
+		insecure: true
+	`, tracesAddr)), logrus.InfoLevel)
+	require.NoError(t, err)
+
+	series := configSeries()
+	require.Zero(t, series["removed"], "series from the removed instance should be gone")
+	require.NotZero(t, series["kept"])
+}
+
+func TestTempo_ApplyConfig_FailedInstanceSeries(t *testing.T) {
+	tracesCh := make(chan pdata.Traces)
+	tracesAddr := tempoutils.NewTestServer(t, func(t pdata.Traces) {
+		tracesCh <- t
+	})
+
+	loadConfig := func(text string) Config {
+		var cfg Config
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(text)))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&cfg))
+		return cfg
+	}
+
+	keptConfig := fmt.Sprintf(`
+- name: kept
+  receivers:
+		jaeger:
+			protocols:
+				thrift_compact:
+	push_config:
+		endpoint: %s
+		insecure: true
+		batch:
+			timeout: 100ms
+			send_batch_size: 1
+	`, tracesAddr)
+
+	reg := prometheus.NewRegistry()
+	tempo, err := New(reg, loadConfig("configs:"+keptConfig), logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	// Send a span so there's some data to expose as metrics.
+	span := testJaegerTracer(t).StartSpan("test-span")
+	span.Finish()
+	select {
+	case <-time.After(30 * time.Second):
+		require.Fail(t, "failed to receive a span after 30 seconds")
+	case <-tracesCh:
+	}
+
+	// Occupy a port so the receiver of the new instance fails to start.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	_, err = tempo.ApplyConfig(loadConfig("configs:"+keptConfig+fmt.Sprintf(`
+- name: broken
+  receivers:
+		otlp:
+			protocols:
+				grpc:
+					endpoint: %s
+	push_config:
+		endpoint: %s
+		insecure: true
+	`, lis.Addr().String(), tracesAddr)), logrus.InfoLevel)
+	require.Error(t, err)
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err, "gathering metrics should not fail after a failed instance")
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "tempo_config" {
+					require.NotEqual(t, "broken", l.GetValue(), "series from the failed instance should not be exposed")
+				}
+			}
+		}
+	}
+}
+
+func TestTempo_ApplyConfig_Disabled(t *testing.T) {
+	loadConfig := func(enabled bool) Config {
+		var cfg Config
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+configs:
+- name: staged
+  enabled: %t
+  receivers:
+		otlp:
+			protocols:
+				grpc:
+					endpoint: 127.0.0.1:0
+	push_config:
+		endpoint: 127.0.0.1:80
+		insecure: true
+	`, enabled))))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&cfg))
+		return cfg
+	}
+
+	tempo, err := New(prometheus.NewRegistry(), loadConfig(false), logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	requireState := func(enabled bool) {
+		t.Helper()
+		cfgs := tempo.ListConfigs()
+		require.Contains(t, cfgs, "staged")
+		c := cfgs["staged"]
+		require.Equal(t, enabled, c.IsEnabled())
+
+		tempo.mut.Lock()
+		defer tempo.mut.Unlock()
+		if enabled {
+			require.Contains(t, tempo.instances, "staged")
+		} else {
+			require.NotContains(t, tempo.instances, "staged")
+		}
+	}
+	requireState(false)
+
+	_, err = tempo.ApplyConfig(loadConfig(true), logrus.InfoLevel)
+	require.NoError(t, err)
+	requireState(true)
+
+	_, err = tempo.ApplyConfig(loadConfig(false), logrus.InfoLevel)
+	require.NoError(t, err)
+	requireState(false)
+}
+
+func TestTempo_ApplyConfig_Summary(t *testing.T) {
+	instanceConfig := func(name, endpoint string) InstanceConfig {
+		var c InstanceConfig
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+name: %s
+receivers:
+	otlp:
+		protocols:
+			grpc:
+				endpoint: 127.0.0.1:0
+push_config:
+	endpoint: %s
+	insecure: true
+		`, name, endpoint))))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&c))
+		return c
+	}
+
+	disabled := instanceConfig("disabled", "127.0.0.1:80")
+	disabled.Enabled = new(bool)
+
+	tempo, err := New(prometheus.NewRegistry(), Config{
+		Configs: []InstanceConfig{
+			instanceConfig("unchanged", "127.0.0.1:80"),
+			instanceConfig("updated", "127.0.0.1:80"),
+			instanceConfig("removed", "127.0.0.1:80"),
+			disabled,
+		},
+	}, logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	summary, err := tempo.ApplyConfig(Config{
+		Configs: []InstanceConfig{
+			instanceConfig("unchanged", "127.0.0.1:80"),
+			instanceConfig("updated", "127.0.0.1:81"),
+			instanceConfig("created-b", "127.0.0.1:80"),
+			instanceConfig("created-a", "127.0.0.1:80"),
+			disabled,
+		},
+	}, logrus.InfoLevel)
+	require.NoError(t, err)
+	require.Equal(t, ReloadSummary{
+		Created:   []string{"created-a", "created-b"},
+		Updated:   []string{"updated"},
+		Removed:   []string{"removed"},
+		Unchanged: []string{"unchanged"},
+	}, summary)
+}
+
+func TestTempo_RemoveInstance(t *testing.T) {
+	instanceConfig := func(name string) InstanceConfig {
+		var c InstanceConfig
+		dec := yaml.NewDecoder(strings.NewReader(util.Untab(fmt.Sprintf(`
+name: %s
+receivers:
+	otlp:
+		protocols:
+			grpc:
+				endpoint: 127.0.0.1:0
+push_config:
+	endpoint: 127.0.0.1:80
+	insecure: true
+		`, name))))
+		dec.SetStrict(true)
+		require.NoError(t, dec.Decode(&c))
+		return c
+	}
+
+	disabled := instanceConfig("disabled")
+	disabled.Enabled = new(bool)
+
+	tempo, err := New(prometheus.NewRegistry(), Config{
+		Configs: []InstanceConfig{instanceConfig("kept"), instanceConfig("removed"), disabled},
+	}, logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	require.NoError(t, tempo.RemoveInstance("removed"))
+	require.NoError(t, tempo.RemoveInstance("disabled"))
+	require.Equal(t, ErrInstanceNotFound, tempo.RemoveInstance("removed"))
+	require.Equal(t, ErrInstanceNotFound, tempo.RemoveInstance("unknown"))
+
+	configs := tempo.ListConfigs()
+	require.Len(t, configs, 1)
+	require.Contains(t, configs, "kept")
+
+	// Applying the old config again recreates the removed instance.
+	summary, err := tempo.ApplyConfig(Config{
+		Configs: []InstanceConfig{instanceConfig("kept"), instanceConfig("removed")},
+	}, logrus.InfoLevel)
+	require.NoError(t, err)
+	require.Equal(t, []string{"removed"}, summary.Created)
+}
+
+func TestTempo_Accepting(t *testing.T) {
+	var c InstanceConfig
+	dec := yaml.NewDecoder(strings.NewReader(util.Untab(`
+name: test
+receivers:
+	otlp:
+		protocols:
+			grpc:
+				endpoint: 127.0.0.1:0
+push_config:
+	endpoint: 127.0.0.1:80
+	insecure: true
+	`)))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&c))
+
+	tempo, err := New(prometheus.NewRegistry(), Config{Configs: []InstanceConfig{c}}, logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	inst := tempo.instances["test"]
+	require.True(t, inst.Accepting())
+	require.True(t, tempo.Accepting())
+
+	// Instances stop accepting spans as soon as they start draining.
+	inst.mut.Lock()
+	inst.stop()
+	inst.mut.Unlock()
+	require.False(t, inst.Accepting())
+	require.False(t, tempo.Accepting())
+
+	// Without any instance, no spans are accepted.
+	require.NoError(t, tempo.RemoveInstance("test"))
+	require.False(t, tempo.Accepting())
+}
+
+func TestTempo_WithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = ""
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(&buf), zapcore.DebugLevel)
+
+	tempo, err := New(prometheus.NewRegistry(), Config{}, logrus.InfoLevel, WithLogger(zap.New(core)))
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	tempo.logger.Debug("filtered")
+	tempo.logger.Info("not filtered")
+
+	// Changing the log level should apply to the external logger.
+	_, err = tempo.ApplyConfig(Config{}, logrus.DebugLevel)
+	require.NoError(t, err)
+	tempo.logger.Debug("not filtered")
+
+	require.Equal(t, util.Untab(`
+{"level":"info","msg":"not filtered","component":"tempo"}
+{"level":"debug","msg":"not filtered","component":"tempo"}
+`), "\n"+buf.String())
+}
+
+func TestTempo_ZPagesHandler(t *testing.T) {
+	tempo, err := New(prometheus.NewRegistry(), Config{}, logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	r := mux.NewRouter()
+	tempo.WireAPI(r)
+
+	request := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tempo/zpages/tracez", nil))
+		return rec
+	}
+	requestStatus := func() int { return request().Code }
+	endSpan := func(name string) {
+		_, span := trace.StartSpan(context.Background(), name)
+		span.End()
+	}
+	require.Equal(t, http.StatusNotFound, requestStatus(), "zpages should be disabled by default")
+	endSpan("before")
+
+	_, err = tempo.ApplyConfig(Config{EnableZPages: true}, logrus.InfoLevel)
+	require.NoError(t, err)
+	endSpan("after")
+
+	rec := request()
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "after (1 spans)")
+	require.NotContains(t, rec.Body.String(), "before", "spans are only recorded once zpages are enabled")
+
+	_, err = tempo.ApplyConfig(Config{}, logrus.InfoLevel)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, requestStatus())
+}
+
+func TestTempo_EffectiveConfigs(t *testing.T) {
+	var cfg Config
+	dec := yaml.NewDecoder(strings.NewReader(util.Untab(`
+configs:
+- name: default
+  receivers:
+		otlp:
+			protocols:
+				grpc:
+					endpoint: 127.0.0.1:0
+	push_config:
+		endpoint: 127.0.0.1:80
+		insecure: true
+- name: disabled
+  enabled: false
+	`)))
+	dec.SetStrict(true)
+	require.NoError(t, dec.Decode(&cfg))
+
+	tempo, err := New(prometheus.NewRegistry(), cfg, logrus.InfoLevel)
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	r := mux.NewRouter()
+	tempo.WireAPI(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/tempo/configs", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var configs map[string]InstanceConfig
+	require.NoError(t, yaml.Unmarshal(rec.Body.Bytes(), &configs))
+	require.Len(t, configs, 1, "disabled configs have no instance")
+
+	effective := configs["default"]
+	require.True(t, effective.IsEnabled())
+	require.NotNil(t, effective.Enabled)
+	require.Equal(t, DefaultShutdownTimeout, effective.ShutdownTimeout)
+	require.Equal(t, DefaultMetricsLevel, effective.MetricsLevel)
+	require.Equal(t, compressionGzip, effective.PushConfig.Compression)
+
+	// The config of the instance itself is left untouched.
+	require.Nil(t, tempo.ListConfigs()["default"].Enabled)
+}
+
+func TestLogLeveller(t *testing.T) {
+	tt := []struct {
+		level  logrus.Level
+		expect zapcore.Level
+	}{
+		{level: logrus.PanicLevel, expect: zapcore.PanicLevel},
+		{level: logrus.FatalLevel, expect: zapcore.FatalLevel},
+		{level: logrus.ErrorLevel, expect: zapcore.ErrorLevel},
+		{level: logrus.WarnLevel, expect: zapcore.WarnLevel},
+		{level: logrus.InfoLevel, expect: zapcore.InfoLevel},
+		{level: logrus.DebugLevel, expect: zapcore.DebugLevel},
+		{level: logrus.TraceLevel, expect: zapcore.DebugLevel},
+	}
+	require.Len(t, tt, len(logrus.AllLevels), "every logrus level should be tested")
+
+	for _, tc := range tt {
+		t.Run(tc.level.String(), func(t *testing.T) {
+			var l logLeveller
+			l.SetLevel(tc.level)
+			require.Equal(t, tc.expect, l.inner)
+
+			require.True(t, l.Enabled(tc.expect))
+			if tc.expect > zapcore.DebugLevel {
+				require.False(t, l.Enabled(tc.expect-1))
+			}
+		})
+	}
+}
+
+func TestLogTimeEncoder(t *testing.T) {
+	ts := time.Date(2021, time.April, 1, 12, 0, 0, 500, time.FixedZone("test", 60*60))
+
+	tt := []struct {
+		format string
+		utc    bool
+		expect string
+	}{
+		{format: time.RFC3339, utc: true, expect: "2021-04-01T11:00:00Z"},
+		{format: time.RFC3339Nano, utc: true, expect: "2021-04-01T11:00:00.0000005Z"},
+		{format: time.RFC3339, utc: false, expect: "2021-04-01T12:00:00+01:00"},
+	}
+
+	for _, tc := range tt {
+		var enc logTimeEncoder
+		enc.SetFormat(tc.format, tc.utc)
+
+		out := zapcore.NewMapObjectEncoder()
+		err := out.AddArray("ts", zapcore.ArrayMarshalerFunc(func(ae zapcore.ArrayEncoder) error {
+			enc.Encode(ts, ae)
+			return nil
+		}))
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{tc.expect}, out.Fields["ts"])
+	}
+}
+
+func TestLogEncoder(t *testing.T) {
+	var (
+		buf      bytes.Buffer
+		leveller logLeveller
+	)
+	leveller.SetLevel(logrus.InfoLevel)
+
+	encodeTime := func(_ time.Time, enc zapcore.PrimitiveArrayEncoder) { enc.AppendString("now") }
+	encoder := newLogEncoder(&leveller, encodeTime, zapcore.AddSync(&buf))
+	logger := zap.New(encoder.Core()).With(zap.String("component", "tempo"))
+	child := logger.With(zap.String("tempo_config", "test"))
+
+	child.Info("logfmt")
+	require.NoError(t, encoder.SetFormat("json"))
+	child.Info("json")
+	child.Debug("filtered")
+	logger.Info("json")
+
+	require.EqualError(t, encoder.SetFormat("xml"), `unsupported log format "xml"`)
+	require.Equal(t, util.Untab(`
+ts=now level=info msg=logfmt component=tempo tempo_config=test
+{"level":"info","ts":"now","msg":"json","component":"tempo","tempo_config":"test"}
+{"level":"info","ts":"now","msg":"json","component":"tempo"}
+`), "\n"+buf.String())
+}
+
+func TestLogEncoder_Concurrent(t *testing.T) {
+	var leveller logLeveller
+	leveller.SetLevel(logrus.InfoLevel)
+
+	encoder := newLogEncoder(&leveller, zapcore.ISO8601TimeEncoder, zapcore.AddSync(ioutil.Discard))
+	logger := zap.New(encoder.Core()).With(zap.String("component", "tempo"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.With(zap.Int("j", j)).Info("message")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		format := "logfmt"
+		if i%2 == 0 {
+			format = "json"
+		}
+		require.NoError(t, encoder.SetFormat(format))
+	}
+	wg.Wait()
+}
+
+func TestTempo_SetLogFormat_WithLogger(t *testing.T) {
+	tempo, err := New(prometheus.NewRegistry(), Config{}, logrus.InfoLevel, WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	t.Cleanup(tempo.Stop)
+
+	require.Equal(t, ErrExternalLogger, tempo.SetLogFormat("json"))
+}
+
 func testJaegerTracer(t *testing.T) opentracing.Tracer {
 	t.Helper()
 
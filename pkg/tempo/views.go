@@ -0,0 +1,92 @@
+package tempo
+
+import (
+	"fmt"
+	"sync"
+
+	"go.opencensus.io/stats/view"
+	"go.opentelemetry.io/collector/config/configtelemetry"
+	"go.opentelemetry.io/collector/obsreport"
+	"go.opentelemetry.io/collector/processor"
+	"go.opentelemetry.io/collector/processor/batchprocessor"
+)
+
+// DefaultMetricsLevel is the MetricsLevel used when an InstanceConfig doesn't
+// set one.
+const DefaultMetricsLevel = "basic"
+
+// levelViews returns the collector views enabled at the given metrics level.
+// Each level enables the views of the levels below it.
+func levelViews(level configtelemetry.Level) []*view.View {
+	var views []*view.View
+	if level >= configtelemetry.LevelBasic {
+		views = append(views, obsreport.AllViews()...)
+	}
+	if level >= configtelemetry.LevelNormal {
+		views = append(views, batchprocessor.MetricViews()...)
+	}
+	if level >= configtelemetry.LevelDetailed {
+		views = append(views, processor.MetricViews()...)
+	}
+	return views
+}
+
+// sharedViews reference counts the views registered for instances. OpenCensus
+// views are process-wide, so a view is registered while the metrics level of
+// at least one instance enables it, and exported by every instance in the
+// meantime.
+var sharedViews = &viewRefs{refs: make(map[string]int)}
+
+type viewRefs struct {
+	mut  sync.Mutex
+	refs map[string]int
+}
+
+// acquire registers the views which aren't registered yet and takes a
+// reference to all of them. No reference is taken if any view fails to
+// register.
+func (r *viewRefs) acquire(views []*view.View) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var added []*view.View
+	for _, v := range views {
+		if r.refs[v.Name] > 0 {
+			continue
+		}
+		if err := view.Register(v); err != nil {
+			view.Unregister(added...)
+			return fmt.Errorf("failed to register view %s: %w", v.Name, err)
+		}
+		added = append(added, v)
+	}
+
+	for _, v := range views {
+		r.refs[v.Name]++
+	}
+	return nil
+}
+
+// release drops a reference to each of the views, unregistering those which
+// are no longer referenced.
+func (r *viewRefs) release(views []*view.View) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var removed []*view.View
+	for _, v := range views {
+		r.refs[v.Name]--
+		if r.refs[v.Name] <= 0 {
+			delete(r.refs, v.Name)
+			removed = append(removed, v)
+		}
+	}
+	view.Unregister(removed...)
+}
+
+// refCount returns the number of references to the view with the given name.
+func (r *viewRefs) refCount(name string) int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.refs[name]
+}
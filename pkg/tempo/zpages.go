@@ -0,0 +1,149 @@
+package tempo
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+// zpagesPrefix is the path under which zpages are served.
+const zpagesPrefix = "/debug/tempo/zpages"
+
+// zpagesSpansPerName is the number of recent spans tracez keeps for each span
+// name.
+const zpagesSpansPerName = 10
+
+// defaultSamplingProbability is the probability of the default OpenCensus
+// sampler, restored once no zpages are enabled anymore.
+const defaultSamplingProbability = 1e-4
+
+// sampledZPages counts the enabled zpages. OpenCensus only exports sampled
+// spans and its sampler is process-wide, so every span is sampled while at
+// least one zpages is enabled.
+var sampledZPages struct {
+	mut   sync.Mutex
+	count int
+}
+
+// zpages serves the tracez page, which shows the recent OpenCensus spans of
+// the collector components, such as the spans of receivers and exporters
+// reporting how many spans they accepted, refused or failed to send.
+//
+// Spans are only sampled and recorded while zpages is enabled, so operators
+// who don't enable it don't pay for it.
+type zpages struct {
+	mut     sync.Mutex
+	enabled bool
+	spans   map[string][]*trace.SpanData // Recent spans by name, oldest first
+	counts  map[string]int               // Spans recorded by name
+}
+
+func newZPages() *zpages {
+	return &zpages{}
+}
+
+// SetEnabled starts or stops recording spans. The recorded spans are dropped
+// when zpages is disabled.
+func (z *zpages) SetEnabled(enabled bool) {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+
+	if enabled == z.enabled {
+		return
+	}
+	z.enabled = enabled
+
+	sampledZPages.mut.Lock()
+	defer sampledZPages.mut.Unlock()
+
+	if enabled {
+		z.spans = make(map[string][]*trace.SpanData)
+		z.counts = make(map[string]int)
+		trace.RegisterExporter(z)
+
+		sampledZPages.count++
+		if sampledZPages.count == 1 {
+			trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+		}
+		return
+	}
+
+	trace.UnregisterExporter(z)
+	z.spans, z.counts = nil, nil
+
+	sampledZPages.count--
+	if sampledZPages.count == 0 {
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(defaultSamplingProbability)})
+	}
+}
+
+// Enabled returns whether spans are being recorded.
+func (z *zpages) Enabled() bool {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+	return z.enabled
+}
+
+// ExportSpan implements trace.Exporter.
+func (z *zpages) ExportSpan(s *trace.SpanData) {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+
+	if !z.enabled {
+		return
+	}
+	spans := append(z.spans[s.Name], s)
+	if len(spans) > zpagesSpansPerName {
+		spans = spans[len(spans)-zpagesSpansPerName:]
+	}
+	z.spans[s.Name] = spans
+	z.counts[s.Name]++
+}
+
+// ServeHTTP serves the tracez page under zpagesPrefix.
+func (z *zpages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != zpagesPrefix+"/tracez" {
+		http.NotFound(w, r)
+		return
+	}
+
+	z.mut.Lock()
+	names := make([]string, 0, len(z.spans))
+	for name := range z.spans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s (%d spans)\n", name, z.counts[name])
+		for _, s := range z.spans[name] {
+			fmt.Fprintf(&sb, "  %s duration=%s status=%d", s.StartTime.UTC().Format(time.RFC3339Nano), s.EndTime.Sub(s.StartTime), s.Code)
+			if s.Message != "" {
+				fmt.Fprintf(&sb, " message=%q", s.Message)
+			}
+			for _, k := range sortedAttributeKeys(s.Attributes) {
+				fmt.Fprintf(&sb, " %s=%v", k, s.Attributes[k])
+			}
+			sb.WriteString("\n")
+		}
+	}
+	z.mut.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(sb.String()))
+}
+
+func sortedAttributeKeys(attrs map[string]interface{}) []string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}